@@ -0,0 +1,284 @@
+// Package binser renders JSON document from Erlang internal
+// representation into CBOR or MessagePack, without going through JSON
+// text
+package binser
+
+import (
+	"fmt"
+	"math/big"
+	"unicode/utf8"
+
+	"github.com/pipedrive/uncouch/erldeser"
+	"github.com/pipedrive/uncouch/erlterm"
+	"github.com/pipedrive/uncouch/jsonser"
+)
+
+// ErrMalformedBody is returned when document body is not Erlang
+// serialised JSON
+var ErrMalformedBody = jsonser.ErrMalformedBody
+
+// Format is binary encoding documents are written in
+type Format int
+
+const (
+	// CBOR is Concise Binary Object Representation, RFC 8949
+	CBOR Format = iota
+	// MsgPack is MessagePack
+	MsgPack
+)
+
+// Field is top level field written into document before the fields
+// stored in the document
+type Field struct {
+	Key string
+	// Value is string, int64 or bool
+	Value interface{}
+}
+
+// emitter appends encoded values of single format
+type emitter interface {
+	appendMapHeader(dst []byte, n int) []byte
+	appendArrayHeader(dst []byte, n int) []byte
+	appendString(dst []byte, s []byte) []byte
+	appendInt(dst []byte, i int64) []byte
+	appendBig(dst []byte, b *big.Int) []byte
+	appendFloat(dst []byte, f float64) []byte
+	appendBool(dst []byte, b bool) []byte
+	appendNull(dst []byte) []byte
+}
+
+// emitters are emitters of formats
+var emitters = map[Format]emitter{
+	CBOR:    cborEmitter{},
+	MsgPack: msgpackEmitter{},
+}
+
+// Encoder encodes Erlang serialised JSON read by the scanner. Objects are
+// maps with string keys, arrays are arrays, numbers are integers or
+// float64, strings are text strings and true, false and null their
+// native values.
+type Encoder struct {
+	s      *erldeser.Scanner
+	t      erlterm.Term
+	em     emitter
+	policy jsonser.UTF8Policy
+	// scratch is reused for strings with invalid UTF-8
+	scratch []byte
+}
+
+// NewEncoder returns encoder of format reading from the scanner
+func NewEncoder(s *erldeser.Scanner, format Format) (*Encoder, error) {
+	var (
+		newEncoder Encoder
+	)
+	em, ok := emitters[format]
+	if !ok {
+		return nil, fmt.Errorf("Unknown binary format %d", format)
+	}
+	e := &newEncoder
+	e.s = s
+	e.em = em
+	e.t.Reset()
+	return e, nil
+}
+
+// SetUTF8Policy sets how invalid UTF-8 in keys and strings is encoded,
+// UTF8Replace is used by default
+func (e *Encoder) SetUTF8Policy(policy jsonser.UTF8Policy) {
+	e.policy = policy
+}
+
+// AppendDocument appends document object to dst. Fields are written at
+// the beginning of the object, replacing document fields with the same
+// keys.
+func (e *Encoder) AppendDocument(dst []byte, fields []Field) ([]byte, error) {
+	err := e.s.Scan(&e.t)
+	if err != nil {
+		return dst, err
+	}
+	if e.t.Term != erldeser.SmallTupleExt {
+		return dst, fmt.Errorf("%w: document should be JSON object, we got %v", ErrMalformedBody, erldeser.TypeName(e.t.Term))
+	}
+	length, err := e.objectLength()
+	if err != nil {
+		return dst, err
+	}
+	if len(fields) == 0 {
+		dst = e.em.appendMapHeader(dst, length)
+		dst, _, err = e.appendPairs(dst, length, nil)
+		return dst, err
+	}
+	// Number of pairs is known only after skipping the ones replaced by
+	// fields, so they are encoded before the header
+	pairs, written, err := e.appendPairs(nil, length, fields)
+	if err != nil {
+		return dst, err
+	}
+	dst = e.em.appendMapHeader(dst, len(fields)+written)
+	for _, f := range fields {
+		dst = e.em.appendString(dst, []byte(f.Key))
+		switch v := f.Value.(type) {
+		case string:
+			dst = e.em.appendString(dst, []byte(v))
+		case int64:
+			dst = e.em.appendInt(dst, v)
+		case bool:
+			dst = e.em.appendBool(dst, v)
+		default:
+			return dst, fmt.Errorf("Unsupported value of field %q: %T", f.Key, f.Value)
+		}
+	}
+	return append(dst, pairs...), nil
+}
+
+// objectLength reads list of {[{Key, Value}]} object whose tuple header
+// was read last and returns number of pairs
+func (e *Encoder) objectLength() (int, error) {
+	err := e.s.Scan(&e.t)
+	if err != nil {
+		return 0, err
+	}
+	switch e.t.Term {
+	case erldeser.ListExt:
+		return int(e.t.IntegerValue), nil
+	case erldeser.NilExt:
+		return 0, nil
+	}
+	return 0, fmt.Errorf("%w: Erlang serialised JSON object should start as tuple containing list, we got %v", ErrMalformedBody, erldeser.TypeName(e.t.Term))
+}
+
+// appendPairs appends length key-value pairs of object and the list tail.
+// Pairs with key in fields are skipped, it returns number of pairs written.
+func (e *Encoder) appendPairs(dst []byte, length int, fields []Field) ([]byte, int, error) {
+	written := 0
+	for i := 0; i < length; i++ {
+		err := e.s.Scan(&e.t)
+		if err != nil {
+			return dst, written, err
+		}
+		if e.t.Term != erldeser.SmallTupleExt {
+			return dst, written, fmt.Errorf("%w: Erlang serialised JSON key-value pair should be inside tuple, we got %v", ErrMalformedBody, erldeser.TypeName(e.t.Term))
+		}
+		err = e.s.Scan(&e.t)
+		if err != nil {
+			return dst, written, err
+		}
+		if e.t.Term != erldeser.BinaryExt {
+			return dst, written, fmt.Errorf("%w: Erlang serialised JSON key should be binary, we got %v", ErrMalformedBody, erldeser.TypeName(e.t.Term))
+		}
+		if hasField(fields, e.t.Binary) {
+			// Value is encoded and dropped
+			_, err = e.appendValue(nil)
+			if err != nil {
+				return dst, written, err
+			}
+			continue
+		}
+		dst, err = e.appendString(dst, e.t.Binary)
+		if err != nil {
+			return dst, written, err
+		}
+		dst, err = e.appendValue(dst)
+		if err != nil {
+			return dst, written, err
+		}
+		written++
+	}
+	if length > 0 {
+		return dst, written, e.listTail()
+	}
+	return dst, written, nil
+}
+
+// hasField tells if key is one of the fields
+func hasField(fields []Field, key []byte) bool {
+	for i := range fields {
+		if fields[i].Key == string(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// appendValue reads single value from the scanner and appends it to dst
+func (e *Encoder) appendValue(dst []byte) ([]byte, error) {
+	err := e.s.Scan(&e.t)
+	if err != nil {
+		return dst, err
+	}
+	t := &e.t
+	switch t.Term {
+	case erldeser.NewFloatExt:
+		return e.em.appendFloat(dst, t.FloatValue), nil
+	case erldeser.SmallIntegerExt, erldeser.IntegerExt:
+		return e.em.appendInt(dst, t.IntegerValue), nil
+	case erldeser.SmallBigExt, erldeser.LargeBigExt:
+		return e.em.appendBig(dst, t.BigValue), nil
+	case erldeser.AtomExt:
+		switch string(t.Binary) {
+		case "true":
+			return e.em.appendBool(dst, true), nil
+		case "false":
+			return e.em.appendBool(dst, false), nil
+		case "null":
+			return e.em.appendNull(dst), nil
+		}
+		return dst, fmt.Errorf("%w: unexpected atom %q", ErrMalformedBody, t.Binary)
+	case erldeser.BinaryExt:
+		return e.appendString(dst, t.Binary)
+	case erldeser.StringExt:
+		// Actually array of small integers
+		dst = e.em.appendArrayHeader(dst, len(t.Binary))
+		for _, b := range t.Binary {
+			dst = e.em.appendInt(dst, int64(b))
+		}
+		return dst, nil
+	case erldeser.NilExt:
+		return e.em.appendArrayHeader(dst, 0), nil
+	case erldeser.ListExt:
+		length := int(t.IntegerValue)
+		dst = e.em.appendArrayHeader(dst, length)
+		for i := 0; i < length; i++ {
+			dst, err = e.appendValue(dst)
+			if err != nil {
+				return dst, err
+			}
+		}
+		return dst, e.listTail()
+	case erldeser.SmallTupleExt:
+		length, err := e.objectLength()
+		if err != nil {
+			return dst, err
+		}
+		dst = e.em.appendMapHeader(dst, length)
+		dst, _, err = e.appendPairs(dst, length, nil)
+		return dst, err
+	}
+	return dst, fmt.Errorf("%w: Don't know how to turn type %v into value", ErrMalformedBody, erldeser.TypeName(t.Term))
+}
+
+// appendString appends binary as text string, handling invalid UTF-8 by
+// policy
+func (e *Encoder) appendString(dst []byte, b []byte) ([]byte, error) {
+	if utf8.Valid(b) {
+		return e.em.appendString(dst, b), nil
+	}
+	var err error
+	e.scratch, err = jsonser.AppendValidUTF8(e.scratch[:0], b, e.policy)
+	if err != nil {
+		return dst, err
+	}
+	return e.em.appendString(dst, e.scratch), nil
+}
+
+// listTail reads nil at the end of non-empty list
+func (e *Encoder) listTail() error {
+	err := e.s.Scan(&e.t)
+	if err != nil {
+		return err
+	}
+	if e.t.Term != erldeser.NilExt {
+		return fmt.Errorf("%w: Erlang serialised list should end with extra nil, but ends with %v", ErrMalformedBody, erldeser.TypeName(e.t.Term))
+	}
+	return nil
+}
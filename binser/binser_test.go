@@ -0,0 +1,406 @@
+package binser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/pipedrive/uncouch/erldeser"
+	"github.com/vmihailenco/msgpack"
+)
+
+// Builders of Erlang serialised JSON used by the tests
+
+func etfBinary(s string) []byte {
+	b := binary.BigEndian.AppendUint32([]byte{byte(erldeser.BinaryExt)}, uint32(len(s)))
+	return append(b, s...)
+}
+
+func etfAtom(s string) []byte {
+	b := binary.BigEndian.AppendUint16([]byte{byte(erldeser.AtomExt)}, uint16(len(s)))
+	return append(b, s...)
+}
+
+func etfFloat(f float64) []byte {
+	return binary.BigEndian.AppendUint64([]byte{byte(erldeser.NewFloatExt)}, math.Float64bits(f))
+}
+
+// etfString returns STRING_EXT, the way lists of small integers are stored
+func etfString(s string) []byte {
+	b := binary.BigEndian.AppendUint16([]byte{byte(erldeser.StringExt)}, uint16(len(s)))
+	return append(b, s...)
+}
+
+// etfInteger returns the smallest integer term holding n
+func etfInteger(n *big.Int) []byte {
+	switch {
+	case n.IsInt64() && n.Int64() >= 0 && n.Int64() < 256:
+		return []byte{byte(erldeser.SmallIntegerExt), byte(n.Int64())}
+	case n.IsInt64() && n.Int64() >= math.MinInt32 && n.Int64() <= math.MaxInt32:
+		return binary.BigEndian.AppendUint32([]byte{byte(erldeser.IntegerExt)}, uint32(n.Int64()))
+	}
+	digits := new(big.Int).Abs(n).Bytes()
+	b := []byte{byte(erldeser.SmallBigExt), byte(len(digits)), 0}
+	if n.Sign() < 0 {
+		b[2] = 1
+	}
+	for i := len(digits) - 1; i >= 0; i-- {
+		b = append(b, digits[i])
+	}
+	return b
+}
+
+func etfList(elements ...[]byte) []byte {
+	if len(elements) == 0 {
+		return []byte{byte(erldeser.NilExt)}
+	}
+	b := binary.BigEndian.AppendUint32([]byte{byte(erldeser.ListExt)}, uint32(len(elements)))
+	for _, e := range elements {
+		b = append(b, e...)
+	}
+	return append(b, byte(erldeser.NilExt))
+}
+
+// etfObject returns {[{Key, Value}]} of alternating keys and values
+func etfObject(pairs ...[]byte) []byte {
+	var tuples [][]byte
+	for i := 0; i+1 < len(pairs); i += 2 {
+		tuple := append([]byte{byte(erldeser.SmallTupleExt), 2}, pairs[i]...)
+		tuples = append(tuples, append(tuple, pairs[i+1]...))
+	}
+	return append([]byte{byte(erldeser.SmallTupleExt), 1}, etfList(tuples...)...)
+}
+
+// bigInt returns integer of decimal text
+func bigInt(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("invalid integer " + s)
+	}
+	return n
+}
+
+// formats are encoded formats with their reference decoders
+var formats = []struct {
+	name   string
+	format Format
+	// decode reads all values of data, one after another
+	decode func(data []byte) ([]interface{}, error)
+}{
+	{"cbor", CBOR, func(data []byte) ([]interface{}, error) {
+		return decodeAll(func(r io.Reader) func(interface{}) error { return cbor.NewDecoder(r).Decode }, data)
+	}},
+	{"msgpack", MsgPack, func(data []byte) ([]interface{}, error) {
+		return decodeAll(func(r io.Reader) func(interface{}) error { return msgpack.NewDecoder(r).Decode }, data)
+	}},
+}
+
+// decodeAll decodes values of data until it ends
+func decodeAll(newDecoder func(io.Reader) func(interface{}) error, data []byte) ([]interface{}, error) {
+	decode := newDecoder(bytes.NewReader(data))
+	var values []interface{}
+	for {
+		var v interface{}
+		err := decode(&v)
+		if err == io.EOF {
+			return values, nil
+		}
+		if err != nil {
+			return values, err
+		}
+		values = append(values, normalize(v))
+	}
+}
+
+// normalize turns values decoded by reference decoders into integers as
+// json.Number, float64, string, bool, nil, []interface{} and
+// map[string]interface{}
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int8, int16, int32, int64, int, uint8, uint16, uint32, uint64, uint:
+		return json.Number(fmt.Sprint(v))
+	case big.Int:
+		return json.Number(v.String())
+	case *big.Int:
+		return json.Number(v.String())
+	case float32:
+		return float64(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = normalize(v[i])
+		}
+		return v
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = normalize(value)
+		}
+		return m
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = normalize(value)
+		}
+		return v
+	}
+	return v
+}
+
+// encodeDocument encodes Erlang serialised document in input
+func encodeDocument(t *testing.T, input []byte, format Format, fields []Field) []byte {
+	t.Helper()
+	s, err := erldeser.NewScanner(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewEncoder(s, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := e.AppendDocument(nil, fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestValuesMatchReferenceDecoders(t *testing.T) {
+	n := func(s string) json.Number { return json.Number(s) }
+	integers := []string{
+		"0", "23", "24", "127", "128", "255", "256", "65535", "65536",
+		"2147483647", "4294967295", "4294967296", "9223372036854775807",
+		"9223372036854775808", "18446744073709551615",
+		"-1", "-24", "-25", "-32", "-33", "-128", "-129", "-256", "-257",
+		"-32768", "-32769", "-65536", "-65537", "-2147483648", "-2147483649",
+		"-4294967296", "-4294967297", "-9223372036854775808",
+	}
+	tests := []struct {
+		name  string
+		input []byte
+		want  interface{}
+		// bigString is set when MessagePack has no integer type for the
+		// value and writes it as decimal string
+		bigString bool
+	}{
+		{"float", etfFloat(1.5), 1.5, false},
+		{"negative float", etfFloat(-2.5e-10), -2.5e-10, false},
+		{"true", etfAtom("true"), true, false},
+		{"false", etfAtom("false"), false, false},
+		{"null", etfAtom("null"), nil, false},
+		{"empty string", etfBinary(""), "", false},
+		{"utf8 string", etfBinary("ümlaut"), "ümlaut", false},
+		{"invalid utf8 replaced", etfBinary("a\xffb"), "a�b", false},
+		{"small integer list", etfString("\x01\x02\xff"), []interface{}{n("1"), n("2"), n("255")}, false},
+		{"empty list", etfList(), []interface{}{}, false},
+		{"empty object", etfObject(), map[string]interface{}{}, false},
+		{"nested", etfObject(etfBinary("a"), etfList(etfObject(etfBinary("b"), etfAtom("null")), etfFloat(0.5))),
+			map[string]interface{}{"a": []interface{}{map[string]interface{}{"b": nil}, 0.5}}, false},
+		{"uint64 overflow", etfInteger(bigInt("18446744073709551616")), n("18446744073709551616"), true},
+		// CBOR stores -1-n, n of -18446744073709551616 still fits uint64
+		{"negative below int64", etfInteger(bigInt("-9223372036854775809")), n("-9223372036854775809"), true},
+		{"negative uint64 argument", etfInteger(bigInt("-18446744073709551616")), n("-18446744073709551616"), true},
+		{"negative bignum", etfInteger(bigInt("-18446744073709551617")), n("-18446744073709551617"), true},
+		{"huge bignum", etfInteger(bigInt("123456789012345678901234567890")), n("123456789012345678901234567890"), true},
+		{"huge negative bignum", etfInteger(bigInt("-123456789012345678901234567890")), n("-123456789012345678901234567890"), true},
+	}
+	for _, i := range integers {
+		tests = append(tests, struct {
+			name      string
+			input     []byte
+			want      interface{}
+			bigString bool
+		}{"integer " + i, etfInteger(bigInt(i)), n(i), false})
+	}
+	for _, f := range formats {
+		for _, tt := range tests {
+			t.Run(f.name+" "+tt.name, func(t *testing.T) {
+				encoded := encodeDocument(t, etfObject(etfBinary("v"), tt.input), f.format, nil)
+				values, err := f.decode(encoded)
+				if err != nil {
+					t.Fatalf("%v decoding % x", err, encoded)
+				}
+				want := tt.want
+				if tt.bigString && f.format == MsgPack {
+					want = string(want.(json.Number))
+				}
+				if !reflect.DeepEqual(values, []interface{}{map[string]interface{}{"v": want}}) {
+					t.Errorf("got %#v from % x, want %#v", values, encoded, want)
+				}
+			})
+		}
+	}
+}
+
+func TestHeaderWidths(t *testing.T) {
+	tests := []struct {
+		length int
+		// Header sizes of strings, arrays and maps
+		cbor         int
+		msgpackStr   int
+		msgpackArray int
+	}{
+		{0, 1, 1, 1},
+		{15, 1, 1, 1},
+		{16, 1, 1, 3},
+		{23, 1, 1, 3},
+		{24, 2, 1, 3},
+		{31, 2, 1, 3},
+		{32, 2, 2, 3},
+		{255, 2, 2, 3},
+		{256, 3, 3, 3},
+		{65535, 3, 3, 3},
+		{65536, 5, 5, 5},
+	}
+	for _, f := range formats {
+		em := emitters[f.format]
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s %d", f.name, tt.length), func(t *testing.T) {
+				strHead, arrayHead := tt.cbor, tt.cbor
+				if f.format == MsgPack {
+					strHead, arrayHead = tt.msgpackStr, tt.msgpackArray
+				}
+				s := strings.Repeat("x", tt.length)
+				encoded := em.appendString(nil, []byte(s))
+				if len(encoded) != strHead+tt.length {
+					t.Errorf("string header of %d bytes, want %d", len(encoded)-tt.length, strHead)
+				}
+				values, err := f.decode(encoded)
+				if err != nil || !reflect.DeepEqual(values, []interface{}{s}) {
+					t.Errorf("string decoded with %v", err)
+				}
+
+				array := em.appendArrayHeader(nil, tt.length)
+				object := em.appendMapHeader(nil, tt.length)
+				want := make([]interface{}, tt.length)
+				wantMap := make(map[string]interface{}, tt.length)
+				for i := 0; i < tt.length; i++ {
+					array = em.appendNull(array)
+					object = em.appendString(object, []byte(fmt.Sprint(i)))
+					object = em.appendNull(object)
+					want[i] = nil
+					wantMap[fmt.Sprint(i)] = nil
+				}
+				if len(array) != arrayHead+tt.length {
+					t.Errorf("array header of %d bytes, want %d", len(array)-tt.length, arrayHead)
+				}
+				values, err = f.decode(array)
+				if err != nil || !reflect.DeepEqual(values, []interface{}{want}) {
+					t.Errorf("array decoded with %v", err)
+				}
+				values, err = f.decode(object)
+				if err != nil || !reflect.DeepEqual(values, []interface{}{wantMap}) {
+					t.Errorf("map decoded with %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestIntegerWidths(t *testing.T) {
+	tests := []struct {
+		value   int64
+		cbor    int
+		msgpack int
+	}{
+		{0, 1, 1},
+		{23, 1, 1},
+		{24, 2, 1},
+		{127, 2, 1},
+		{128, 2, 2},
+		{255, 2, 2},
+		{256, 3, 3},
+		{65535, 3, 3},
+		{65536, 5, 5},
+		{math.MaxUint32, 5, 5},
+		{math.MaxUint32 + 1, 9, 9},
+		{-1, 1, 1},
+		{-24, 1, 1},
+		{-25, 2, 1},
+		{-32, 2, 1},
+		{-33, 2, 2},
+		{-128, 2, 2},
+		{-129, 2, 3},
+		{-256, 2, 3},
+		{-257, 3, 3},
+		{math.MinInt16, 3, 3},
+		{math.MinInt16 - 1, 3, 5},
+		{-65537, 5, 5},
+		{math.MinInt32, 5, 5},
+		{math.MinInt32 - 1, 5, 9},
+		{math.MinInt64, 9, 9},
+	}
+	for _, f := range formats {
+		for _, tt := range tests {
+			encoded := emitters[f.format].appendInt(nil, tt.value)
+			want := tt.cbor
+			if f.format == MsgPack {
+				want = tt.msgpack
+			}
+			if len(encoded) != want {
+				t.Errorf("%s %d takes %d bytes, want %d", f.name, tt.value, len(encoded), want)
+			}
+			values, err := f.decode(encoded)
+			if err != nil || !reflect.DeepEqual(values, []interface{}{json.Number(fmt.Sprint(tt.value))}) {
+				t.Errorf("%s %d decoded as %v, %v", f.name, tt.value, values, err)
+			}
+		}
+	}
+}
+
+func TestAppendDocumentFields(t *testing.T) {
+	fields := []Field{
+		{Key: "_id", Value: "doc"},
+		{Key: "_rev", Value: "1-r"},
+		{Key: "_seq", Value: int64(7)},
+		{Key: "_deleted", Value: false},
+	}
+	n := func(s string) json.Number { return json.Number(s) }
+	// Keys k0 to k13 take the pair count of 18 above MessagePack fixmap
+	var pairs [][]byte
+	many := map[string]interface{}{"_id": "doc", "_rev": "1-r", "_seq": n("7"), "_deleted": false}
+	for i := 0; i < 14; i++ {
+		key := fmt.Sprintf("k%d", i)
+		pairs = append(pairs, etfBinary(key), etfInteger(big.NewInt(int64(i))))
+		many[key] = n(fmt.Sprint(i))
+	}
+	tests := []struct {
+		name  string
+		input []byte
+		want  map[string]interface{}
+	}{
+		{"no fields replaced", etfObject(etfBinary("a"), etfInteger(big.NewInt(1))),
+			map[string]interface{}{"_id": "doc", "_rev": "1-r", "_seq": n("7"), "_deleted": false, "a": n("1")}},
+		{"fields replace keys", etfObject(etfBinary("_id"), etfBinary("body"), etfBinary("a"), etfObject(),
+			etfBinary("_rev"), etfList(etfBinary("x")), etfBinary("b"), etfAtom("true")),
+			map[string]interface{}{"_id": "doc", "_rev": "1-r", "_seq": n("7"), "_deleted": false,
+				"a": map[string]interface{}{}, "b": true}},
+		{"only replaced keys", etfObject(etfBinary("_id"), etfBinary("body")),
+			map[string]interface{}{"_id": "doc", "_rev": "1-r", "_seq": n("7"), "_deleted": false}},
+		{"empty document", etfObject(),
+			map[string]interface{}{"_id": "doc", "_rev": "1-r", "_seq": n("7"), "_deleted": false}},
+		{"more than fixmap", etfObject(append(pairs, etfBinary("_seq"), etfInteger(big.NewInt(1)))...), many},
+	}
+	for _, f := range formats {
+		for _, tt := range tests {
+			t.Run(f.name+" "+tt.name, func(t *testing.T) {
+				// Wrong pair count would misalign the second document
+				encoded := encodeDocument(t, tt.input, f.format, fields)
+				encoded = append(encoded, encodeDocument(t, tt.input, f.format, fields)...)
+				values, err := f.decode(encoded)
+				if err != nil {
+					t.Fatalf("%v decoding % x", err, encoded)
+				}
+				if !reflect.DeepEqual(values, []interface{}{tt.want, tt.want}) {
+					t.Errorf("got %v, want two of %v", values, tt.want)
+				}
+			})
+		}
+	}
+}
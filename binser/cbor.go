@@ -0,0 +1,110 @@
+package binser
+
+import (
+	"encoding/binary"
+	"math"
+	"math/big"
+)
+
+// CBOR major types
+const (
+	cborUnsigned byte = 0 << 5
+	cborNegative byte = 1 << 5
+	cborBytes    byte = 2 << 5
+	cborText     byte = 3 << 5
+	cborArray    byte = 4 << 5
+	cborMap      byte = 5 << 5
+	cborTag      byte = 6 << 5
+)
+
+// CBOR simple values, float64 and bignum tags
+const (
+	cborFalse     byte = 0xf4
+	cborTrue      byte = 0xf5
+	cborNull      byte = 0xf6
+	cborFloat64   byte = 0xfb
+	cborPosBignum      = 2
+	cborNegBignum      = 3
+)
+
+// cborEmitter appends CBOR data items with definite lengths, so
+// documents written one after another form CBOR Sequence, RFC 8742
+type cborEmitter struct{}
+
+// appendHead appends head of major type with argument in shortest form
+func (cborEmitter) appendHead(dst []byte, major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(dst, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(dst, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(dst, major|25), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(dst, major|26), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(dst, major|27), n)
+}
+
+// appendMapHeader implements emitter
+func (c cborEmitter) appendMapHeader(dst []byte, n int) []byte {
+	return c.appendHead(dst, cborMap, uint64(n))
+}
+
+// appendArrayHeader implements emitter
+func (c cborEmitter) appendArrayHeader(dst []byte, n int) []byte {
+	return c.appendHead(dst, cborArray, uint64(n))
+}
+
+// appendString implements emitter
+func (c cborEmitter) appendString(dst []byte, s []byte) []byte {
+	return append(c.appendHead(dst, cborText, uint64(len(s))), s...)
+}
+
+// appendInt implements emitter
+func (c cborEmitter) appendInt(dst []byte, i int64) []byte {
+	if i < 0 {
+		return c.appendHead(dst, cborNegative, uint64(-1-i))
+	}
+	return c.appendHead(dst, cborUnsigned, uint64(i))
+}
+
+// appendBig appends integers up to 64 bits as integers and bigger ones
+// as bignums
+func (c cborEmitter) appendBig(dst []byte, b *big.Int) []byte {
+	if b.Sign() >= 0 {
+		if b.IsUint64() {
+			return c.appendHead(dst, cborUnsigned, b.Uint64())
+		}
+		dst = c.appendHead(dst, cborTag, cborPosBignum)
+		bytes := b.Bytes()
+		return append(c.appendHead(dst, cborBytes, uint64(len(bytes))), bytes...)
+	}
+	// Negative integers are stored as -1 - n
+	n := new(big.Int).Neg(b)
+	n.Sub(n, big.NewInt(1))
+	if n.IsUint64() {
+		return c.appendHead(dst, cborNegative, n.Uint64())
+	}
+	dst = c.appendHead(dst, cborTag, cborNegBignum)
+	bytes := n.Bytes()
+	return append(c.appendHead(dst, cborBytes, uint64(len(bytes))), bytes...)
+}
+
+// appendFloat implements emitter
+func (cborEmitter) appendFloat(dst []byte, f float64) []byte {
+	return binary.BigEndian.AppendUint64(append(dst, cborFloat64), math.Float64bits(f))
+}
+
+// appendBool implements emitter
+func (cborEmitter) appendBool(dst []byte, b bool) []byte {
+	if b {
+		return append(dst, cborTrue)
+	}
+	return append(dst, cborFalse)
+}
+
+// appendNull implements emitter
+func (cborEmitter) appendNull(dst []byte) []byte {
+	return append(dst, cborNull)
+}
@@ -0,0 +1,138 @@
+package binser
+
+import (
+	"encoding/binary"
+	"math"
+	"math/big"
+)
+
+// MessagePack format bytes
+const (
+	msgpackFixMap   byte = 0x80
+	msgpackFixArray byte = 0x90
+	msgpackFixStr   byte = 0xa0
+	msgpackNil      byte = 0xc0
+	msgpackFalse    byte = 0xc2
+	msgpackTrue     byte = 0xc3
+	msgpackFloat64  byte = 0xcb
+	msgpackUint8    byte = 0xcc
+	msgpackUint16   byte = 0xcd
+	msgpackUint32   byte = 0xce
+	msgpackUint64   byte = 0xcf
+	msgpackInt8     byte = 0xd0
+	msgpackInt16    byte = 0xd1
+	msgpackInt32    byte = 0xd2
+	msgpackInt64    byte = 0xd3
+	msgpackStr8     byte = 0xd9
+	msgpackStr16    byte = 0xda
+	msgpackStr32    byte = 0xdb
+	msgpackArray16  byte = 0xdc
+	msgpackArray32  byte = 0xdd
+	msgpackMap16    byte = 0xde
+	msgpackMap32    byte = 0xdf
+)
+
+// msgpackEmitter appends MessagePack objects, documents written one after
+// another form MessagePack stream
+type msgpackEmitter struct{}
+
+// appendLength appends header of collection in shortest form
+func (msgpackEmitter) appendLength(dst []byte, n int, fix, with16, with32 byte) []byte {
+	switch {
+	case n < 16:
+		return append(dst, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(dst, with16), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(dst, with32), uint32(n))
+}
+
+// appendMapHeader implements emitter
+func (m msgpackEmitter) appendMapHeader(dst []byte, n int) []byte {
+	return m.appendLength(dst, n, msgpackFixMap, msgpackMap16, msgpackMap32)
+}
+
+// appendArrayHeader implements emitter
+func (m msgpackEmitter) appendArrayHeader(dst []byte, n int) []byte {
+	return m.appendLength(dst, n, msgpackFixArray, msgpackArray16, msgpackArray32)
+}
+
+// appendString implements emitter
+func (msgpackEmitter) appendString(dst []byte, s []byte) []byte {
+	n := len(s)
+	switch {
+	case n < 32:
+		dst = append(dst, msgpackFixStr|byte(n))
+	case n <= math.MaxUint8:
+		dst = append(dst, msgpackStr8, byte(n))
+	case n <= math.MaxUint16:
+		dst = binary.BigEndian.AppendUint16(append(dst, msgpackStr16), uint16(n))
+	default:
+		dst = binary.BigEndian.AppendUint32(append(dst, msgpackStr32), uint32(n))
+	}
+	return append(dst, s...)
+}
+
+// appendInt implements emitter
+func (m msgpackEmitter) appendInt(dst []byte, i int64) []byte {
+	switch {
+	case i >= 0:
+		return m.appendUint(dst, uint64(i))
+	case i >= -32:
+		// negative fixint
+		return append(dst, byte(i))
+	case i >= math.MinInt8:
+		return append(dst, msgpackInt8, byte(i))
+	case i >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(dst, msgpackInt16), uint16(i))
+	case i >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(dst, msgpackInt32), uint32(i))
+	}
+	return binary.BigEndian.AppendUint64(append(dst, msgpackInt64), uint64(i))
+}
+
+// appendUint appends non-negative integer in shortest form
+func (msgpackEmitter) appendUint(dst []byte, u uint64) []byte {
+	switch {
+	case u <= math.MaxInt8:
+		// positive fixint
+		return append(dst, byte(u))
+	case u <= math.MaxUint8:
+		return append(dst, msgpackUint8, byte(u))
+	case u <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(dst, msgpackUint16), uint16(u))
+	case u <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(dst, msgpackUint32), uint32(u))
+	}
+	return binary.BigEndian.AppendUint64(append(dst, msgpackUint64), u)
+}
+
+// appendBig appends integers fitting int64 or uint64 as integers. Bigger
+// ones have no MessagePack type, they are written as decimal strings.
+func (m msgpackEmitter) appendBig(dst []byte, b *big.Int) []byte {
+	if b.IsInt64() {
+		return m.appendInt(dst, b.Int64())
+	}
+	if b.IsUint64() {
+		return m.appendUint(dst, b.Uint64())
+	}
+	return m.appendString(dst, []byte(b.String()))
+}
+
+// appendFloat implements emitter
+func (msgpackEmitter) appendFloat(dst []byte, f float64) []byte {
+	return binary.BigEndian.AppendUint64(append(dst, msgpackFloat64), math.Float64bits(f))
+}
+
+// appendBool implements emitter
+func (msgpackEmitter) appendBool(dst []byte, b bool) []byte {
+	if b {
+		return append(dst, msgpackTrue)
+	}
+	return append(dst, msgpackFalse)
+}
+
+// appendNull implements emitter
+func (msgpackEmitter) appendNull(dst []byte) []byte {
+	return append(dst, msgpackNil)
+}
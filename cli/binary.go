@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/pipedrive/uncouch/binser"
+	"github.com/pipedrive/uncouch/couchdbfile"
+	"github.com/pipedrive/uncouch/leakybucket"
+)
+
+// binaryWriter writes documents as CBOR or MessagePack maps one after
+// another. Each map is self-delimiting, with --length-prefix it is
+// preceded by its length as 4 byte big endian integer, so readers can
+// skip documents without decoding them.
+type binaryWriter struct {
+	cf           *couchdbfile.CouchDbFile
+	w            io.Writer
+	format       binser.Format
+	dbName       string
+	lengthPrefix bool
+	buf          *bytes.Buffer
+}
+
+// newCBORWriter returns writer of cbor format
+func newCBORWriter(out *dataOutput) (documentWriter, error) {
+	return newBinaryWriter(out, binser.CBOR)
+}
+
+// newMsgPackWriter returns writer of msgpack format
+func newMsgPackWriter(out *dataOutput) (documentWriter, error) {
+	return newBinaryWriter(out, binser.MsgPack)
+}
+
+// newBinaryWriter returns binaryWriter of format
+func newBinaryWriter(out *dataOutput, format binser.Format) (documentWriter, error) {
+	var (
+		newWriter binaryWriter
+	)
+	bw := &newWriter
+	bw.cf = out.cf
+	bw.w = out.w
+	bw.format = format
+	bw.dbName = out.dbName
+	lengthPrefix, err := out.cmd.Flags().GetBool("length-prefix")
+	if err != nil {
+		return nil, err
+	}
+	bw.lengthPrefix = lengthPrefix
+	bw.buf = leakybucket.GetBuffer()
+	return bw, nil
+}
+
+// writeDocument implements documentWriter
+func (bw *binaryWriter) writeDocument(di *couchdbfile.DocumentInfo) error {
+	bw.buf.Reset()
+	if bw.lengthPrefix {
+		bw.buf.Write(make([]byte, 4))
+	}
+	// Document is encoded into buffer first, so failing document leaves
+	// no partial document in the output
+	err := bw.cf.EncodeDocument(di, bw.buf, bw.format,
+		binser.Field{Key: "_id", Value: string(di.ID)},
+		binser.Field{Key: "_db", Value: bw.dbName},
+		binser.Field{Key: "_deleted", Value: int64(di.Deleted)})
+	if err != nil {
+		return err
+	}
+	if bw.lengthPrefix {
+		encoded := bw.buf.Bytes()
+		binary.BigEndian.PutUint32(encoded, uint32(len(encoded)-4))
+	}
+	_, err = bw.buf.WriteTo(bw.w)
+	return err
+}
+
+// close implements documentWriter
+func (bw *binaryWriter) close() error {
+	leakybucket.PutBuffer(bw.buf)
+	bw.buf = nil
+	return nil
+}
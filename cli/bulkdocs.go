@@ -0,0 +1,256 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pipedrive/uncouch/couchdbfile"
+	"github.com/pipedrive/uncouch/jsonser"
+	"github.com/pipedrive/uncouch/leakybucket"
+)
+
+// bulkDocsWriter writes documents as CouchDB _bulk_docs requests with
+// new_edits false, so restoring them keeps revision history. Each batch
+// is single line, or its own file when output is a directory.
+type bulkDocsWriter struct {
+	cf        *couchdbfile.CouchDbFile
+	w         io.Writer
+	dbName    string
+	dir       string
+	batchSize int
+	// file is batch file being written, when writing into directory
+	file    *os.File
+	fileW   *bufio.Writer
+	batches int
+	inBatch int
+	buf     *bytes.Buffer
+}
+
+// newBulkDocsWriter returns writer of bulk-docs format
+func newBulkDocsWriter(out *dataOutput) (documentWriter, error) {
+	var (
+		newWriter bulkDocsWriter
+	)
+	bw := &newWriter
+	bw.cf = out.cf
+	bw.w = out.w
+	bw.dbName = out.dbName
+	bw.dir = out.dir
+	batchSize, err := out.cmd.Flags().GetInt("batch-size")
+	if err != nil {
+		return nil, err
+	}
+	if batchSize < 1 {
+		return nil, fmt.Errorf("Batch size has to be positive, got %d", batchSize)
+	}
+	bw.batchSize = batchSize
+	bw.buf = leakybucket.GetBuffer()
+	return bw, nil
+}
+
+// writeDocument implements documentWriter
+func (bw *bulkDocsWriter) writeDocument(di *couchdbfile.DocumentInfo) error {
+	bw.buf.Reset()
+	// Document is rendered into buffer first, so failing document leaves
+	// no partial document in the batch
+	err := bw.renderDocument(di)
+	if err != nil {
+		return err
+	}
+	if bw.inBatch == 0 {
+		err = bw.startBatch()
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(bw.w, `{"docs":[`)
+	} else {
+		_, err = io.WriteString(bw.w, ",")
+	}
+	if err != nil {
+		return err
+	}
+	_, err = bw.buf.WriteTo(bw.w)
+	if err != nil {
+		return err
+	}
+	bw.inBatch++
+	if bw.inBatch == bw.batchSize {
+		return bw.endBatch()
+	}
+	return nil
+}
+
+// renderDocument writes winning revision of document with _id, _rev,
+// _revisions, _deleted and inline _attachments into buffer
+func (bw *bulkDocsWriter) renderDocument(di *couchdbfile.DocumentInfo) error {
+	if len(di.OtherLeaves) > 0 {
+		others := make([]string, len(di.OtherLeaves))
+		for i := range di.OtherLeaves {
+			others[i] = di.OtherLeaves[i].String()
+		}
+		slog.Warnf("Document %q has conflicting revisions %s, only winning revision %s is written.",
+			di.ID, strings.Join(others, ", "), di.Rev())
+	}
+	fields := []jsonser.Field{
+		jsonser.StringField("_id", string(di.ID)),
+		jsonser.StringField("_rev", di.Rev()),
+		{Key: "_revisions", Value: revisionsJSON(di)},
+	}
+	if di.Deleted != 0 {
+		fields = append(fields, jsonser.BoolField("_deleted", true))
+	}
+	atts, err := bw.cf.ReadAttachments(di)
+	if err != nil {
+		return err
+	}
+	if len(atts) > 0 {
+		value, err := bw.attachmentsJSON(atts)
+		if err != nil {
+			return couchdbfile.NewDocumentError(di, err)
+		}
+		fields = append(fields, jsonser.Field{Key: "_attachments", Value: value})
+	}
+	return bw.cf.WriteDocument(di, bw.buf, fields...)
+}
+
+// revisionsJSON returns {"start":Pos,"ids":[...]} object with revision ids
+// from the winning revision back to the first one known
+func revisionsJSON(di *couchdbfile.DocumentInfo) []byte {
+	var enc bytes.Buffer
+	last, _ := di.LastRevision()
+	fmt.Fprintf(&enc, `{"start":%d,"ids":[`, last.Pos)
+	for i := len(di.Revisions) - 1; i >= 0; i-- {
+		quoted, _ := jsonCell(di.Revisions[i].ID())
+		enc.WriteString(quoted)
+		if i > 0 {
+			enc.WriteByte(',')
+		}
+	}
+	enc.WriteString("]}")
+	return enc.Bytes()
+}
+
+// attachmentsJSON returns _attachments object with base64 encoded data.
+// Gzip encoded attachments are decoded, as inline data can not be
+// encoded, and their digest is left out as it is digest of encoded data.
+func (bw *bulkDocsWriter) attachmentsJSON(atts []couchdbfile.Attachment) ([]byte, error) {
+	var enc bytes.Buffer
+	enc.WriteByte('{')
+	for i := range atts {
+		a := &atts[i]
+		if i > 0 {
+			enc.WriteByte(',')
+		}
+		name, err := jsonCell(a.Name)
+		if err != nil {
+			return nil, err
+		}
+		contentType, err := jsonCell(a.ContentType)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&enc, `%s:{"content_type":%s,"revpos":%d,`, name, contentType, a.RevPos)
+		if a.Encoding == "identity" && len(a.Digest) > 0 {
+			fmt.Fprintf(&enc, `"digest":"md5-%s",`, base64.StdEncoding.EncodeToString(a.Digest))
+		}
+		enc.WriteString(`"data":"`)
+		err = bw.writeAttachmentData(a, &enc)
+		if err != nil {
+			return nil, err
+		}
+		enc.WriteString(`"}`)
+	}
+	enc.WriteByte('}')
+	return enc.Bytes(), nil
+}
+
+// writeAttachmentData writes decoded attachment data as base64
+func (bw *bulkDocsWriter) writeAttachmentData(a *couchdbfile.Attachment, output io.Writer) error {
+	b64 := base64.NewEncoder(base64.StdEncoding, output)
+	err := writeDecodedAttachment(bw.cf, a, b64)
+	if err != nil {
+		return err
+	}
+	return b64.Close()
+}
+
+// writeDecodedAttachment writes attachment data, gzip encoded attachments
+// are decoded
+func writeDecodedAttachment(cf *couchdbfile.CouchDbFile, a *couchdbfile.Attachment, output io.Writer) error {
+	switch a.Encoding {
+	case "identity":
+		return cf.WriteAttachment(a, output)
+	case "gzip":
+		var stored bytes.Buffer
+		err := cf.WriteAttachment(a, &stored)
+		if err != nil {
+			return err
+		}
+		zr, err := gzip.NewReader(&stored)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(output, zr)
+		return err
+	}
+	return fmt.Errorf("Unsupported encoding %q of attachment %q", a.Encoding, a.Name)
+}
+
+// startBatch opens file of the next batch when writing into directory
+func (bw *bulkDocsWriter) startBatch() error {
+	bw.batches++
+	if bw.dir == "" {
+		return nil
+	}
+	filename := path.Join(bw.dir, fmt.Sprintf("%s-%06d.json", bw.dbName, bw.batches))
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	bw.file = f
+	bw.fileW = bufio.NewWriter(f)
+	bw.w = bw.fileW
+	return nil
+}
+
+// endBatch ends the batch and closes its file
+func (bw *bulkDocsWriter) endBatch() error {
+	bw.inBatch = 0
+	_, err := io.WriteString(bw.w, "],\"new_edits\":false}\n")
+	if err != nil {
+		return err
+	}
+	if bw.file == nil {
+		return nil
+	}
+	err = bw.fileW.Flush()
+	closeErr := bw.file.Close()
+	bw.file = nil
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// close implements documentWriter, it ends the last batch
+func (bw *bulkDocsWriter) close() error {
+	leakybucket.PutBuffer(bw.buf)
+	bw.buf = nil
+	if bw.inBatch > 0 {
+		err := bw.endBatch()
+		if err != nil {
+			return err
+		}
+	}
+	if bw.dir != "" {
+		slog.Infof("Wrote %d batch files to %s.", bw.batches, bw.dir)
+	}
+	return nil
+}
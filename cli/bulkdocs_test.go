@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/pipedrive/uncouch/internal/couchtest"
+)
+
+func TestBulkDocs(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  couchtest.Document
+		want string
+	}{
+		{"single revision", couchtest.Doc("a", 1, "a1", `{"v":1}`),
+			`{"_id":"a","_rev":"1-a1","_revisions":{"start":1,"ids":["a1"]},"v":1}`},
+		{"deleted", couchtest.Deleted("a", 2, "a2"),
+			`{"_id":"a","_rev":"2-a2","_revisions":{"start":2,"ids":["a2"]},"_deleted":true}`},
+		{"attachment", couchtest.Document{ID: "a", Leaves: []couchtest.Leaf{{
+			Pos: 2, Revs: []string{"a2", "a1"}, Body: `{}`,
+			Attachments: []couchtest.Attachment{{Name: "n.txt", ContentType: "text/plain", Data: []byte("hi"), RevPos: 1}},
+		}}},
+			`{"_id":"a","_rev":"2-a2","_revisions":{"start":2,"ids":["a2","a1"]},"_attachments":{"n.txt":{"content_type":"text/plain","revpos":1,"digest":"md5-SfaKXIST7CwL9ImCHCH8Ow==","data":"aGk="}}}`},
+		{"longer branch wins", couchtest.Document{ID: "a", Leaves: []couchtest.Leaf{
+			{Pos: 2, Revs: []string{"b2", "a1"}, Body: `{"v":"short"}`},
+			{Pos: 3, Revs: []string{"c3", "x2", "a1"}, Body: `{"v":"long"}`},
+		}},
+			`{"_id":"a","_rev":"3-c3","_revisions":{"start":3,"ids":["c3","x2","a1"]},"v":"long"}`},
+		{"higher revision id wins", couchtest.Document{ID: "a", Leaves: []couchtest.Leaf{
+			{Pos: 2, Revs: []string{"z2", "a1"}, Body: `{"v":"z"}`},
+			{Pos: 2, Revs: []string{"b2", "a1"}, Body: `{"v":"b"}`},
+		}},
+			`{"_id":"a","_rev":"2-z2","_revisions":{"start":2,"ids":["z2","a1"]},"v":"z"}`},
+		{"live branch wins over deleted", couchtest.Document{ID: "a", Leaves: []couchtest.Leaf{
+			{Pos: 3, Revs: []string{"d3", "b2", "a1"}, Deleted: true},
+			{Pos: 2, Revs: []string{"c2", "a1"}, Body: `{"v":"live"}`},
+		}},
+			`{"_id":"a","_rev":"2-c2","_revisions":{"start":2,"ids":["c2","a1"]},"v":"live"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := runData(t, couchtest.File(t, tt.doc), "-f", "bulk-docs")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := `{"docs":[` + tt.want + "],\"new_edits\":false}\n"
+			if got != want {
+				t.Errorf("got\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
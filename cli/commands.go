@@ -2,9 +2,13 @@ package cli
 
 import (
 	"bytes"
-	"encoding/json"
+	"fmt"
 	"github.com/pipedrive/uncouch/couchdbfile"
+	"github.com/pipedrive/uncouch/erldeser"
+	"github.com/pipedrive/uncouch/jsonser"
+	"github.com/pipedrive/uncouch/termite"
 	"github.com/spf13/cobra"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
@@ -13,52 +17,193 @@ import (
 
 func cmdDataFunc(cmd *cobra.Command, args []string) error {
 	filename := args[0]
-
-	// open file for reading
-	f, err := os.Open(filename)
+	f, cf, err := openDocuments(cmd, filename)
 	if err != nil {
-		slog.Error(err)
 		return err
 	}
 	defer f.Close()
 
-	// get file size
-	fi, err := f.Stat()
+	dbName := strings.Split(path.Base(filename), ".")[0]
+	policy, err := errorPolicyFlags(cmd, dbName, cf)
 	if err != nil {
-		slog.Error(err)
 		return err
 	}
+	defer policy.Close()
 
-	// get CouchDbFile
-	cf, err := couchdbfile.New(f, fi.Size())
+	formatName, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+	format, err := getDataFormat(formatName)
 	if err != nil {
-		slog.Error(err)
 		return err
 	}
 
-	// read JSON from CouchDbFile and send to jsonLines channel
-	couchDbDocuments := cf.ReadOffset(cf.Header.SeqTreeState.Offset, []couchdbfile.CouchDbDocument{})
+	// documents go to stdout or output files, logs stay on stderr
+	dataOut := &dataOutput{cf: cf, dbName: dbName, cmd: cmd, policy: policy}
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return err
+	}
+	var dw documentWriter
+	dir := ""
+	if output != "" && format.batchFiles {
+		dir, err = outputDir(output)
+		if err != nil {
+			return err
+		}
+	}
+	if dir != "" {
+		for _, name := range []string{"compress", "rotate-size", "rotate-docs", "split-by-hash"} {
+			if cmd.Flags().Changed(name) {
+				return fmt.Errorf("--%s can not be used with batch files of %s", name, formatName)
+			}
+		}
+		dataOut.dir = dir
+		dw, err = format.newWriter(dataOut)
+		if err != nil {
+			return err
+		}
+	} else {
+		opts, err := sinkFlags(cmd, dbName, format)
+		if err != nil {
+			return err
+		}
+		sinks, err := newDataSinks(cmd, dataOut, format, opts)
+		if err != nil {
+			return err
+		}
+		// Parts not completed are removed when the command fails
+		defer sinks.abort()
+		dw = sinks
+	}
 
-	// read from the channel and print the results
-	dbName := strings.Split(path.Base(filename), ".")[0]
-	for _, doc := range couchDbDocuments {
-		line := map[string]interface{}{
-			"_id":      doc.Id,
-			"_db":      dbName,
-			"_deleted": doc.Deleted,
+	// read documents in sequence or ID order and print the results
+	written := 0
+	c := cf.SeqCursor(0)
+	if format.byID {
+		c = cf.IDCursor()
+	}
+	for {
+		di, err := c.Next()
+		if err == io.EOF {
+			break
 		}
-		for k, v := range doc.Value {
-			line[k] = v
+		if err != nil {
+			if err = policy.nodeFailed(err); err != nil {
+				return err
+			}
+			continue
+		}
+		err = dw.writeDocument(di)
+		if err == errSkipDocument {
+			continue
+		}
+		if isDocumentError(err) {
+			if err = policy.documentFailed(di, err); err != nil {
+				return err
+			}
+			continue
 		}
-		s, err := json.Marshal(line)
 		if err != nil {
-			slog.Error(err)
+			return err
 		}
-		log.Info(string(s))
+		written++
+	}
+	err = dw.close()
+	if err != nil {
+		return err
+	}
+	err = policy.Close()
+	if err != nil {
+		return err
+	}
+	return policy.finish(written)
+}
+
+// openDocuments opens CouchDB file for commands writing documents, set up
+// by the document flags. File has to be closed by the caller.
+func openDocuments(cmd *cobra.Command, filename string) (*os.File, *couchdbfile.CouchDbFile, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	cf, err := couchdbfile.New(f, fi.Size())
+	if err == nil {
+		err = documentFlags(cmd, cf)
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, err
 	}
+	return f, cf, nil
+}
+
+// documentFlags applies --invalid-utf8, --ascii and --float-format flags
+func documentFlags(cmd *cobra.Command, cf *couchdbfile.CouchDbFile) error {
+	invalidUTF8, err := cmd.Flags().GetString("invalid-utf8")
+	if err != nil {
+		return err
+	}
+	utf8Policy, err := jsonser.ParseUTF8Policy(invalidUTF8)
+	if err != nil {
+		return err
+	}
+	cf.SetUTF8Policy(utf8Policy)
+	ascii, err := cmd.Flags().GetBool("ascii")
+	if err != nil {
+		return err
+	}
+	cf.SetASCII(ascii)
+	floatFormat, err := cmd.Flags().GetString("float-format")
+	if err != nil {
+		return err
+	}
+	floats, err := jsonser.ParseFloatFormat(floatFormat)
+	if err != nil {
+		return err
+	}
+	cf.SetFloatFormat(floats)
 	return nil
 }
 
+// errorPolicyFlags returns error policy set up by --on-error and
+// --quarantine-file flags
+func errorPolicyFlags(cmd *cobra.Command, dbName string, cf *couchdbfile.CouchDbFile) (*errorPolicy, error) {
+	onError, err := cmd.Flags().GetString("on-error")
+	if err != nil {
+		return nil, err
+	}
+	quarantinePath, err := cmd.Flags().GetString("quarantine-file")
+	if err != nil {
+		return nil, err
+	}
+	if quarantinePath == "" {
+		quarantinePath = dbName + ".quarantine.jsonl"
+	}
+	return newErrorPolicy(onError, quarantinePath, cf)
+}
+
+// outputDir returns output if it names a directory, either existing or
+// ending with slash, and empty string otherwise
+func outputDir(output string) (string, error) {
+	fi, err := os.Stat(output)
+	isDir := err == nil && fi.IsDir()
+	if !isDir && !strings.HasSuffix(output, "/") {
+		return "", nil
+	}
+	err = os.MkdirAll(output, 0755)
+	if err != nil {
+		return "", err
+	}
+	return output, nil
+}
+
 func cmdHeadersFunc(cmd *cobra.Command, args []string) error {
 	outputdir := args[1]
 	_, err := os.Stat(outputdir)
@@ -68,26 +213,72 @@ func cmdHeadersFunc(cmd *cobra.Command, args []string) error {
 	filename := args[0]
 	f, err := os.Open(filename)
 	if err != nil {
-		slog.Error(err)
 		return err
 	}
 	defer f.Close()
 	fi, err := f.Stat()
 	if err != nil {
-		slog.Error(err)
 		return err
 	}
 	fileBytes, err := ioutil.ReadAll(f)
 	if err != nil {
-		slog.Error(err)
 		return err
 	}
 	memoryReader := bytes.NewReader(fileBytes)
 	cf, err := couchdbfile.New(memoryReader, fi.Size())
 	if err != nil {
-		slog.Error(err)
 		return err
 	}
 
 	return writeHeaders(cf, outputdir)
 }
+
+func cmdDecodeFunc(cmd *cobra.Command, args []string) error {
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+	if format != "erlang" && format != "json" {
+		err := fmt.Errorf("Unknown format %q, expecting erlang or json", format)
+		return err
+	}
+	out := cmd.OutOrStdout()
+	for _, filename := range args {
+		fileBytes, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		// headers command strips the magic number, raw blocks may still have it
+		if len(fileBytes) > 0 && fileBytes[0] == 131 {
+			fileBytes = fileBytes[1:]
+		}
+		s, err := erldeser.NewScanner(fileBytes)
+		if err != nil {
+			return err
+		}
+		tb, err := termite.NewBuilder()
+		if err != nil {
+			return err
+		}
+		t, err := tb.ReadTermite(s)
+		if err != nil {
+			return err
+		}
+		if format == "erlang" {
+			err = t.WriteErlang(out)
+			if err == nil {
+				_, err = fmt.Fprintln(out, ".")
+			}
+		} else {
+			err = t.WriteTaggedJSON(out)
+			if err == nil {
+				_, err = fmt.Fprintln(out)
+			}
+		}
+		t.Release()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+// runData runs data command with args and returns what it wrote to
+// stdout
+func runData(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	var stdout bytes.Buffer
+	cmd := newRootCommand(new(bool))
+	cmd.SetOut(&stdout)
+	cmd.SetArgs(append([]string{"data", "--log-level", "error"}, args...))
+	err := cmd.Execute()
+	return stdout.String(), err
+}
+
+// newDecodeCommand returns decode command writing to output
+func newDecodeCommand(format string, output *bytes.Buffer) *cobra.Command {
+	cmd := &cobra.Command{RunE: cmdDecodeFunc}
+	cmd.Flags().StringP("format", "f", "erlang", "")
+	cmd.Flags().Set("format", format)
+	cmd.SetOut(output)
+	return cmd
+}
+
+func TestCmdDecode(t *testing.T) {
+	dir := t.TempDir()
+	// {ok,[<<"a">>|1]} with and without the magic number
+	term := []byte{
+		104, 2, // SMALL_TUPLE_EXT of 2
+		100, 0, 2, 'o', 'k', // ATOM_EXT ok
+		108, 0, 0, 0, 1, // LIST_EXT of 1
+		109, 0, 0, 0, 1, 'a', // BINARY_EXT <<"a">>
+		97, 1, // SMALL_INTEGER_EXT tail
+	}
+	withMagic := filepath.Join(dir, "with_magic.bin")
+	withoutMagic := filepath.Join(dir, "without_magic.bin")
+	if err := os.WriteFile(withMagic, append([]byte{131}, term...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(withoutMagic, term, 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"erlang", "{ok,[<<\"a\">>|1]}.\n"},
+		{"json", `{"type":"tuple","elements":[{"type":"atom","value":"ok"},` +
+			`{"type":"list","elements":[{"type":"binary","value":"a"}],"tail":{"type":"integer","value":1}}]}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var output bytes.Buffer
+			cmd := newDecodeCommand(tt.format, &output)
+			if err := cmdDecodeFunc(cmd, []string{withMagic, withoutMagic}); err != nil {
+				t.Fatal(err)
+			}
+			// Both files hold the same term
+			want := tt.want + tt.want
+			if output.String() != want {
+				t.Errorf("got\n%s\nwant\n%s", output.String(), want)
+			}
+		})
+	}
+}
+
+func TestCmdDecodeUnknownFormat(t *testing.T) {
+	var output bytes.Buffer
+	err := cmdDecodeFunc(newDecodeCommand("yaml", &output), []string{"unused.bin"})
+	want := `Unknown format "yaml", expecting erlang or json`
+	if err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"github.com/pipedrive/uncouch/couchdbfile"
+	"github.com/pipedrive/uncouch/jsonser"
+	"github.com/pipedrive/uncouch/leakybucket"
+)
+
+// couchRowsWriter writes documents as rows of CouchDB _all_docs or
+// _changes responses with include_docs, one row per line
+type couchRowsWriter struct {
+	cf  *couchdbfile.CouchDbFile
+	w   io.Writer
+	buf *bytes.Buffer
+	// changes writes _changes rows instead of _all_docs rows
+	changes bool
+}
+
+// newAllDocsWriter returns writer of all-docs format. Deleted documents
+// are left out, same as in _all_docs.
+func newAllDocsWriter(out *dataOutput) (documentWriter, error) {
+	return newCouchRowsWriter(out, false), nil
+}
+
+// newChangesWriter returns writer of changes format
+func newChangesWriter(out *dataOutput) (documentWriter, error) {
+	return newCouchRowsWriter(out, true), nil
+}
+
+// newCouchRowsWriter returns couchRowsWriter of _changes or _all_docs rows
+func newCouchRowsWriter(out *dataOutput, changes bool) *couchRowsWriter {
+	var (
+		newWriter couchRowsWriter
+	)
+	rw := &newWriter
+	rw.cf = out.cf
+	rw.w = out.w
+	rw.buf = leakybucket.GetBuffer()
+	rw.changes = changes
+	return rw
+}
+
+// writeDocument implements documentWriter
+func (rw *couchRowsWriter) writeDocument(di *couchdbfile.DocumentInfo) error {
+	if !rw.changes && di.Deleted != 0 {
+		return errSkipDocument
+	}
+	rw.buf.Reset()
+	// Row is rendered into buffer first, so failing document leaves no
+	// partial line in the output
+	id, err := jsonCell(string(di.ID))
+	if err != nil {
+		return err
+	}
+	rev, err := jsonCell(di.Rev())
+	if err != nil {
+		return err
+	}
+	if rw.changes {
+		fmt.Fprintf(rw.buf, `{"seq":%d,"id":%s,"changes":[{"rev":%s}],`, di.UpdateSeq, id, rev)
+		if di.Deleted != 0 {
+			rw.buf.WriteString(`"deleted":true,`)
+		}
+	} else {
+		fmt.Fprintf(rw.buf, `{"id":%s,"key":%s,"value":{"rev":%s},`, id, id, rev)
+	}
+	rw.buf.WriteString(`"doc":`)
+	fields, err := rw.docFields(di)
+	if err != nil {
+		return err
+	}
+	err = rw.cf.WriteDocument(di, rw.buf, fields...)
+	if err != nil {
+		return err
+	}
+	rw.buf.WriteString("}\n")
+	_, err = rw.buf.WriteTo(rw.w)
+	return err
+}
+
+// docFields returns fields CouchDB adds to included documents, _id, _rev,
+// _deleted for tombstones and _attachments stubs
+func (rw *couchRowsWriter) docFields(di *couchdbfile.DocumentInfo) ([]jsonser.Field, error) {
+	fields := []jsonser.Field{
+		jsonser.StringField("_id", string(di.ID)),
+		jsonser.StringField("_rev", di.Rev()),
+	}
+	if di.Deleted != 0 {
+		fields = append(fields, jsonser.BoolField("_deleted", true))
+	}
+	atts, err := rw.cf.ReadAttachments(di)
+	if err != nil {
+		return nil, err
+	}
+	if len(atts) > 0 {
+		value, err := attachmentStubsJSON(atts)
+		if err != nil {
+			return nil, couchdbfile.NewDocumentError(di, err)
+		}
+		fields = append(fields, jsonser.Field{Key: "_attachments", Value: value})
+	}
+	return fields, nil
+}
+
+// attachmentStubsJSON returns _attachments object with stubs, as CouchDB
+// returns it without attachment data
+func attachmentStubsJSON(atts []couchdbfile.Attachment) ([]byte, error) {
+	var enc bytes.Buffer
+	enc.WriteByte('{')
+	for i := range atts {
+		a := &atts[i]
+		if i > 0 {
+			enc.WriteByte(',')
+		}
+		name, err := jsonCell(a.Name)
+		if err != nil {
+			return nil, err
+		}
+		contentType, err := jsonCell(a.ContentType)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&enc, `%s:{"content_type":%s,"revpos":%d,`, name, contentType, a.RevPos)
+		if len(a.Digest) > 0 {
+			fmt.Fprintf(&enc, `"digest":"md5-%s",`, base64.StdEncoding.EncodeToString(a.Digest))
+		}
+		fmt.Fprintf(&enc, `"length":%d,`, a.Length)
+		if a.Encoding != "identity" {
+			fmt.Fprintf(&enc, `"encoding":%q,"encoded_length":%d,`, a.Encoding, a.DiskLength)
+		}
+		enc.WriteString(`"stub":true}`)
+	}
+	enc.WriteByte('}')
+	return enc.Bytes(), nil
+}
+
+// close implements documentWriter
+func (rw *couchRowsWriter) close() error {
+	leakybucket.PutBuffer(rw.buf)
+	rw.buf = nil
+	return nil
+}
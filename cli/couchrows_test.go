@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/pipedrive/uncouch/internal/couchtest"
+)
+
+// rowsFile returns database with live, deleted, attachment and conflicted
+// documents
+func rowsFile(t *testing.T) string {
+	return couchtest.File(t,
+		couchtest.Doc("b", 1, "b1", `{"v":1,"_x":"y"}`),
+		couchtest.Deleted("a", 2, "a2"),
+		couchtest.Document{ID: "c", Leaves: []couchtest.Leaf{{
+			Pos: 2, Revs: []string{"c2", "c1"}, Body: `{"n":"c"}`,
+			Attachments: []couchtest.Attachment{{Name: "n.txt", ContentType: "text/plain", Data: []byte("hi"), RevPos: 1}},
+		}}},
+		couchtest.Document{ID: "d", Leaves: []couchtest.Leaf{
+			{Pos: 2, Revs: []string{"z2", "d1"}, Body: `{"v":"z"}`},
+			{Pos: 2, Revs: []string{"b2", "d1"}, Body: `{"v":"b"}`},
+		}},
+		couchtest.Doc("e", 1, "e1", `{"_id":"body","_rev":"body","x":null}`),
+	)
+}
+
+func TestCouchRows(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"changes", `{"seq":1,"id":"b","changes":[{"rev":"1-b1"}],"doc":{"_id":"b","_rev":"1-b1","v":1,"_x":"y"}}
+{"seq":2,"id":"a","changes":[{"rev":"2-a2"}],"deleted":true,"doc":{"_id":"a","_rev":"2-a2","_deleted":true}}
+{"seq":3,"id":"c","changes":[{"rev":"2-c2"}],"doc":{"_id":"c","_rev":"2-c2","_attachments":{"n.txt":{"content_type":"text/plain","revpos":1,"digest":"md5-SfaKXIST7CwL9ImCHCH8Ow==","length":2,"stub":true}},"n":"c"}}
+{"seq":4,"id":"d","changes":[{"rev":"2-z2"}],"doc":{"_id":"d","_rev":"2-z2","v":"z"}}
+{"seq":5,"id":"e","changes":[{"rev":"1-e1"}],"doc":{"_id":"e","_rev":"1-e1","x":null}}
+`},
+		// Deleted "a" is skipped and rows are in ID order
+		{"all-docs", `{"id":"b","key":"b","value":{"rev":"1-b1"},"doc":{"_id":"b","_rev":"1-b1","v":1,"_x":"y"}}
+{"id":"c","key":"c","value":{"rev":"2-c2"},"doc":{"_id":"c","_rev":"2-c2","_attachments":{"n.txt":{"content_type":"text/plain","revpos":1,"digest":"md5-SfaKXIST7CwL9ImCHCH8Ow==","length":2,"stub":true}},"n":"c"}}
+{"id":"d","key":"d","value":{"rev":"2-z2"},"doc":{"_id":"d","_rev":"2-z2","v":"z"}}
+{"id":"e","key":"e","value":{"rev":"1-e1"},"doc":{"_id":"e","_rev":"1-e1","x":null}}
+`},
+	}
+	path := rowsFile(t)
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			got, err := runData(t, path, "-f", tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pipedrive/uncouch/couchdbfile"
+	"github.com/pipedrive/uncouch/jsonser"
+)
+
+// Array cell formats of csv and tsv output
+const (
+	arrayJSON = "json"
+	arrayJoin = "join"
+)
+
+// csvWriter writes documents as rows of a table. Nested objects are
+// flattened into dotted column names, so {"a":{"b":1}} fills column a.b.
+// Dots and backslashes in keys are escaped with backslash, so {"a.b":1}
+// fills column a\.b and does not collide with the nested field.
+type csvWriter struct {
+	cf        *couchdbfile.CouchDbFile
+	w         *csv.Writer
+	dbField   jsonser.Field
+	columns   []string
+	index     map[string]int
+	arrays    string
+	separator string
+	row       []string
+}
+
+// newCSVWriter returns writer of comma separated values
+func newCSVWriter(out *dataOutput) (documentWriter, error) {
+	return newTableWriter(out, ',')
+}
+
+// newTSVWriter returns writer of tab separated values
+func newTSVWriter(out *dataOutput) (documentWriter, error) {
+	return newTableWriter(out, '\t')
+}
+
+// newTableWriter returns csvWriter separating cells with comma. Columns
+// come from --columns flag or are discovered by reading all documents
+// first, and the header row is written right away.
+func newTableWriter(out *dataOutput, comma rune) (documentWriter, error) {
+	var (
+		newWriter csvWriter
+	)
+	cw := &newWriter
+	cw.cf = out.cf
+	cw.dbField = jsonser.StringField("_db", out.dbName)
+	flags := out.cmd.Flags()
+	columns, err := flags.GetStringSlice("columns")
+	if err != nil {
+		return nil, err
+	}
+	cw.arrays, err = flags.GetString("array-format")
+	if err != nil {
+		return nil, err
+	}
+	if cw.arrays != arrayJSON && cw.arrays != arrayJoin {
+		return nil, fmt.Errorf("Unknown array format %q, expecting json or join", cw.arrays)
+	}
+	cw.separator, err = flags.GetString("array-separator")
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		if out.columns == nil {
+			out.columns, err = discoverColumns(out, cw.dbField)
+			if err != nil {
+				return nil, err
+			}
+		}
+		columns = out.columns
+	}
+	cw.columns = columns
+	cw.index = make(map[string]int, len(columns))
+	for i, column := range columns {
+		cw.index[column] = i
+	}
+	cw.row = make([]string, len(columns))
+	cw.w = csv.NewWriter(out.w)
+	cw.w.Comma = comma
+	err = cw.w.Write(columns)
+	if err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+// discoverColumns returns columns of all documents in sequence order,
+// metadata columns first and the rest as they are first seen
+func discoverColumns(out *dataOutput, dbField jsonser.Field) ([]string, error) {
+	var columns []string
+	seen := make(map[string]bool)
+	add := func(column string, value interface{}, leaf bool) error {
+		if leaf && !seen[column] {
+			seen[column] = true
+			columns = append(columns, column)
+		}
+		return nil
+	}
+	err := scanDocuments(out, func(di *couchdbfile.DocumentInfo) error {
+		return flattenDocument(out.cf, di, dbField, add)
+	})
+	if err != nil {
+		return nil, err
+	}
+	slog.Infof("Discovered %d columns.", len(columns))
+	return columns, nil
+}
+
+// flattenFunc gets each value of flattened document with its dotted
+// column name. Leaf is false for non-empty objects, whose values follow.
+type flattenFunc func(column string, value interface{}, leaf bool) error
+
+// flattenDocument reads document and calls fn with metadata fields and
+// then every value of the body
+func flattenDocument(cf *couchdbfile.CouchDbFile, di *couchdbfile.DocumentInfo, dbField jsonser.Field, fn flattenFunc) error {
+	var body jsonser.OrderedMap
+	err := cf.UnmarshalDocument(di, &body)
+	if err != nil {
+		return err
+	}
+	fields := metaFields(string(di.ID), di.Deleted != 0, dbField)
+	for _, f := range fields {
+		var value interface{}
+		d := json.NewDecoder(bytes.NewReader(f.Value))
+		d.UseNumber()
+		err = d.Decode(&value)
+		if err != nil {
+			return err
+		}
+		err = fn(f.Key, value, true)
+		if err != nil {
+			return err
+		}
+	}
+	for _, key := range body.Keys() {
+		if isMetaField(fields, key) {
+			// Metadata takes precedence, same as in JSON lines
+			continue
+		}
+		value, _ := body.Get(key)
+		err = flatten(columnKey(key), value, fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isMetaField tells if key is one of the metadata fields
+func isMetaField(fields []jsonser.Field, key string) bool {
+	for _, f := range fields {
+		if f.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// flatten calls fn with value and, for objects, with all nested values
+func flatten(column string, value interface{}, fn flattenFunc) error {
+	om, ok := value.(*jsonser.OrderedMap)
+	if !ok || om.Len() == 0 {
+		return fn(column, value, true)
+	}
+	err := fn(column, value, false)
+	if err != nil {
+		return err
+	}
+	for _, key := range om.Keys() {
+		v, _ := om.Get(key)
+		err = flatten(column+"."+columnKey(key), v, fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// columnKeyEscaper escapes backslashes and dots of keys
+var columnKeyEscaper = strings.NewReplacer(`\`, `\\`, ".", `\.`)
+
+// columnKey returns key as part of dotted column name
+func columnKey(key string) string {
+	return columnKeyEscaper.Replace(key)
+}
+
+// writeDocument implements documentWriter
+func (cw *csvWriter) writeDocument(di *couchdbfile.DocumentInfo) error {
+	for i := range cw.row {
+		cw.row[i] = ""
+	}
+	// Row is complete before it is written, so failing document leaves
+	// no partial row in the output
+	err := flattenDocument(cw.cf, di, cw.dbField, cw.setCell)
+	if err != nil {
+		return err
+	}
+	return cw.w.Write(cw.row)
+}
+
+// setCell stores value into its column, if the column is written.
+// Columns naming an object get the whole object as JSON.
+func (cw *csvWriter) setCell(column string, value interface{}, leaf bool) error {
+	i, ok := cw.index[column]
+	if !ok {
+		return nil
+	}
+	cell, err := cw.cell(value)
+	if err != nil {
+		return err
+	}
+	cw.row[i] = cell
+	return nil
+}
+
+// cell returns text of value. Null is empty cell, strings and numbers are
+// written as they are and objects as JSON. Arrays are JSON or their
+// elements joined by separator, depending on array format.
+func (cw *csvWriter) cell(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return string(v), nil
+	case bool:
+		if v {
+			return "true", nil
+		}
+		return "false", nil
+	case []interface{}:
+		if cw.arrays == arrayJSON {
+			return jsonCell(v)
+		}
+		elements := make([]string, len(v))
+		for i, e := range v {
+			var err error
+			switch e.(type) {
+			case []interface{}, *jsonser.OrderedMap:
+				elements[i], err = jsonCell(e)
+			default:
+				elements[i], err = cw.cell(e)
+			}
+			if err != nil {
+				return "", err
+			}
+		}
+		return strings.Join(elements, cw.separator), nil
+	default:
+		return jsonCell(v)
+	}
+}
+
+// jsonCell returns value as JSON text
+func jsonCell(value interface{}) (string, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(value)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+// close implements documentWriter
+func (cw *csvWriter) close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/pipedrive/uncouch/internal/couchtest"
+)
+
+func TestCSV(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		args []string
+		want string
+	}{
+		{"dotted columns", `{"a":{"b":1,"c":{"d":"x"}},"e":2.5}`, nil,
+			"_id,_db,_deleted,a.b,a.c.d,e\ndoc1,test,0,1,x,2.5\n"},
+		{"empty object and null", `{"o":{},"n":null,"t":true}`, nil,
+			"_id,_db,_deleted,o,n,t\ndoc1,test,0,{},,true\n"},
+		{"dotted key does not collide", `{"a.b":1,"a":{"b":2}}`, nil,
+			"_id,_db,_deleted,a\\.b,a.b\ndoc1,test,0,1,2\n"},
+		{"backslash in key", `{"a\\":{"b":1},"a\\.b":2}`, nil,
+			"_id,_db,_deleted,a\\\\.b,a\\\\\\.b\ndoc1,test,0,1,2\n"},
+		{"metadata takes precedence", `{"_id":"other","x":1}`, nil,
+			"_id,_db,_deleted,x\ndoc1,test,0,1\n"},
+		{"json arrays", `{"l":[1,"a",null,[2],{"k":"<v>"}]}`, nil,
+			"_id,_db,_deleted,l\ndoc1,test,0,\"[1,\"\"a\"\",null,[2],{\"\"k\"\":\"\"<v>\"\"}]\"\n"},
+		{"joined arrays", `{"l":[1,"a",null,true,[2,3],{"k":"v"}]}`, []string{"--array-format", "join"},
+			"_id,_db,_deleted,l\ndoc1,test,0,\"1;a;;true;[2,3];{\"\"k\"\":\"\"v\"\"}\"\n"},
+		{"joined with separator", `{"l":["x","y"]}`, []string{"--array-format", "join", "--array-separator", "|"},
+			"_id,_db,_deleted,l\ndoc1,test,0,x|y\n"},
+		{"quoting", `{"s":"a,b","q":"say \"hi\"","nl":"line\nbreak","sp":" lead"}`, nil,
+			"_id,_db,_deleted,s,q,nl,sp\ndoc1,test,0,\"a,b\",\"say \"\"hi\"\"\",\"line\nbreak\",\" lead\"\n"},
+		{"tab separated", `{"s":"a,b","tab":"x\ty","a":{"b":1}}`, []string{"-f", "tsv"},
+			"_id\t_db\t_deleted\ts\ttab\ta.b\ndoc1\ttest\t0\ta,b\t\"x\ty\"\t1\n"},
+		{"selected columns", `{"a":{"b":1,"c":[2]},"z":3}`, []string{"--columns", "_id,a,a.c,missing"},
+			"_id,a,a.c,missing\ndoc1,\"{\"\"b\"\":1,\"\"c\"\":[2]}\",[2],\n"},
+		{"escaped selected column", `{"a.b":1,"a":{"b":2}}`, []string{"--columns", `a\.b`},
+			"a\\.b\n1\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := couchtest.File(t, couchtest.Doc("doc1", 1, "r1", tt.body))
+			args := append([]string{path, "-f", "csv"}, tt.args...)
+			got, err := runData(t, args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVColumnsOfAllDocuments(t *testing.T) {
+	path := couchtest.File(t,
+		couchtest.Doc("doc1", 1, "r1", `{"a":1}`),
+		couchtest.Deleted("doc2", 2, "r2"),
+		couchtest.Doc("doc3", 1, "r1", `{"b":{"c":2},"a":3}`),
+	)
+	got, err := runData(t, path, "-f", "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "_id,_db,_deleted,a,b.c\ndoc1,test,0,1,\ndoc2,test,1,,\ndoc3,test,0,3,2\n"
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
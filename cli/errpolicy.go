@@ -0,0 +1,185 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/pipedrive/uncouch"
+	"github.com/pipedrive/uncouch/couchbytes"
+	"github.com/pipedrive/uncouch/couchdbfile"
+	"github.com/pipedrive/uncouch/leakybucket"
+)
+
+// Error policy modes of the data command
+const (
+	onErrorStrict     = "strict"
+	onErrorSkip       = "skip"
+	onErrorQuarantine = "quarantine"
+)
+
+// Exit codes of the application
+const (
+	exitFailure = 1
+	// exitSkipped means the command finished, but skipped some documents
+	exitSkipped = 2
+)
+
+// exitCodeError makes Cli exit with code, without logging anything more
+type exitCodeError struct {
+	code int
+}
+
+// Error implements error
+func (e *exitCodeError) Error() string {
+	return fmt.Sprintf("exit code %d", e.code)
+}
+
+// quarantineRecord is single line of quarantine file
+type quarantineRecord struct {
+	ID     string `json:"id,omitempty"`
+	Offset int64  `json:"offset"`
+	Error  string `json:"error"`
+	// Raw is the chunk as stored in the file, marshalled as base64
+	Raw []byte `json:"raw,omitempty"`
+}
+
+// errorPolicy decides what happens with documents and nodes which can not
+// be read: strict stops, skip drops them and quarantine drops them while
+// saving them to a side file
+type errorPolicy struct {
+	mode        string
+	cf          *couchdbfile.CouchDbFile
+	path        string
+	file        *os.File
+	w           *bufio.Writer
+	skipped     int
+	quarantined int
+}
+
+// newErrorPolicy returns policy of mode. Quarantine file is created at
+// quarantinePath in quarantine mode.
+func newErrorPolicy(mode string, quarantinePath string, cf *couchdbfile.CouchDbFile) (*errorPolicy, error) {
+	var (
+		newPolicy errorPolicy
+	)
+	p := &newPolicy
+	p.mode = mode
+	p.cf = cf
+	p.path = quarantinePath
+	switch mode {
+	case onErrorStrict, onErrorSkip:
+	case onErrorQuarantine:
+		f, err := os.Create(quarantinePath)
+		if err != nil {
+			return nil, err
+		}
+		p.file = f
+		p.w = bufio.NewWriter(f)
+	default:
+		return nil, fmt.Errorf("Unknown error policy %q, expecting strict, skip or quarantine", mode)
+	}
+	return p, nil
+}
+
+// documentFailed handles document which can not be written. It returns
+// error when the command should stop.
+func (p *errorPolicy) documentFailed(di *couchdbfile.DocumentInfo, err error) error {
+	r, _ := di.LastRevision()
+	return p.failed(string(di.ID), r.Offset, err)
+}
+
+// nodeFailed handles Btree node which can not be read. It returns error
+// when the command should stop, also for errors not tied to a node.
+func (p *errorPolicy) nodeFailed(err error) error {
+	var cbe *couchbytes.CorruptBlockError
+	if !errors.As(err, &cbe) {
+		return err
+	}
+	return p.failed("", cbe.Offset, err)
+}
+
+// scanFailed handles document or node which can not be read by a pass
+// reading all documents before writing. Strict mode stops, others leave
+// it to documentFailed or nodeFailed when the same chunk fails again
+// while writing, so it is counted once. Other errors stop the command.
+func (p *errorPolicy) scanFailed(err error) error {
+	var cbe *couchbytes.CorruptBlockError
+	if p.mode == onErrorStrict || !isDocumentError(err) && !errors.As(err, &cbe) {
+		return err
+	}
+	slog.Debugf("Skipping while reading all documents: %v", err)
+	return nil
+}
+
+// isDocumentError tells if err is failure of single document, reported
+// by couchdbfile or by uncouch pipelines
+func isDocumentError(err error) bool {
+	var (
+		docErr *couchdbfile.DocumentError
+		apiErr *uncouch.DocumentError
+	)
+	return errors.As(err, &docErr) || errors.As(err, &apiErr)
+}
+
+// failed records failure of the chunk at offset according to the mode
+func (p *errorPolicy) failed(id string, offset int64, err error) error {
+	if p.mode == onErrorStrict {
+		return err
+	}
+	slog.Error(err)
+	p.skipped++
+	if p.mode != onErrorQuarantine {
+		return nil
+	}
+	record := quarantineRecord{ID: id, Offset: offset, Error: err.Error()}
+	if offset >= 0 {
+		raw, rawErr := p.cf.ReadChunkBytes(offset)
+		if rawErr == nil {
+			record.Raw = append([]byte(nil), *raw...)
+			leakybucket.PutBytes(raw)
+		}
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	_, err = p.w.Write(line)
+	if err != nil {
+		return err
+	}
+	p.quarantined++
+	return nil
+}
+
+// Close flushes and closes the quarantine file
+func (p *errorPolicy) Close() error {
+	if p.file == nil {
+		return nil
+	}
+	err := p.w.Flush()
+	closeErr := p.file.Close()
+	p.file = nil
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// finish logs summary of written and skipped documents and returns
+// exitCodeError when anything was skipped
+func (p *errorPolicy) finish(written int) error {
+	if p.skipped == 0 {
+		slog.Infof("Wrote %d documents.", written)
+		return nil
+	}
+	if p.mode == onErrorQuarantine {
+		slog.Warnf("Wrote %d documents, skipped %d documents or nodes, %d saved to %s.", written, p.skipped, p.quarantined, p.path)
+	} else {
+		slog.Warnf("Wrote %d documents, skipped %d documents or nodes.", written, p.skipped)
+	}
+	return &exitCodeError{code: exitSkipped}
+}
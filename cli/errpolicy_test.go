@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pipedrive/uncouch/couchdbfile"
+)
+
+func TestQuarantineDocumentWithoutRevisions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quarantine.jsonl")
+	p, err := newErrorPolicy(onErrorQuarantine, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	di := &couchdbfile.DocumentInfo{ID: []byte("no-revisions")}
+	err = p.documentFailed(di, errors.New("Corrupt document"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record quarantineRecord
+	if err = json.Unmarshal(b, &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.ID != "no-revisions" || record.Offset != -1 || record.Raw != nil {
+		t.Errorf("got record %+v", record)
+	}
+	if p.skipped != 1 || p.quarantined != 1 {
+		t.Errorf("got %d skipped and %d quarantined, want 1 and 1", p.skipped, p.quarantined)
+	}
+}
+
+func TestScanFailed(t *testing.T) {
+	docErr := couchdbfile.NewDocumentError(&couchdbfile.DocumentInfo{ID: []byte("doc")}, errors.New("Corrupt document"))
+	other := errors.New("Read failed")
+	tests := []struct {
+		mode string
+		err  error
+		stop bool
+	}{
+		{onErrorStrict, docErr, true},
+		{onErrorSkip, docErr, false},
+		{onErrorSkip, other, true},
+		{onErrorQuarantine, docErr, false},
+	}
+	for _, tt := range tests {
+		p, err := newErrorPolicy(tt.mode, filepath.Join(t.TempDir(), "quarantine.jsonl"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.scanFailed(tt.err)
+		if (err != nil) != tt.stop {
+			t.Errorf("%s: got error %v for %v", tt.mode, err, tt.err)
+		}
+		// Failures are counted when the writing pass reads them again
+		if p.skipped != 0 || p.quarantined != 0 {
+			t.Errorf("%s: got %d skipped and %d quarantined, want none", tt.mode, p.skipped, p.quarantined)
+		}
+		p.Close()
+	}
+}
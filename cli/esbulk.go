@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pipedrive/uncouch/couchdbfile"
+	"github.com/pipedrive/uncouch/jsonser"
+	"github.com/pipedrive/uncouch/leakybucket"
+)
+
+// esBulkWriter writes documents as Elasticsearch and OpenSearch bulk API
+// requests. Live documents are index actions followed by the source,
+// deleted documents delete actions. Document _id is the id of the action.
+type esBulkWriter struct {
+	cf     *couchdbfile.CouchDbFile
+	w      io.Writer
+	index  string
+	prefix string
+	buf    *bytes.Buffer
+}
+
+// newESBulkWriter returns writer of es-bulk format. Index name comes from
+// --index template, where {db} is replaced by database name.
+func newESBulkWriter(out *dataOutput) (documentWriter, error) {
+	var (
+		newWriter esBulkWriter
+	)
+	ew := &newWriter
+	ew.cf = out.cf
+	ew.w = out.w
+	flags := out.cmd.Flags()
+	index, err := flags.GetString("index")
+	if err != nil {
+		return nil, err
+	}
+	if index == "" {
+		return nil, fmt.Errorf("Index name can not be empty")
+	}
+	ew.index, err = jsonCell(strings.ReplaceAll(index, "{db}", out.dbName))
+	if err != nil {
+		return nil, err
+	}
+	ew.prefix, err = flags.GetString("rename-prefix")
+	if err != nil {
+		return nil, err
+	}
+	if ew.prefix == "" || strings.HasPrefix(ew.prefix, "_") {
+		return nil, fmt.Errorf("Rename prefix %q can not be empty or start with _", ew.prefix)
+	}
+	ew.buf = leakybucket.GetBuffer()
+	return ew, nil
+}
+
+// writeDocument implements documentWriter
+func (ew *esBulkWriter) writeDocument(di *couchdbfile.DocumentInfo) error {
+	ew.buf.Reset()
+	// Action and source are rendered into buffer first, so failing
+	// document leaves no partial request in the output
+	id, err := jsonCell(string(di.ID))
+	if err != nil {
+		return err
+	}
+	if di.Deleted != 0 {
+		fmt.Fprintf(ew.buf, "{\"delete\":{\"_index\":%s,\"_id\":%s}}\n", ew.index, id)
+		_, err = ew.buf.WriteTo(ew.w)
+		return err
+	}
+	source, err := ew.source(di)
+	if err != nil {
+		return couchdbfile.NewDocumentError(di, err)
+	}
+	fmt.Fprintf(ew.buf, "{\"index\":{\"_index\":%s,\"_id\":%s}}\n", ew.index, id)
+	ew.buf.Write(source)
+	ew.buf.WriteString("\n")
+	_, err = ew.buf.WriteTo(ew.w)
+	return err
+}
+
+// source returns document body with _rev as JSON. Top level fields
+// starting with _ are reserved for metadata in Elasticsearch, they get
+// rename prefix, so _rev becomes couch_rev by default. Fields already named
+// so take precedence over renamed ones, and the revision of the document
+// over both.
+func (ew *esBulkWriter) source(di *couchdbfile.DocumentInfo) ([]byte, error) {
+	var body jsonser.OrderedMap
+	err := ew.cf.UnmarshalDocument(di, &body)
+	if err != nil {
+		return nil, err
+	}
+	keys := body.Keys()
+	source := jsonser.NewOrderedMap(len(keys) + 1)
+	revKey := ew.prefix + "_rev"
+	source.Set(revKey, di.Rev())
+	for _, key := range keys {
+		if key == "_rev" || key == revKey {
+			continue
+		}
+		value, _ := body.Get(key)
+		if !strings.HasPrefix(key, "_") {
+			source.Set(key, value)
+			continue
+		}
+		renamed := ew.prefix + key
+		if _, ok := body.Get(renamed); ok {
+			continue
+		}
+		source.Set(renamed, value)
+	}
+	return source.MarshalJSON()
+}
+
+// close implements documentWriter
+func (ew *esBulkWriter) close() error {
+	leakybucket.PutBuffer(ew.buf)
+	ew.buf = nil
+	return nil
+}
@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/pipedrive/uncouch/internal/couchtest"
+)
+
+func TestESBulk(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"defaults", nil, `{"index":{"_index":"test","_id":"b"}}
+{"couch_rev":"1-b1","v":1,"couch_x":"y"}
+{"delete":{"_index":"test","_id":"a"}}
+{"index":{"_index":"test","_id":"c"}}
+{"couch_rev":"2-c2","n":"c"}
+{"index":{"_index":"test","_id":"d"}}
+{"couch_rev":"2-z2","v":"z"}
+{"index":{"_index":"test","_id":"e"}}
+{"couch_rev":"1-e1","couch_id":"body","x":null}
+`},
+		{"index template and prefix", []string{"--index", "couch-{db}", "--rename-prefix", "c"}, `{"index":{"_index":"couch-test","_id":"b"}}
+{"c_rev":"1-b1","v":1,"c_x":"y"}
+{"delete":{"_index":"couch-test","_id":"a"}}
+{"index":{"_index":"couch-test","_id":"c"}}
+{"c_rev":"2-c2","n":"c"}
+{"index":{"_index":"couch-test","_id":"d"}}
+{"c_rev":"2-z2","v":"z"}
+{"index":{"_index":"couch-test","_id":"e"}}
+{"c_rev":"1-e1","c_id":"body","x":null}
+`},
+	}
+	path := rowsFile(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := runData(t, append([]string{path, "-f", "es-bulk"}, tt.args...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestESBulkRenamedFieldsTakePrecedence(t *testing.T) {
+	path := couchtest.File(t,
+		couchtest.Doc("e", 1, "e1", `{"_a":1,"couch_a":2,"_b":3,"couch_rev":"mine"}`),
+	)
+	got, err := runData(t, path, "-f", "es-bulk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"index":{"_index":"test","_id":"e"}}
+{"couch_rev":"1-e1","couch_a":2,"couch_b":3}
+`
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestESBulkRenamePrefix(t *testing.T) {
+	for _, prefix := range []string{"", "_x"} {
+		_, err := runData(t, couchtest.File(t), "-f", "es-bulk", "--rename-prefix", prefix)
+		if err == nil {
+			t.Errorf("rename prefix %q was accepted", prefix)
+		}
+	}
+}
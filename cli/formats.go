@@ -0,0 +1,146 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pipedrive/uncouch"
+	"github.com/pipedrive/uncouch/couchdbfile"
+	"github.com/pipedrive/uncouch/jsonser"
+	"github.com/spf13/cobra"
+)
+
+// documentWriter writes documents of the data command in one output
+// format. Documents which can not be read are reported as
+// *couchdbfile.DocumentError or *uncouch.DocumentError, any other error
+// stops the command.
+type documentWriter interface {
+	writeDocument(di *couchdbfile.DocumentInfo) error
+	// close writes anything still buffered, output itself is closed by
+	// the caller
+	close() error
+}
+
+// dataOutput is what every format of the data command gets
+type dataOutput struct {
+	cf     *couchdbfile.CouchDbFile
+	dbName string
+	w      io.Writer
+	cmd    *cobra.Command
+	// policy handles documents failing while reading all documents once
+	policy *errorPolicy
+	// dir is output directory of formats writing batch files, w is not
+	// used then
+	dir string
+	// columns and kinds are found by reading all documents once, they are
+	// kept for writers of further output parts
+	columns []string
+	kinds   map[string]columnKind
+}
+
+// newDocumentWriter returns documentWriter of a format
+type newDocumentWriter func(out *dataOutput) (documentWriter, error)
+
+// dataFormat is output format of the data command
+type dataFormat struct {
+	// ext is file name extension used when output is a directory
+	ext       string
+	newWriter newDocumentWriter
+	// batchFiles formats write each batch into its own file when output
+	// is a directory
+	batchFiles bool
+	// byID formats write documents in ID order instead of sequence order
+	byID bool
+}
+
+// dataFormats are output formats of the data command by name. Formats
+// rendering from UnmarshalDocument hold whole decoded document in memory.
+var dataFormats = map[string]dataFormat{
+	"jsonl":      {ext: "jsonl", newWriter: newJSONLinesWriter},
+	"csv":        {ext: "csv", newWriter: newCSVWriter},
+	"tsv":        {ext: "tsv", newWriter: newTSVWriter},
+	"parquet":    {ext: "parquet", newWriter: newParquetWriter},
+	"bulk-docs":  {ext: "json", newWriter: newBulkDocsWriter, batchFiles: true},
+	"all-docs":   {ext: "jsonl", newWriter: newAllDocsWriter, byID: true},
+	"changes":    {ext: "jsonl", newWriter: newChangesWriter},
+	"cbor":       {ext: "cbor", newWriter: newCBORWriter},
+	"msgpack":    {ext: "msgpack", newWriter: newMsgPackWriter},
+	"es-bulk":    {ext: "ndjson", newWriter: newESBulkWriter},
+	"bson":       {ext: "bson", newWriter: newBSONWriter},
+	"mongo-json": {ext: "json", newWriter: newMongoJSONWriter},
+}
+
+// errSkipDocument is returned by documentWriter for documents the format
+// leaves out, they are not counted as written
+var errSkipDocument = errors.New("Document left out by the format")
+
+// formatNames returns names of data formats for help and errors
+func formatNames() string {
+	names := make([]string, 0, len(dataFormats))
+	for name := range dataFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// getDataFormat returns format of name
+func getDataFormat(name string) (dataFormat, error) {
+	format, ok := dataFormats[name]
+	if !ok {
+		return dataFormat{}, fmt.Errorf("Unknown format %q, expecting one of %s", name, formatNames())
+	}
+	return format, nil
+}
+
+// scanDocuments calls fn with every document in sequence order, for
+// formats which need to read the file once before writing. Documents and
+// nodes which can not be read go to the error policy, which stops in
+// strict mode and otherwise leaves them to the writing pass.
+func scanDocuments(out *dataOutput, fn func(di *couchdbfile.DocumentInfo) error) error {
+	c := out.cf.SeqCursor(0)
+	for {
+		di, err := c.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err == nil {
+			err = fn(di)
+		}
+		if err != nil {
+			if err = out.policy.scanFailed(err); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// metaFields returns metadata fields written before document fields of
+// jsonl, csv and tsv, _id, _db and _deleted
+func metaFields(id string, deleted bool, dbField jsonser.Field) []jsonser.Field {
+	deletedValue := int64(0)
+	if deleted {
+		deletedValue = 1
+	}
+	return []jsonser.Field{
+		jsonser.StringField("_id", id),
+		dbField,
+		jsonser.IntField("_deleted", deletedValue),
+	}
+}
+
+// newJSONLinesWriter returns writer of jsonl format, each document is
+// JSON object on its own line. Strict mode stops at the first failing
+// document, so bodies are streamed to the output. Documents skipped by
+// other modes must leave no partial line, so each one is rendered into
+// memory first.
+func newJSONLinesWriter(out *dataOutput) (documentWriter, error) {
+	sink := uncouch.NewJSONLinesSink(out.w)
+	if out.policy.mode != onErrorStrict {
+		sink = uncouch.NewBufferedJSONLinesSink(out.w)
+	}
+	return newPipelineWriter(out, sink), nil
+}
@@ -1,9 +1,13 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
+	"github.com/pipedrive/uncouch/leakybucket"
 	"github.com/pipedrive/uncouch/logger"
+	"github.com/pipedrive/uncouch/termite"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"go.uber.org/zap"
 	"os"
 	"strings"
@@ -20,6 +24,29 @@ func init() {
 
 func Cli() {
 	// defer profile.Start().Stop()
+	// Route logs of the library packages to the zap logger, skipping the
+	// proxy frame when reporting caller
+	logger.Set(log.WithOptions(zap.AddCallerSkip(1)).Sugar())
+	var poolStats bool
+	rootCmd := newRootCommand(&poolStats)
+	err := rootCmd.Execute()
+	if poolStats {
+		fmt.Fprint(os.Stderr, leakybucket.GetStats())
+		fmt.Fprintln(os.Stderr, termite.GetPoolStats())
+	}
+	var exitErr *exitCodeError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.code)
+	}
+	if err != nil {
+		slog.Error(err)
+		os.Exit(exitFailure)
+	}
+}
+
+// newRootCommand returns uncouch command with all subcommands. PoolStats
+// is set by the --pool-stats flag.
+func newRootCommand(poolStats *bool) *cobra.Command {
 	cmdPrint := &cobra.Command{
 		Use:   "print [string to print]",
 		Short: "Print anything to the screen",
@@ -33,10 +60,41 @@ For many years people have printed back to the screen.`,
 
 	cmdData := &cobra.Command{
 		Use:   "data filename",
-		Short: "Dump .couch file data as JSON lines to stdout",
+		Short: "Dump .couch file data as JSON lines or table to stdout",
 		Args:  cobra.MinimumNArgs(1),
 		RunE:  cmdDataFunc,
 	}
+	cmdData.Flags().StringP("output", "o", "", "write documents to file instead of stdout, or to <db>.<format> inside directory. {db}, {part} and {shard} are replaced by database name, part and shard numbers")
+	cmdData.Flags().StringP("format", "f", "jsonl", "output format: "+formatNames())
+	cmdData.Flags().StringSlice("columns", nil, "csv and tsv columns, dotted for nested fields, dots and backslashes in keys escaped with backslash (default all columns found by decoding every document in an extra pass)")
+	cmdData.Flags().String("array-format", arrayJSON, "csv and tsv array cells: json, or join elements with --array-separator")
+	cmdData.Flags().String("array-separator", ";", "separator of joined array elements")
+	cmdData.Flags().String("schema", "", "parquet columns from JSON Schema file (default inferred from the documents)")
+	cmdData.Flags().Int64("row-group-size", 10000, "parquet rows per row group")
+	cmdData.Flags().Int("batch-size", 1000, "bulk-docs documents per batch, each batch is a line or a file inside output directory")
+	cmdData.Flags().Bool("length-prefix", false, "cbor and msgpack documents preceded by their length as 4 byte big endian integer")
+	cmdData.Flags().String("index", "{db}", "es-bulk index name, {db} is replaced by database name")
+	cmdData.Flags().String("rename-prefix", "couch", "es-bulk prefix added to top level fields starting with _")
+	cmdData.Flags().String("compress", "", "compress output with gzip, zstd or none (default by --out extension .gz or .zst)")
+	cmdData.Flags().String("rotate-size", "", "start next {part} after about this size, like 500K, 100M or 2G")
+	cmdData.Flags().Int("rotate-docs", 0, "start next {part} after this many documents")
+	cmdData.Flags().Int("split-by-hash", 0, "spread documents across this many {shard} outputs by hash of _id")
+	// --out is the same as --output
+	cmdData.Flags().SetNormalizeFunc(func(f *pflag.FlagSet, name string) pflag.NormalizedName {
+		if name == "out" {
+			name = "output"
+		}
+		return pflag.NormalizedName(name)
+	})
+	addDocumentFlags(cmdData)
+
+	cmdSQLite := &cobra.Command{
+		Use:   "sqlite filename output.db",
+		Short: "Export .couch file into SQLite database with docs, revisions and attachments tables",
+		Args:  cobra.ExactArgs(2),
+		RunE:  cmdSQLiteFunc,
+	}
+	addDocumentFlags(cmdSQLite)
 
 	cmdHeaders := &cobra.Command{
 		Use:   "headers filename path",
@@ -45,18 +103,49 @@ For many years people have printed back to the screen.`,
 		RunE:  cmdHeadersFunc,
 	}
 
+	cmdDecode := &cobra.Command{
+		Use:   "decode filename...",
+		Short: "Print binary blocks written by headers command as Erlang terms or tagged JSON",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  cmdDecodeFunc,
+	}
+	cmdDecode.Flags().StringP("format", "f", "erlang", "output format: erlang or json")
+
+	var (
+		poolMemory int64
+		logLevel   string
+		logFormat  string
+	)
 	rootCmd := &cobra.Command{
 		Use:   "uncouch",
 		Short: "Manage Uncouch related commands",
+		// Errors are logged once by Cli
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// Arguments are valid, errors from now on are not about usage
+			cmd.SilenceUsage = true
+			leakybucket.SetMemoryCeiling(poolMemory)
+			return logger.Configure(logLevel, logFormat)
+		},
 	}
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "log level: debug, info, warn or error")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "console", "log format: console or json")
+	rootCmd.PersistentFlags().BoolVar(poolStats, "pool-stats", false, "print buffer and term pool statistics to stderr on exit")
+	rootCmd.PersistentFlags().Int64Var(&poolMemory, "pool-memory", leakybucket.DefaultMemoryCeiling, "maximum bytes kept in buffer pools for reuse")
 
 	rootCmd.AddCommand(cmdPrint)
 	rootCmd.AddCommand(cmdData)
+	rootCmd.AddCommand(cmdSQLite)
 	rootCmd.AddCommand(cmdHeaders)
+	rootCmd.AddCommand(cmdDecode)
+	return rootCmd
+}
 
-	err := rootCmd.Execute()
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
+// addDocumentFlags adds flags of commands writing documents
+func addDocumentFlags(cmd *cobra.Command) {
+	cmd.Flags().String("on-error", onErrorSkip, "what to do with unreadable documents: strict stops, skip drops them, quarantine drops them and saves them to quarantine file")
+	cmd.Flags().String("invalid-utf8", "replace", "how to write invalid UTF-8 in keys and strings: replace, escape as \\u00XX or fail the document")
+	cmd.Flags().String("float-format", "json", "float format: json as encoding/json writes them, couchdb exactly as CouchDB serves them")
+	cmd.Flags().Bool("ascii", false, "write non-ASCII characters as \\uXXXX escapes")
+	cmd.Flags().String("quarantine-file", "", "quarantine file for --on-error quarantine (default <db>.quarantine.jsonl)")
 }
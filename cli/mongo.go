@@ -0,0 +1,366 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/pipedrive/uncouch/couchdbfile"
+	"github.com/pipedrive/uncouch/jsonser"
+	"github.com/pipedrive/uncouch/leakybucket"
+)
+
+// mongoMetaField holds _rev and _seq of the document, which have no place
+// in MongoDB
+const mongoMetaField = "_couch"
+
+// mongoBinary is binary value, written as generic BSON binary
+type mongoBinary []byte
+
+// MarshalJSON implements json.Marshaler, writing extended JSON $binary
+func (b mongoBinary) MarshalJSON() ([]byte, error) {
+	return []byte(`{"$binary":{"base64":"` + base64.StdEncoding.EncodeToString(b) + `","subType":"00"}}`), nil
+}
+
+// mongoDecimal is integer too big for int64, written as Decimal128
+type mongoDecimal string
+
+// MarshalJSON implements json.Marshaler, writing extended JSON
+// $numberDecimal
+func (d mongoDecimal) MarshalJSON() ([]byte, error) {
+	return []byte(`{"$numberDecimal":"` + string(d) + `"}`), nil
+}
+
+// mongoDouble is float of the document, written as double even when it
+// is integral. It is in CouchDB float format, which always has fraction
+// or exponent.
+type mongoDouble json.Number
+
+// MarshalJSON implements json.Marshaler, writing integral doubles as
+// extended JSON $numberDouble so they do not turn into integers
+func (d mongoDouble) MarshalJSON() ([]byte, error) {
+	f, err := strconv.ParseFloat(string(d), 64)
+	if err != nil {
+		return nil, err
+	}
+	if f == math.Trunc(f) {
+		return []byte(`{"$numberDouble":"` + string(d) + `"}`), nil
+	}
+	return []byte(d), nil
+}
+
+// maxDecimalDigits is precision of Decimal128
+const maxDecimalDigits = 34
+
+// mongoWriter writes live documents as MongoDB documents, concatenated
+// BSON for mongorestore or relaxed extended JSON lines for mongoimport.
+// Deleted documents are left out.
+type mongoWriter struct {
+	cf   *couchdbfile.CouchDbFile
+	w    io.Writer
+	bson bool
+	buf  *bytes.Buffer
+}
+
+// newBSONWriter returns writer of bson format
+func newBSONWriter(out *dataOutput) (documentWriter, error) {
+	return newMongoWriter(out, true), nil
+}
+
+// newMongoJSONWriter returns writer of mongo-json format
+func newMongoJSONWriter(out *dataOutput) (documentWriter, error) {
+	return newMongoWriter(out, false), nil
+}
+
+// newMongoWriter returns mongoWriter of BSON or extended JSON
+func newMongoWriter(out *dataOutput, bson bool) *mongoWriter {
+	var (
+		newWriter mongoWriter
+	)
+	mw := &newWriter
+	// Floats are decoded in CouchDB format whatever --float-format says,
+	// so that they can be told from integers
+	cf := *out.cf
+	cf.SetFloatFormat(jsonser.FloatCouchDB)
+	mw.cf = &cf
+	mw.w = out.w
+	mw.bson = bson
+	mw.buf = leakybucket.GetBuffer()
+	return mw
+}
+
+// writeDocument implements documentWriter
+func (mw *mongoWriter) writeDocument(di *couchdbfile.DocumentInfo) error {
+	if di.Deleted != 0 {
+		return errSkipDocument
+	}
+	doc, err := mw.readDocument(di)
+	if err != nil {
+		return err
+	}
+	mw.buf.Reset()
+	// Document is encoded into buffer first, so failing document leaves
+	// no partial document in the output
+	var encoded []byte
+	if mw.bson {
+		encoded, err = appendBSONDocument(mw.buf.AvailableBuffer(), doc)
+	} else {
+		encoded, err = doc.MarshalJSON()
+		encoded = append(encoded, '\n')
+	}
+	if err != nil {
+		return couchdbfile.NewDocumentError(di, err)
+	}
+	mw.buf.Write(encoded)
+	_, err = mw.buf.WriteTo(mw.w)
+	return err
+}
+
+// readDocument returns document with _id first, then body fields, _couch
+// metadata and _attachments with their data as binary
+func (mw *mongoWriter) readDocument(di *couchdbfile.DocumentInfo) (*jsonser.OrderedMap, error) {
+	var body jsonser.OrderedMap
+	err := mw.cf.UnmarshalDocument(di, &body)
+	if err != nil {
+		return nil, err
+	}
+	atts, err := mw.cf.ReadAttachments(di)
+	if err != nil {
+		return nil, err
+	}
+	keys := body.Keys()
+	doc := jsonser.NewOrderedMap(len(keys) + 3)
+	doc.Set("_id", string(di.ID))
+	for _, key := range keys {
+		if key == "_id" || key == mongoMetaField || key == "_attachments" {
+			continue
+		}
+		value, _ := body.Get(key)
+		value, err = mongoValue(value)
+		if err != nil {
+			return nil, couchdbfile.NewDocumentError(di, err)
+		}
+		doc.Set(key, value)
+	}
+	meta := jsonser.NewOrderedMap(2)
+	meta.Set("rev", di.Rev())
+	meta.Set("seq", di.UpdateSeq)
+	doc.Set(mongoMetaField, meta)
+	if len(atts) == 0 {
+		return doc, nil
+	}
+	attachments := jsonser.NewOrderedMap(len(atts))
+	for i := range atts {
+		a := &atts[i]
+		var data bytes.Buffer
+		err = writeDecodedAttachment(mw.cf, a, &data)
+		if err != nil {
+			return nil, couchdbfile.NewDocumentError(di, err)
+		}
+		attachment := jsonser.NewOrderedMap(4)
+		attachment.Set("content_type", a.ContentType)
+		attachment.Set("length", a.Length)
+		attachment.Set("revpos", a.RevPos)
+		attachment.Set("data", mongoBinary(data.Bytes()))
+		attachments.Set(a.Name, attachment)
+	}
+	doc.Set("_attachments", attachments)
+	return doc, nil
+}
+
+// mongoValue returns value with floats as mongoDouble and integers too
+// big for int64 as mongoDecimal. Integers beyond Decimal128 precision
+// are an error.
+func mongoValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case json.Number:
+		if strings.ContainsAny(string(v), ".eE") {
+			return mongoDouble(v), nil
+		}
+		if _, err := v.Int64(); err == nil {
+			return v, nil
+		}
+		if len(strings.TrimPrefix(string(v), "-")) > maxDecimalDigits {
+			return nil, fmt.Errorf("Integer %s has more than %d digits, Decimal128 can not hold it", v, maxDecimalDigits)
+		}
+		return mongoDecimal(v), nil
+	case []interface{}:
+		for i := range v {
+			element, err := mongoValue(v[i])
+			if err != nil {
+				return nil, err
+			}
+			v[i] = element
+		}
+	case *jsonser.OrderedMap:
+		for _, key := range v.Keys() {
+			element, _ := v.Get(key)
+			element, err := mongoValue(element)
+			if err != nil {
+				return nil, err
+			}
+			v.Set(key, element)
+		}
+	}
+	return value, nil
+}
+
+// BSON element types
+const (
+	bsonDouble     byte = 0x01
+	bsonString     byte = 0x02
+	bsonDocument   byte = 0x03
+	bsonArray      byte = 0x04
+	bsonBinary     byte = 0x05
+	bsonBool       byte = 0x08
+	bsonNull       byte = 0x0a
+	bsonInt32      byte = 0x10
+	bsonInt64      byte = 0x12
+	bsonDecimal128 byte = 0x13
+)
+
+// appendBSONDocument appends map as BSON document
+func appendBSONDocument(dst []byte, m *jsonser.OrderedMap) ([]byte, error) {
+	start := len(dst)
+	dst = append(dst, 0, 0, 0, 0)
+	for _, key := range m.Keys() {
+		value, _ := m.Get(key)
+		var err error
+		dst, err = appendBSONElement(dst, key, value)
+		if err != nil {
+			return dst, err
+		}
+	}
+	return endBSONDocument(dst, start), nil
+}
+
+// endBSONDocument appends terminating zero and writes length of document
+// starting at start
+func endBSONDocument(dst []byte, start int) []byte {
+	dst = append(dst, 0)
+	binary.LittleEndian.PutUint32(dst[start:], uint32(len(dst)-start))
+	return dst
+}
+
+// appendBSONElement appends key and value as element of BSON document
+func appendBSONElement(dst []byte, key string, value interface{}) ([]byte, error) {
+	if strings.IndexByte(key, 0) >= 0 {
+		return dst, fmt.Errorf("Field name %q can not contain zero byte in BSON", key)
+	}
+	// Type is known once the value is seen, it is filled in at the end
+	typeAt := len(dst)
+	dst = append(dst, 0)
+	dst = append(dst, key...)
+	dst = append(dst, 0)
+	var elementType byte
+	switch v := value.(type) {
+	case nil:
+		elementType = bsonNull
+	case bool:
+		elementType = bsonBool
+		if v {
+			dst = append(dst, 1)
+		} else {
+			dst = append(dst, 0)
+		}
+	case string:
+		elementType = bsonString
+		dst = binary.LittleEndian.AppendUint32(dst, uint32(len(v)+1))
+		dst = append(dst, v...)
+		dst = append(dst, 0)
+	case int64:
+		elementType = bsonInt64
+		dst = binary.LittleEndian.AppendUint64(dst, uint64(v))
+	case json.Number:
+		i, err := v.Int64()
+		if err != nil {
+			return dst, err
+		}
+		if i >= math.MinInt32 && i <= math.MaxInt32 {
+			elementType = bsonInt32
+			dst = binary.LittleEndian.AppendUint32(dst, uint32(i))
+			break
+		}
+		elementType = bsonInt64
+		dst = binary.LittleEndian.AppendUint64(dst, uint64(i))
+	case mongoDouble:
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			return dst, err
+		}
+		elementType = bsonDouble
+		dst = binary.LittleEndian.AppendUint64(dst, math.Float64bits(f))
+	case mongoDecimal:
+		elementType = bsonDecimal128
+		var err error
+		dst, err = appendDecimal128(dst, string(v))
+		if err != nil {
+			return dst, err
+		}
+	case mongoBinary:
+		elementType = bsonBinary
+		dst = binary.LittleEndian.AppendUint32(dst, uint32(len(v)))
+		// generic binary subtype
+		dst = append(dst, 0)
+		dst = append(dst, v...)
+	case []interface{}:
+		elementType = bsonArray
+		// Arrays are documents with keys "0", "1" and so on
+		start := len(dst)
+		dst = append(dst, 0, 0, 0, 0)
+		for i, element := range v {
+			var err error
+			dst, err = appendBSONElement(dst, fmt.Sprint(i), element)
+			if err != nil {
+				return dst, err
+			}
+		}
+		dst = endBSONDocument(dst, start)
+	case *jsonser.OrderedMap:
+		elementType = bsonDocument
+		var err error
+		dst, err = appendBSONDocument(dst, v)
+		if err != nil {
+			return dst, err
+		}
+	default:
+		return dst, fmt.Errorf("Unsupported value of field %q: %T", key, value)
+	}
+	dst[typeAt] = elementType
+	return dst, nil
+}
+
+// appendDecimal128 appends integer of at most 34 digits as Decimal128
+// with zero exponent, little endian low and high 64 bits
+func appendDecimal128(dst []byte, integer string) ([]byte, error) {
+	coefficient, ok := new(big.Int).SetString(integer, 10)
+	if !ok {
+		return dst, fmt.Errorf("Invalid integer %s", integer)
+	}
+	negative := coefficient.Sign() < 0
+	coefficient.Abs(coefficient)
+	low := new(big.Int).And(coefficient, new(big.Int).SetUint64(math.MaxUint64)).Uint64()
+	high := new(big.Int).Rsh(coefficient, 64).Uint64()
+	// Exponent 0 is stored with bias 6176 above the 49 high bits of
+	// coefficient
+	high |= uint64(6176) << 49
+	if negative {
+		high |= 1 << 63
+	}
+	dst = binary.LittleEndian.AppendUint64(dst, low)
+	return binary.LittleEndian.AppendUint64(dst, high), nil
+}
+
+// close implements documentWriter
+func (mw *mongoWriter) close() error {
+	leakybucket.PutBuffer(mw.buf)
+	mw.buf = nil
+	return nil
+}
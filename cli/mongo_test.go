@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/pipedrive/uncouch/jsonser"
+)
+
+func TestMongoValueJSON(t *testing.T) {
+	tests := []struct {
+		// number is json.Number as decoded in CouchDB float format
+		number string
+		want   string
+	}{
+		{"1", `1`},
+		{"-2147483649", `-2147483649`},
+		{"1.0", `{"$numberDouble":"1.0"}`},
+		{"-0.0", `{"$numberDouble":"-0.0"}`},
+		{"1e+21", `{"$numberDouble":"1e+21"}`},
+		{"1.5", `1.5`},
+		{"1.5e-7", `1.5e-7`},
+		{"9223372036854775808", `{"$numberDecimal":"9223372036854775808"}`},
+		{strings.Repeat("9", 34), `{"$numberDecimal":"` + strings.Repeat("9", 34) + `"}`},
+	}
+	for _, tt := range tests {
+		value, err := mongoValue([]interface{}{json.Number(tt.number)})
+		if err != nil {
+			t.Errorf("%s: %v", tt.number, err)
+			continue
+		}
+		got, err := json.Marshal(value)
+		if err != nil {
+			t.Errorf("%s: %v", tt.number, err)
+			continue
+		}
+		if string(got) != "["+tt.want+"]" {
+			t.Errorf("%s: got %s, want [%s]", tt.number, got, tt.want)
+		}
+	}
+}
+
+func TestMongoValueTooManyDigits(t *testing.T) {
+	doc := jsonser.NewOrderedMap(1)
+	doc.Set("nested", []interface{}{json.Number("-" + strings.Repeat("1", 35))})
+	_, err := mongoValue(doc)
+	if err == nil {
+		t.Error("integer of 35 digits was accepted")
+	}
+}
+
+func TestAppendBSONElementNumbers(t *testing.T) {
+	tests := []struct {
+		number      string
+		elementType byte
+		// bits are little endian bits of the value
+		bits uint64
+	}{
+		{"1", bsonInt32, 1},
+		{"-2147483649", bsonInt64, uint64(math.MaxUint64 - 2147483648)},
+		{"1.0", bsonDouble, math.Float64bits(1)},
+		{"1e+21", bsonDouble, math.Float64bits(1e21)},
+		{"0.25", bsonDouble, math.Float64bits(0.25)},
+	}
+	for _, tt := range tests {
+		value, err := mongoValue(json.Number(tt.number))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := appendBSONElement(nil, "n", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Element is type, key "n" with zero byte and the value
+		if got[0] != tt.elementType {
+			t.Errorf("%s: got element type %#x, want %#x", tt.number, got[0], tt.elementType)
+		}
+		var bits uint64
+		if tt.elementType == bsonInt32 {
+			bits = uint64(binary.LittleEndian.Uint32(got[3:]))
+		} else {
+			bits = binary.LittleEndian.Uint64(got[3:])
+		}
+		if bits != tt.bits {
+			t.Errorf("%s: got value bits %#x, want %#x", tt.number, bits, tt.bits)
+		}
+	}
+}
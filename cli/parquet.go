@@ -0,0 +1,361 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/pipedrive/uncouch/couchdbfile"
+	"github.com/pipedrive/uncouch/jsonser"
+)
+
+// columnKind is type of parquet column holding document field
+type columnKind int
+
+const (
+	// kindNull columns had only nulls, they are written as strings
+	kindNull columnKind = iota
+	kindInt
+	kindDouble
+	kindBool
+	kindString
+	// kindJSON columns hold objects, arrays and fields of mixed types as
+	// JSON text
+	kindJSON
+)
+
+// columnKindNames are names used in errors
+var columnKindNames = map[columnKind]string{
+	kindNull:   "null",
+	kindInt:    "integer",
+	kindDouble: "double",
+	kindBool:   "boolean",
+	kindString: "string",
+	kindJSON:   "JSON",
+}
+
+// String implements Stringer
+func (k columnKind) String() string {
+	return columnKindNames[k]
+}
+
+// node returns parquet type of the column
+func (k columnKind) node() parquet.Node {
+	switch k {
+	case kindInt:
+		return parquet.Int(64)
+	case kindDouble:
+		return parquet.Leaf(parquet.DoubleType)
+	case kindBool:
+		return parquet.Leaf(parquet.BooleanType)
+	case kindJSON:
+		return parquet.JSON()
+	}
+	return parquet.String()
+}
+
+// kindOf returns kind of value decoded from document or metadata
+func kindOf(value interface{}) columnKind {
+	switch v := value.(type) {
+	case nil:
+		return kindNull
+	case bool:
+		return kindBool
+	case string:
+		return kindString
+	case int64:
+		return kindInt
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return kindInt
+		}
+		// Integers too big for int64 are kept exact as JSON
+		if strings.ContainsAny(string(v), ".eE") {
+			return kindDouble
+		}
+	}
+	return kindJSON
+}
+
+// mergeKinds returns kind of column holding values of both kinds
+func mergeKinds(a, b columnKind) columnKind {
+	switch {
+	case a == b:
+		return a
+	case a == kindNull:
+		return b
+	case b == kindNull:
+		return a
+	case a == kindInt && b == kindDouble, a == kindDouble && b == kindInt:
+		return kindDouble
+	}
+	return kindJSON
+}
+
+// parquetMeta are metadata columns written before document fields, they
+// are required unlike the columns of document fields
+var parquetMeta = map[string]columnKind{
+	"_id":      kindString,
+	"_rev":     kindString,
+	"_deleted": kindBool,
+	"_seq":     kindInt,
+	"_db":      kindString,
+}
+
+// parquetColumn is single column of the parquet file
+type parquetColumn struct {
+	name     string
+	kind     columnKind
+	optional bool
+}
+
+// value returns parquet value of v, which has to fit the column
+func (c *parquetColumn) value(v interface{}) (parquet.Value, error) {
+	switch c.kind {
+	case kindInt:
+		switch n := v.(type) {
+		case int64:
+			return parquet.Int64Value(n), nil
+		case json.Number:
+			i, err := n.Int64()
+			if err == nil {
+				return parquet.Int64Value(i), nil
+			}
+		}
+	case kindDouble:
+		if n, ok := v.(json.Number); ok {
+			f, err := n.Float64()
+			if err == nil {
+				return parquet.DoubleValue(f), nil
+			}
+		}
+	case kindBool:
+		if b, ok := v.(bool); ok {
+			return parquet.BooleanValue(b), nil
+		}
+	case kindJSON:
+		text, err := jsonCell(v)
+		if err != nil {
+			return parquet.Value{}, err
+		}
+		return parquet.ByteArrayValue([]byte(text)), nil
+	default:
+		if s, ok := v.(string); ok {
+			return parquet.ByteArrayValue([]byte(s)), nil
+		}
+	}
+	return parquet.Value{}, fmt.Errorf("Value of field %q does not fit %v column", c.name, c.kind)
+}
+
+// parquetWriter writes documents as rows of parquet file. Top level
+// document fields are columns, nested objects and arrays are JSON text.
+type parquetWriter struct {
+	cf      *couchdbfile.CouchDbFile
+	w       *parquet.Writer
+	dbName  string
+	columns []parquetColumn
+	index   map[string]int
+	values  []interface{}
+	row     parquet.Row
+}
+
+// newParquetWriter returns writer of parquet format. Columns come from
+// JSON Schema given by --schema flag, or are inferred by reading all
+// documents first.
+func newParquetWriter(out *dataOutput) (documentWriter, error) {
+	var (
+		newWriter parquetWriter
+	)
+	pw := &newWriter
+	pw.cf = out.cf
+	pw.dbName = out.dbName
+	flags := out.cmd.Flags()
+	schemaPath, err := flags.GetString("schema")
+	if err != nil {
+		return nil, err
+	}
+	rowGroupSize, err := flags.GetInt64("row-group-size")
+	if err != nil {
+		return nil, err
+	}
+	var kinds map[string]columnKind
+	if schemaPath != "" {
+		kinds, err = readJSONSchema(schemaPath)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if out.kinds == nil {
+			out.kinds, err = inferColumnKinds(out)
+			if err != nil {
+				return nil, err
+			}
+		}
+		kinds = out.kinds
+	}
+
+	group := parquet.Group{}
+	for name, kind := range parquetMeta {
+		group[name] = kind.node()
+	}
+	for name, kind := range kinds {
+		if _, ok := parquetMeta[name]; ok {
+			// Metadata takes precedence, same as in JSON lines
+			continue
+		}
+		group[name] = parquet.Optional(kind.node())
+	}
+	schema := parquet.NewSchema(pw.dbName, group)
+	// Columns are in schema order, which is the order of values in a row
+	pw.index = make(map[string]int, len(group))
+	for i, path := range schema.Columns() {
+		name := path[0]
+		kind, meta := parquetMeta[name]
+		if !meta {
+			kind = kinds[name]
+		}
+		pw.columns = append(pw.columns, parquetColumn{name: name, kind: kind, optional: !meta})
+		pw.index[name] = i
+	}
+	pw.values = make([]interface{}, len(pw.columns))
+	pw.row = make(parquet.Row, len(pw.columns))
+	pw.w = parquet.NewWriter(out.w, schema,
+		parquet.MaxRowsPerRowGroup(rowGroupSize),
+		parquet.Compression(&parquet.Snappy))
+	return pw, nil
+}
+
+// inferColumnKinds returns kinds of top level fields of all documents
+func inferColumnKinds(out *dataOutput) (map[string]columnKind, error) {
+	kinds := make(map[string]columnKind)
+	err := scanDocuments(out, func(di *couchdbfile.DocumentInfo) error {
+		var body jsonser.OrderedMap
+		err := out.cf.UnmarshalDocument(di, &body)
+		if err != nil {
+			return err
+		}
+		for _, key := range body.Keys() {
+			value, _ := body.Get(key)
+			kind, ok := kinds[key]
+			if !ok {
+				kinds[key] = kindOf(value)
+				continue
+			}
+			kinds[key] = mergeKinds(kind, kindOf(value))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slog.Infof("Inferred %d columns.", len(kinds))
+	return kinds, nil
+}
+
+// jsonSchema is the part of JSON Schema used for parquet columns
+type jsonSchema struct {
+	Properties map[string]struct {
+		// Type is name of the type or list of names
+		Type interface{} `json:"type"`
+	} `json:"properties"`
+}
+
+// jsonSchemaKinds are column kinds of JSON Schema types
+var jsonSchemaKinds = map[string]columnKind{
+	"integer": kindInt,
+	"number":  kindDouble,
+	"boolean": kindBool,
+	"string":  kindString,
+}
+
+// readJSONSchema returns column kinds of properties of JSON Schema file.
+// Nullable types are allowed, properties of other types or of several
+// types are JSON columns.
+func readJSONSchema(filename string) (map[string]columnKind, error) {
+	schemaBytes, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var schema jsonSchema
+	err = json.Unmarshal(schemaBytes, &schema)
+	if err != nil {
+		return nil, fmt.Errorf("Can not read JSON Schema %s: %w", filename, err)
+	}
+	kinds := make(map[string]columnKind, len(schema.Properties))
+	for name, property := range schema.Properties {
+		var types []string
+		switch t := property.Type.(type) {
+		case string:
+			types = append(types, t)
+		case []interface{}:
+			for _, e := range t {
+				if s, ok := e.(string); ok && s != "null" {
+					types = append(types, s)
+				}
+			}
+		}
+		kind, ok := kindJSON, false
+		if len(types) == 1 {
+			kind, ok = jsonSchemaKinds[types[0]]
+		}
+		if !ok {
+			kind = kindJSON
+		}
+		kinds[name] = kind
+	}
+	return kinds, nil
+}
+
+// writeDocument implements documentWriter
+func (pw *parquetWriter) writeDocument(di *couchdbfile.DocumentInfo) error {
+	var body jsonser.OrderedMap
+	err := pw.cf.UnmarshalDocument(di, &body)
+	if err != nil {
+		return err
+	}
+	for i := range pw.values {
+		pw.values[i] = nil
+	}
+	for _, key := range body.Keys() {
+		i, ok := pw.index[key]
+		if !ok || !pw.columns[i].optional {
+			continue
+		}
+		pw.values[i], _ = body.Get(key)
+	}
+	pw.values[pw.index["_id"]] = string(di.ID)
+	pw.values[pw.index["_rev"]] = di.Rev()
+	pw.values[pw.index["_deleted"]] = di.Deleted != 0
+	pw.values[pw.index["_seq"]] = di.UpdateSeq
+	pw.values[pw.index["_db"]] = pw.dbName
+
+	// Row is complete before it is written, so failing document leaves
+	// no partial row in the output
+	for i := range pw.columns {
+		c := &pw.columns[i]
+		v := pw.values[i]
+		if v == nil {
+			pw.row[i] = parquet.NullValue().Level(0, 0, i)
+			continue
+		}
+		value, err := c.value(v)
+		if err != nil {
+			return couchdbfile.NewDocumentError(di, err)
+		}
+		definitionLevel := 0
+		if c.optional {
+			definitionLevel = 1
+		}
+		pw.row[i] = value.Level(0, definitionLevel, i)
+	}
+	_, err = pw.w.WriteRows([]parquet.Row{pw.row})
+	return err
+}
+
+// close implements documentWriter, it writes the last row group and the
+// file footer
+func (pw *parquetWriter) close() error {
+	return pw.w.Close()
+}
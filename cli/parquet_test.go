@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/pipedrive/uncouch/internal/couchtest"
+)
+
+// readParquet returns schema and rows of parquet file
+func readParquet(t *testing.T, path string) (*parquet.Schema, []parquet.Row) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf, err := parquet.OpenFile(f, info.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := parquet.NewReader(pf)
+	defer reader.Close()
+	rows := make([]parquet.Row, pf.NumRows())
+	n, err := reader.ReadRows(rows)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	return pf.Schema(), rows[:n]
+}
+
+func TestParquet(t *testing.T) {
+	path := couchtest.File(t,
+		couchtest.Doc("doc1", 1, "r1", `{"n":1,"x":7,"o":{"k":"v"},"nul":null,"s":"a","b":true,"_id":"body"}`),
+		couchtest.Doc("doc2", 2, "r2", `{"n":2.5,"x":null,"o":[1,"two"],"s":"b"}`),
+		couchtest.Deleted("doc3", 3, "r3"),
+	)
+	out := filepath.Join(t.TempDir(), "out.parquet")
+	if _, err := runData(t, path, "-f", "parquet", "-o", out); err != nil {
+		t.Fatal(err)
+	}
+	schema, rows := readParquet(t, out)
+
+	wantColumns := []struct {
+		name     string
+		kind     parquet.Kind
+		logical  string
+		optional bool
+	}{
+		{"_db", parquet.ByteArray, "STRING", false},
+		{"_deleted", parquet.Boolean, "", false},
+		{"_id", parquet.ByteArray, "STRING", false},
+		{"_rev", parquet.ByteArray, "STRING", false},
+		{"_seq", parquet.Int64, "INT(64,true)", false},
+		{"b", parquet.Boolean, "", true},
+		{"n", parquet.Double, "", true},
+		{"nul", parquet.ByteArray, "STRING", true},
+		{"o", parquet.ByteArray, "JSON", true},
+		{"s", parquet.ByteArray, "STRING", true},
+		{"x", parquet.Int64, "INT(64,true)", true},
+	}
+	fields := schema.Fields()
+	if len(fields) != len(wantColumns) {
+		t.Fatalf("got schema %v", schema)
+	}
+	for i, want := range wantColumns {
+		field := fields[i]
+		logical := ""
+		if lt := field.Type().LogicalType(); lt != nil {
+			logical = lt.String()
+		}
+		if field.Name() != want.name || field.Type().Kind() != want.kind ||
+			logical != want.logical || field.Optional() != want.optional {
+			t.Errorf("column %d is %s %v %s optional %v, want %+v",
+				i, field.Name(), field.Type().Kind(), logical, field.Optional(), want)
+		}
+	}
+
+	// Values are written as value/definition level, null values as null
+	want := [][]string{
+		{"test/0", "false/0", "doc1/0", "1-r1/0", "1/0", "true/1", "1/1", "null/0", `{"k":"v"}/1`, "a/1", "7/1"},
+		{"test/0", "false/0", "doc2/0", "2-r2/0", "2/0", "null/0", "2.5/1", "null/0", `[1,"two"]/1`, "b/1", "null/0"},
+		{"test/0", "true/0", "doc3/0", "3-r3/0", "3/0", "null/0", "null/0", "null/0", "null/0", "null/0", "null/0"},
+	}
+	var got [][]string
+	for _, row := range rows {
+		var values []string
+		for _, v := range row {
+			text := v.String()
+			if v.IsNull() {
+				text = "null"
+			}
+			values = append(values, fmt.Sprintf("%s/%d", text, v.DefinitionLevel()))
+		}
+		got = append(got, values)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got rows\n%v\nwant\n%v", got, want)
+	}
+}
@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"io"
+
+	"github.com/pipedrive/uncouch"
+	"github.com/pipedrive/uncouch/couchdbfile"
+	"github.com/pipedrive/uncouch/jsonser"
+)
+
+// transformers are added by AddTransformer
+var transformers []uncouch.Transformer
+
+// AddTransformer adds transformer applied by the data command to
+// documents of jsonl format and formats added by RegisterSink, after
+// metadata fields are set. Custom binaries call it before Cli.
+func AddTransformer(t uncouch.Transformer) {
+	transformers = append(transformers, t)
+}
+
+// NewSinkFunc returns sink of data format writing into w
+type NewSinkFunc func(w io.Writer, dbName string) (uncouch.DocumentSink, error)
+
+// RegisterSink adds data format of name writing documents into sink
+// returned by newSink, ext is file name extension used when output is a
+// directory. Custom binaries call it before Cli.
+func RegisterSink(name, ext string, newSink NewSinkFunc) {
+	dataFormats[name] = dataFormat{ext: ext, newWriter: func(out *dataOutput) (documentWriter, error) {
+		sink, err := newSink(out.w, out.dbName)
+		if err != nil {
+			return nil, err
+		}
+		return newPipelineWriter(out, sink), nil
+	}}
+}
+
+// pipelineWriter passes documents through metadata and added transformers
+// into sink. Bodies are streamed from the file unless a transformer reads
+// them.
+type pipelineWriter struct {
+	cf       *couchdbfile.CouchDbFile
+	pipeline *uncouch.Pipeline
+}
+
+// newPipelineWriter returns pipelineWriter writing into sink
+func newPipelineWriter(out *dataOutput, sink uncouch.DocumentSink) *pipelineWriter {
+	var (
+		newWriter pipelineWriter
+	)
+	pw := &newWriter
+	pw.cf = out.cf
+	meta := metaTransformer{dbField: jsonser.StringField("_db", out.dbName)}
+	pw.pipeline = uncouch.NewPipeline(sink, append([]uncouch.Transformer{meta}, transformers...)...)
+	return pw
+}
+
+// writeDocument implements documentWriter
+func (pw *pipelineWriter) writeDocument(di *couchdbfile.DocumentInfo) error {
+	kept, err := pw.pipeline.Process(uncouch.NewFileDocument(pw.cf, di))
+	if err != nil {
+		return err
+	}
+	if !kept {
+		return errSkipDocument
+	}
+	return nil
+}
+
+// close implements documentWriter
+func (pw *pipelineWriter) close() error {
+	return pw.pipeline.Close()
+}
+
+// metaTransformer sets metadata fields of jsonl documents
+type metaTransformer struct {
+	dbField jsonser.Field
+}
+
+// Transform implements uncouch.Transformer
+func (mt metaTransformer) Transform(doc *uncouch.Document) (*uncouch.Document, bool, error) {
+	for _, f := range metaFields(doc.ID, doc.Deleted, mt.dbField) {
+		doc.SetField(uncouch.Field(f))
+	}
+	return doc, true, nil
+}
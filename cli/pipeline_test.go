@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pipedrive/uncouch/internal/couchtest"
+)
+
+// brokenFile returns database of documents "a", "b" and "c" where body
+// of "b" can not be decoded
+func brokenFile(t *testing.T) string {
+	t.Helper()
+	path := couchtest.File(t,
+		couchtest.Doc("a", 1, "a1", `{"v":1}`),
+		couchtest.Doc("b", 1, "b1", `{"broken":"body with some length"}`),
+		couchtest.Doc("c", 1, "c1", `{"v":3}`),
+	)
+	file, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Value of "broken" gets unknown term tag
+	i := bytes.Index(file, []byte("body with"))
+	file[i-5] = 0xff
+	if err = os.WriteFile(path, file, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestJSONLinesSkipsFailingDocument(t *testing.T) {
+	got, err := runData(t, brokenFile(t), "--on-error", "skip")
+	var exitErr *exitCodeError
+	if !errors.As(err, &exitErr) || exitErr.code != exitSkipped {
+		t.Fatalf("got error %v, want exit code %d", err, exitSkipped)
+	}
+	want := `{"_id":"a","_db":"test","_deleted":0,"v":1}` + "\n" + `{"_id":"c","_db":"test","_deleted":0,"v":3}` + "\n"
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestJSONLinesStrictStopsAtFailingDocument(t *testing.T) {
+	got, err := runData(t, brokenFile(t), "--on-error", "strict")
+	if err == nil || !strings.Contains(err.Error(), `"b"`) {
+		t.Fatalf("got error %v, want failure of document b", err)
+	}
+	// Bodies are streamed, so part of "b" may be written before it fails
+	if strings.Contains(got, `"c"`) {
+		t.Errorf("document after the failing one was written:\n%s", got)
+	}
+}
@@ -0,0 +1,376 @@
+package cli
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pipedrive/uncouch/couchdbfile"
+	"github.com/spf13/cobra"
+)
+
+// Compressions of output parts
+const (
+	compressNone = "none"
+	compressGzip = "gzip"
+	compressZstd = "zstd"
+)
+
+// compressExts are file name extensions of compressions
+var compressExts = map[string]string{
+	compressGzip: ".gz",
+	compressZstd: ".zst",
+}
+
+// sinkOptions are settings of data command outputs
+type sinkOptions struct {
+	// template is output file name with {part} and {shard}, empty for
+	// stdout
+	template string
+	compress string
+	// parts are rotated after maxSize bytes or maxDocs documents, zero
+	// does not rotate
+	maxSize int64
+	maxDocs int
+	shards  int
+}
+
+// sinkFlags returns sink options of --out, --compress, --rotate-size,
+// --rotate-docs and --split-by-hash flags. Output naming a directory gets
+// <db>.<format> file inside it, with part and shard numbers when needed.
+func sinkFlags(cmd *cobra.Command, dbName string, format dataFormat) (*sinkOptions, error) {
+	var (
+		opts sinkOptions
+	)
+	flags := cmd.Flags()
+	output, err := flags.GetString("output")
+	if err != nil {
+		return nil, err
+	}
+	if opts.compress, err = flags.GetString("compress"); err != nil {
+		return nil, err
+	}
+	rotateSize, err := flags.GetString("rotate-size")
+	if err != nil {
+		return nil, err
+	}
+	if opts.maxSize, err = parseSize(rotateSize); err != nil {
+		return nil, err
+	}
+	if opts.maxDocs, err = flags.GetInt("rotate-docs"); err != nil {
+		return nil, err
+	}
+	if opts.shards, err = flags.GetInt("split-by-hash"); err != nil {
+		return nil, err
+	}
+	if opts.maxDocs < 0 || opts.shards < 0 {
+		return nil, fmt.Errorf("Rotate docs and split by hash can not be negative")
+	}
+	rotating := opts.maxSize > 0 || opts.maxDocs > 0
+	if output == "" {
+		if rotating || opts.shards > 1 {
+			return nil, fmt.Errorf("Rotating and splitting by hash need --out")
+		}
+	} else {
+		dir, err := outputDir(output)
+		if err != nil {
+			return nil, err
+		}
+		opts.template = strings.ReplaceAll(output, "{db}", dbName)
+		if dir != "" {
+			name := dbName
+			if opts.shards > 1 {
+				name += "-{shard}"
+			}
+			if rotating {
+				name += "-{part}"
+			}
+			name += "." + format.ext
+			if opts.compress != "" {
+				name += compressExts[opts.compress]
+			}
+			opts.template = filepath.Join(dir, name)
+		}
+		if rotating && !strings.Contains(opts.template, "{part}") {
+			return nil, fmt.Errorf("Output %s needs {part} when rotating", output)
+		}
+		if opts.shards > 1 && !strings.Contains(opts.template, "{shard}") {
+			return nil, fmt.Errorf("Output %s needs {shard} when splitting by hash", output)
+		}
+	}
+	if opts.compress == "" {
+		// Compression follows file name extension
+		opts.compress = compressNone
+		for compress, ext := range compressExts {
+			if strings.HasSuffix(opts.template, ext) {
+				opts.compress = compress
+			}
+		}
+	}
+	if _, ok := compressExts[opts.compress]; !ok && opts.compress != compressNone {
+		return nil, fmt.Errorf("Unknown compression %q, expecting gzip, zstd or none", opts.compress)
+	}
+	return &opts, nil
+}
+
+// parseSize returns bytes of size like 500K, 100M or 2G
+func parseSize(size string) (int64, error) {
+	if size == "" {
+		return 0, nil
+	}
+	multiplier := int64(1)
+	switch strings.ToUpper(size[len(size)-1:]) {
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		size = size[:len(size)-1]
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("Invalid size %q, expecting bytes with optional K, M or G suffix", size)
+	}
+	return n * multiplier, nil
+}
+
+// dataSinks writes documents into outputs, spread by hash of _id when
+// split by hash. It implements documentWriter.
+type dataSinks struct {
+	sinks []*outputSink
+}
+
+// newDataSinks returns sinks of the options, each with first part open
+func newDataSinks(cmd *cobra.Command, out *dataOutput, format dataFormat, opts *sinkOptions) (*dataSinks, error) {
+	var (
+		newSinks dataSinks
+	)
+	ds := &newSinks
+	shards := opts.shards
+	if shards < 1 {
+		shards = 1
+	}
+	width := len(strconv.Itoa(shards - 1))
+	for i := 0; i < shards; i++ {
+		s := &outputSink{
+			out:       out,
+			newWriter: format.newWriter,
+			opts:      opts,
+			stdout:    cmd.OutOrStdout(),
+			template:  strings.ReplaceAll(opts.template, "{shard}", fmt.Sprintf("%0*d", width, i)),
+		}
+		ds.sinks = append(ds.sinks, s)
+		err := s.openPart()
+		if err != nil {
+			ds.abort()
+			return nil, err
+		}
+	}
+	return ds, nil
+}
+
+// writeDocument implements documentWriter
+func (ds *dataSinks) writeDocument(di *couchdbfile.DocumentInfo) error {
+	if len(ds.sinks) == 1 {
+		return ds.sinks[0].writeDocument(di)
+	}
+	h := fnv.New32a()
+	h.Write(di.ID)
+	return ds.sinks[h.Sum32()%uint32(len(ds.sinks))].writeDocument(di)
+}
+
+// close implements documentWriter, it completes the last parts
+func (ds *dataSinks) close() error {
+	files := 0
+	for _, s := range ds.sinks {
+		err := s.closePart()
+		if err != nil {
+			return err
+		}
+		files += s.files
+	}
+	if files > 1 {
+		slog.Infof("Wrote %d files.", files)
+	}
+	return nil
+}
+
+// abort removes parts not completed, it does nothing after close
+func (ds *dataSinks) abort() {
+	for _, s := range ds.sinks {
+		s.abort()
+	}
+}
+
+// outputSink writes documents of single output into parts, files named
+// by template or stdout. Each part has its own documentWriter, so it is
+// complete with header or footer of the format. Files are written under
+// temporary name and renamed into place once complete.
+type outputSink struct {
+	out       *dataOutput
+	newWriter newDocumentWriter
+	opts      *sinkOptions
+	stdout    io.Writer
+	template  string
+	part      int
+	files     int
+	// dw writes the current part, it is nil between parts
+	dw      documentWriter
+	w       *bufio.Writer
+	zw      io.WriteCloser
+	counter *countingWriter
+	file    *os.File
+	name    string
+	docs    int
+}
+
+// countingWriter counts bytes written into file
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write implements io.Writer
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// writeDocument implements documentWriter, starting next part when the
+// current one is full
+func (s *outputSink) writeDocument(di *couchdbfile.DocumentInfo) error {
+	if s.dw == nil {
+		err := s.openPart()
+		if err != nil {
+			return err
+		}
+	}
+	err := s.dw.writeDocument(di)
+	if err != nil {
+		return err
+	}
+	s.docs++
+	if s.opts.maxDocs > 0 && s.docs >= s.opts.maxDocs {
+		return s.closePart()
+	}
+	// Compressed size is known after compressor flushes, parts of
+	// compressed output get a little bigger than max size
+	if s.opts.maxSize > 0 && s.counter.n+int64(s.w.Buffered()) >= s.opts.maxSize {
+		return s.closePart()
+	}
+	return nil
+}
+
+// openPart starts next part and its documentWriter
+func (s *outputSink) openPart() error {
+	s.part++
+	s.docs = 0
+	var w io.Writer = s.stdout
+	if s.template != "" {
+		s.name = strings.ReplaceAll(s.template, "{part}", fmt.Sprintf("%06d", s.part))
+		dir, base := filepath.Split(s.name)
+		if dir == "" {
+			dir = "."
+		}
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			return err
+		}
+		// Temporary file is in the same directory, so rename is atomic
+		s.file, err = os.CreateTemp(dir, "."+base+".*.tmp")
+		if err != nil {
+			return err
+		}
+		// Temporary files are private, parts get the usual permissions
+		err = s.file.Chmod(0644)
+		if err != nil {
+			return err
+		}
+		s.counter = &countingWriter{w: s.file}
+		w = s.counter
+	}
+	switch s.opts.compress {
+	case compressGzip:
+		s.zw = gzip.NewWriter(w)
+		w = s.zw
+	case compressZstd:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return err
+		}
+		s.zw = zw
+		w = zw
+	}
+	s.w = bufio.NewWriter(w)
+	// Writers take w when created, so output is shared by all parts and
+	// keeps columns found for the first one
+	s.out.w = s.w
+	dw, err := s.newWriter(s.out)
+	if err != nil {
+		return err
+	}
+	s.dw = dw
+	return nil
+}
+
+// closePart completes the current part and renames its file into place
+func (s *outputSink) closePart() error {
+	if s.dw == nil {
+		return nil
+	}
+	err := s.dw.close()
+	s.dw = nil
+	if err != nil {
+		return err
+	}
+	err = s.w.Flush()
+	if err != nil {
+		return err
+	}
+	if s.zw != nil {
+		err = s.zw.Close()
+		s.zw = nil
+		if err != nil {
+			return err
+		}
+	}
+	if s.file == nil {
+		return nil
+	}
+	err = s.file.Sync()
+	if err == nil {
+		err = s.file.Close()
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(s.file.Name(), s.name)
+	if err != nil {
+		return err
+	}
+	slog.Debugf("Wrote %s.", s.name)
+	s.file = nil
+	s.files++
+	return nil
+}
+
+// abort removes temporary file of the current part
+func (s *outputSink) abort() {
+	if s.file == nil {
+		return
+	}
+	s.file.Close()
+	os.Remove(s.file.Name())
+	s.file = nil
+}
@@ -0,0 +1,279 @@
+package cli
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pipedrive/uncouch/internal/couchtest"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"0", 0, false},
+		{"100", 100, false},
+		{"500K", 500 << 10, false},
+		{"500k", 500 << 10, false},
+		{"2M", 2 << 20, false},
+		{"1G", 1 << 30, false},
+		{"-1", 0, true},
+		{"K", 0, true},
+		{"1.5M", 0, true},
+		{"10T", 0, true},
+		{"abc", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.size)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d, error %v", tt.size, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSinkFlags(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		args     []string
+		template string
+		compress string
+		wantErr  bool
+	}{
+		{"stdout", nil, "", compressNone, false},
+		{"rotating stdout", []string{"--rotate-docs", "10"}, "", "", true},
+		{"splitting stdout", []string{"--split-by-hash", "2"}, "", "", true},
+		{"file", []string{"-o", "out.jsonl"}, "out.jsonl", compressNone, false},
+		{"database name", []string{"-o", "{db}.jsonl"}, "test.jsonl", compressNone, false},
+		{"rotating without part", []string{"-o", "out.jsonl", "--rotate-size", "1M"}, "", "", true},
+		{"rotating", []string{"-o", "out-{part}.jsonl", "--rotate-docs", "10"}, "out-{part}.jsonl", compressNone, false},
+		{"splitting without shard", []string{"-o", "out-{part}.jsonl", "--split-by-hash", "3"}, "", "", true},
+		{"single shard", []string{"-o", "out.jsonl", "--split-by-hash", "1"}, "out.jsonl", compressNone, false},
+		{"directory", []string{"-o", dir}, filepath.Join(dir, "test.jsonl"), compressNone, false},
+		{"directory with parts and shards", []string{"-o", dir, "--split-by-hash", "3", "--rotate-docs", "5", "--compress", "gzip"},
+			filepath.Join(dir, "test-{shard}-{part}.jsonl.gz"), compressGzip, false},
+		{"gzip extension", []string{"-o", "out.jsonl.gz"}, "out.jsonl.gz", compressGzip, false},
+		{"zstd extension", []string{"-o", "out.jsonl.zst"}, "out.jsonl.zst", compressZstd, false},
+		{"compression over extension", []string{"-o", "out.jsonl.gz", "--compress", "none"}, "out.jsonl.gz", compressNone, false},
+		{"unknown compression", []string{"-o", "out.jsonl", "--compress", "lz4"}, "", "", true},
+		{"negative docs", []string{"-o", "out-{part}.jsonl", "--rotate-docs", "-1"}, "", "", true},
+		{"invalid size", []string{"-o", "out-{part}.jsonl", "--rotate-size", "big"}, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, _, err := newRootCommand(new(bool)).Find([]string{"data"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = cmd.ParseFlags(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			opts, err := sinkFlags(cmd, "test", dataFormats["jsonl"])
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("got %+v, want error", opts)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opts.template != tt.template || opts.compress != tt.compress {
+				t.Errorf("got template %q and compression %s, want %q and %s", opts.template, opts.compress, tt.template, tt.compress)
+			}
+		})
+	}
+}
+
+// sinkDocs returns database of documents doc0 to doc9 and its JSON lines
+func sinkDocs(t *testing.T) (string, []string) {
+	t.Helper()
+	var docs []couchtest.Document
+	for i := 0; i < 10; i++ {
+		docs = append(docs, couchtest.Doc(fmt.Sprintf("doc%d", i), 1, "r", fmt.Sprintf(`{"n":%d,"text":"some text to take space"}`, i)))
+	}
+	path := couchtest.File(t, docs...)
+	out, err := runData(t, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(out, "\n")
+	return path, lines[:len(lines)-1]
+}
+
+// readParts returns content of files in dir by name, decompressed by
+// decompress when set. It fails on temporary files left behind.
+func readParts(t *testing.T, dir string, decompress func(io.Reader) (io.Reader, error)) map[string]string {
+	t.Helper()
+	parts := make(map[string]string)
+	for _, name := range fileNames(t, dir) {
+		if strings.HasSuffix(name, ".tmp") {
+			t.Errorf("temporary file %s left behind", name)
+			continue
+		}
+		path := filepath.Join(dir, name)
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0644 {
+			t.Errorf("%s has mode %v", name, info.Mode())
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r io.Reader = f
+		if decompress != nil {
+			if r, err = decompress(f); err != nil {
+				t.Fatal(err)
+			}
+		}
+		data, err := io.ReadAll(r)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[name] = string(data)
+	}
+	return parts
+}
+
+func TestRotateDocs(t *testing.T) {
+	path, lines := sinkDocs(t)
+	dir := t.TempDir()
+	if _, err := runData(t, path, "-o", filepath.Join(dir, "out-{part}.jsonl"), "--rotate-docs", "4"); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"out-000001.jsonl": strings.Join(lines[0:4], ""),
+		"out-000002.jsonl": strings.Join(lines[4:8], ""),
+		"out-000003.jsonl": strings.Join(lines[8:], ""),
+	}
+	if got := readParts(t, dir, nil); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got parts\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestRotateDocsEvenly(t *testing.T) {
+	path, _ := sinkDocs(t)
+	dir := t.TempDir()
+	if _, err := runData(t, path, "-o", filepath.Join(dir, "out-{part}.jsonl"), "--rotate-docs", "5"); err != nil {
+		t.Fatal(err)
+	}
+	// No empty part is started after the last document
+	if names := fileNames(t, dir); fmt.Sprint(names) != "[out-000001.jsonl out-000002.jsonl]" {
+		t.Errorf("got files %v", names)
+	}
+}
+
+func TestRotateSize(t *testing.T) {
+	path, lines := sinkDocs(t)
+	maxSize := 2*len(lines[0]) + 1
+	dir := t.TempDir()
+	if _, err := runData(t, path, "-o", filepath.Join(dir, "out-{part}.jsonl"), "--rotate-size", fmt.Sprint(maxSize)); err != nil {
+		t.Fatal(err)
+	}
+	parts := readParts(t, dir, nil)
+	var names []string
+	for name := range parts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var all string
+	for i, name := range names {
+		part := parts[name]
+		all += part
+		last := strings.LastIndex(strings.TrimSuffix(part, "\n"), "\n") + 1
+		// Part ends with the document reaching max size
+		if i < len(names)-1 && (len(part) < maxSize || last >= maxSize) {
+			t.Errorf("%s has %d bytes, %d before its last document, max size %d", name, len(part), last, maxSize)
+		}
+	}
+	if len(names) != 4 || all != strings.Join(lines, "") {
+		t.Errorf("got %d parts holding\n%s", len(names), all)
+	}
+}
+
+func TestSplitByHash(t *testing.T) {
+	path, lines := sinkDocs(t)
+	dir := t.TempDir()
+	if _, err := runData(t, path, "-o", filepath.Join(dir, "out-{shard}.jsonl"), "--split-by-hash", "3"); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{}
+	for i, line := range lines {
+		h := fnv.New32a()
+		h.Write([]byte(fmt.Sprintf("doc%d", i)))
+		want[fmt.Sprintf("out-%d.jsonl", h.Sum32()%3)] += line
+	}
+	if got := readParts(t, dir, nil); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got shards\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestCompressedOutput(t *testing.T) {
+	path, lines := sinkDocs(t)
+	tests := []struct {
+		name       string
+		args       []string
+		decompress func(io.Reader) (io.Reader, error)
+	}{
+		{"out.jsonl.gz", nil, func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{"out.jsonl.zst", nil, func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) }},
+		{"out.jsonl", []string{"--compress", "gzip"}, func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			args := append([]string{path, "-o", filepath.Join(dir, tt.name)}, tt.args...)
+			if _, err := runData(t, args...); err != nil {
+				t.Fatal(err)
+			}
+			got := readParts(t, dir, tt.decompress)
+			if want := strings.Join(lines, ""); got[tt.name] != want || len(got) != 1 {
+				t.Errorf("got %v, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestFailedOutputLeavesNoTemporaryFiles(t *testing.T) {
+	docs := []couchtest.Document{
+		couchtest.Doc("a", 1, "a1", `{}`),
+		couchtest.Doc("b", 1, "b1", `{}`),
+		couchtest.Doc("c", 1, "c1", `{"broken":"body"}`),
+	}
+	path := couchtest.File(t, docs...)
+	file, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Key of "c" body gets unknown term tag
+	i := bytes.Index(file, []byte("broken"))
+	file[i-5] = 0xff
+	if err = os.WriteFile(path, file, 0644); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	_, err = runData(t, path, "-o", filepath.Join(dir, "out-{part}.jsonl"), "--rotate-docs", "2", "--on-error", "strict")
+	if err == nil {
+		t.Fatal("broken document was written")
+	}
+	// Completed first part stays, the failed one is removed
+	if names := fileNames(t, dir); fmt.Sprint(names) != "[out-000001.jsonl]" {
+		t.Errorf("got files %v", names)
+	}
+}
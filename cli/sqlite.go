@@ -0,0 +1,283 @@
+package cli
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pipedrive/uncouch/couchdbfile"
+	"github.com/pipedrive/uncouch/leakybucket"
+	"github.com/spf13/cobra"
+	// Pure Go SQLite driver, registered as sqlite
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema creates tables of the sqlite command. Bodies are JSON
+// text, so they can be queried with JSON1 functions. Revisions are the
+// path from the first to the current revision of each document.
+const sqliteSchema = `
+PRAGMA journal_mode = OFF;
+PRAGMA synchronous = OFF;
+CREATE TABLE docs (
+	id TEXT PRIMARY KEY,
+	rev TEXT NOT NULL,
+	seq INTEGER NOT NULL,
+	deleted INTEGER NOT NULL,
+	body TEXT
+);
+CREATE TABLE revisions (
+	id TEXT NOT NULL,
+	pos INTEGER NOT NULL,
+	rev TEXT NOT NULL,
+	seq INTEGER,
+	deleted INTEGER NOT NULL,
+	-- stored is 1 when body of the revision is still in the file
+	stored INTEGER NOT NULL,
+	PRIMARY KEY (id, pos)
+);
+CREATE TABLE attachments (
+	id TEXT NOT NULL,
+	rev TEXT NOT NULL,
+	name TEXT NOT NULL,
+	content_type TEXT NOT NULL,
+	length INTEGER NOT NULL,
+	disk_length INTEGER NOT NULL,
+	encoding TEXT NOT NULL,
+	revpos INTEGER NOT NULL,
+	digest TEXT,
+	data BLOB,
+	PRIMARY KEY (id, name)
+);
+`
+
+func cmdSQLiteFunc(cmd *cobra.Command, args []string) error {
+	filename, output := args[0], args[1]
+	f, cf, err := openDocuments(cmd, filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dbName := strings.Split(path.Base(filename), ".")[0]
+	policy, err := errorPolicyFlags(cmd, dbName, cf)
+	if err != nil {
+		return err
+	}
+	defer policy.Close()
+
+	// Tables are created, not appended to
+	_, err = os.Stat(output)
+	if err == nil {
+		return fmt.Errorf("Output %s already exists", output)
+	}
+	// Database is built in temporary file in the same directory and
+	// renamed into place when complete, so failed export leaves nothing
+	// behind
+	dir, base := filepath.Split(output)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, "."+base+".*.tmp")
+	if err != nil {
+		return err
+	}
+	// Does nothing once the file is renamed
+	defer os.Remove(tmp.Name())
+	err = tmp.Chmod(0644)
+	if err == nil {
+		err = tmp.Close()
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	written, err := exportSQLite(cf, policy, tmp.Name())
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), output)
+	if err != nil {
+		return err
+	}
+	err = policy.Close()
+	if err != nil {
+		return err
+	}
+	return policy.finish(written)
+}
+
+// exportSQLite writes documents of the file into new SQLite database and
+// returns number of documents written
+func exportSQLite(cf *couchdbfile.CouchDbFile, policy *errorPolicy, filename string) (int, error) {
+	db, err := sql.Open("sqlite", filename)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	_, err = db.Exec(sqliteSchema)
+	if err != nil {
+		return 0, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	ins, err := newSQLiteInserter(tx)
+	if err != nil {
+		return 0, err
+	}
+	defer ins.Close()
+
+	written := 0
+	c := cf.SeqCursor(0)
+	for {
+		di, err := c.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if err = policy.nodeFailed(err); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		// Everything is read before inserting, so failing document leaves
+		// no rows behind
+		doc, err := readSQLiteDocument(cf, di)
+		if isDocumentError(err) {
+			if err = policy.documentFailed(di, err); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		err = ins.insert(doc)
+		if err != nil {
+			return 0, err
+		}
+		written++
+	}
+	ins.Close()
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return written, db.Close()
+}
+
+// sqliteDocument is everything inserted for single document
+type sqliteDocument struct {
+	di   *couchdbfile.DocumentInfo
+	body string
+	atts []couchdbfile.Attachment
+	data [][]byte
+}
+
+// readSQLiteDocument reads body and attachments of the document. Errors
+// are returned as *couchdbfile.DocumentError.
+func readSQLiteDocument(cf *couchdbfile.CouchDbFile, di *couchdbfile.DocumentInfo) (*sqliteDocument, error) {
+	buf := leakybucket.GetBuffer()
+	defer leakybucket.PutBuffer(buf)
+	err := cf.WriteDocument(di, buf)
+	if err != nil {
+		return nil, err
+	}
+	doc := &sqliteDocument{di: di, body: buf.String()}
+	doc.atts, err = cf.ReadAttachments(di)
+	if err != nil {
+		return nil, err
+	}
+	doc.data = make([][]byte, len(doc.atts))
+	for i := range doc.atts {
+		var data bytes.Buffer
+		err = cf.WriteAttachment(&doc.atts[i], &data)
+		if err != nil {
+			return nil, couchdbfile.NewDocumentError(di, err)
+		}
+		doc.data[i] = data.Bytes()
+	}
+	return doc, nil
+}
+
+// sqliteInserter inserts documents with prepared statements
+type sqliteInserter struct {
+	docs        *sql.Stmt
+	revisions   *sql.Stmt
+	attachments *sql.Stmt
+}
+
+// newSQLiteInserter prepares statements inside the transaction
+func newSQLiteInserter(tx *sql.Tx) (*sqliteInserter, error) {
+	var (
+		newInserter sqliteInserter
+		err         error
+	)
+	ins := &newInserter
+	ins.docs, err = tx.Prepare(`INSERT INTO docs (id, rev, seq, deleted, body) VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return nil, err
+	}
+	ins.revisions, err = tx.Prepare(`INSERT INTO revisions (id, pos, rev, seq, deleted, stored) VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		ins.Close()
+		return nil, err
+	}
+	ins.attachments, err = tx.Prepare(`INSERT INTO attachments (id, rev, name, content_type, length, disk_length, encoding, revpos, digest, data) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		ins.Close()
+		return nil, err
+	}
+	return ins, nil
+}
+
+// insert inserts document with its revisions and attachments
+func (ins *sqliteInserter) insert(doc *sqliteDocument) error {
+	di := doc.di
+	id := string(di.ID)
+	rev := di.Rev()
+	_, err := ins.docs.Exec(id, rev, di.UpdateSeq, di.Deleted != 0, doc.body)
+	if err != nil {
+		return err
+	}
+	for _, r := range di.Revisions {
+		// Only revisions with body know their sequence
+		var seq interface{}
+		if r.Offset >= 0 {
+			seq = r.UpdateSeq
+		}
+		_, err = ins.revisions.Exec(id, r.Pos, r.String(), seq, r.Deleted != 0, r.Offset >= 0)
+		if err != nil {
+			return err
+		}
+	}
+	for i, a := range doc.atts {
+		var digest interface{}
+		if len(a.Digest) > 0 {
+			digest = "md5-" + base64.StdEncoding.EncodeToString(a.Digest)
+		}
+		_, err = ins.attachments.Exec(id, rev, a.Name, a.ContentType, a.Length, a.DiskLength, a.Encoding, a.RevPos, digest, doc.data[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the prepared statements
+func (ins *sqliteInserter) Close() error {
+	for _, stmt := range []*sql.Stmt{ins.docs, ins.revisions, ins.attachments} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package cli
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pipedrive/uncouch/internal/couchtest"
+)
+
+// runSQLite runs sqlite command exporting filename into output
+func runSQLite(filename, output string, args ...string) error {
+	cmd := newRootCommand(new(bool))
+	cmd.SetOut(new(bytes.Buffer))
+	cmd.SetArgs(append([]string{"sqlite", "--log-level", "error", filename, output}, args...))
+	return cmd.Execute()
+}
+
+// queryRows returns rows of the query as text, NULL as <nil>
+func queryRows(t *testing.T, db *sql.DB, query string) [][]string {
+	t.Helper()
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result [][]string
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			t.Fatal(err)
+		}
+		row := make([]string, len(values))
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			row[i] = fmt.Sprint(v)
+		}
+		result = append(result, row)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// fileNames returns names of files in directory
+func fileNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestSQLite(t *testing.T) {
+	path := couchtest.File(t,
+		couchtest.Document{ID: "a", Leaves: []couchtest.Leaf{{
+			Pos:  3,
+			Revs: []string{"a3", "a2", "a1"},
+			Body: `{"name":"Ann","addr":{"city":"Tartu"}}`,
+			Attachments: []couchtest.Attachment{
+				{Name: "note.txt", ContentType: "text/plain", Data: []byte("hello"), RevPos: 2},
+			},
+		}}},
+		couchtest.Deleted("b", 2, "b2"),
+		couchtest.Doc("c", 1, "c1", `{"name":"Cid"}`),
+	)
+	dir := t.TempDir()
+	output := filepath.Join(dir, "out.db")
+	if err := runSQLite(path, output); err != nil {
+		t.Fatal(err)
+	}
+	if names := fileNames(t, dir); !reflect.DeepEqual(names, []string{"out.db"}) {
+		t.Errorf("got files %v, want only out.db", names)
+	}
+	db, err := sql.Open("sqlite", output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		name  string
+		query string
+		want  [][]string
+	}{
+		{"docs", `SELECT id, rev, seq, deleted, json_extract(body, '$.addr.city') FROM docs ORDER BY id`, [][]string{
+			{"a", "3-a3", "1", "0", "Tartu"},
+			{"b", "2-b2", "2", "1", "<nil>"},
+			{"c", "1-c1", "3", "0", "<nil>"},
+		}},
+		{"revisions", `SELECT id, pos, rev, seq, deleted, stored FROM revisions ORDER BY id, pos`, [][]string{
+			{"a", "1", "1-a1", "<nil>", "0", "0"},
+			{"a", "2", "2-a2", "<nil>", "0", "0"},
+			{"a", "3", "3-a3", "1", "0", "1"},
+			{"b", "2", "2-b2", "2", "1", "1"},
+			{"c", "1", "1-c1", "3", "0", "1"},
+		}},
+		{"attachments", `SELECT id, rev, name, content_type, length, disk_length, encoding, revpos, digest, data FROM attachments`, [][]string{
+			{"a", "3-a3", "note.txt", "text/plain", "5", "5", "identity", "2", "md5-XUFAKrxLKna5cZ2REBfFkg==", "hello"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := queryRows(t, db, tt.query); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSQLiteFailureLeavesNoOutput(t *testing.T) {
+	data := []byte("broken attachment")
+	path := couchtest.File(t,
+		couchtest.Doc("a", 1, "a1", `{}`),
+		couchtest.Document{ID: "b", Leaves: []couchtest.Leaf{{
+			Revs:        []string{"b1"},
+			Attachments: []couchtest.Attachment{{Name: "x", ContentType: "text/plain", Data: data, RevPos: 1}},
+		}}},
+	)
+	file, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Attachment chunk claims to be bigger than the file
+	i := bytes.Index(file, data)
+	copy(file[i-4:], []byte{0x7f, 0xff, 0xff, 0xff})
+	if err = os.WriteFile(path, file, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err = runSQLite(path, filepath.Join(dir, "out.db"), "--on-error", "strict"); err == nil {
+		t.Fatal("export of broken attachment succeeded")
+	}
+	if names := fileNames(t, dir); len(names) != 0 {
+		t.Errorf("got files %v after failed export", names)
+	}
+}
+
+func TestSQLiteExistingOutput(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "out.db")
+	if err := os.WriteFile(output, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runSQLite(couchtest.File(t), output); err == nil {
+		t.Fatal("existing output was overwritten")
+	}
+	if data, _ := os.ReadFile(output); string(data) != "keep" {
+		t.Errorf("existing output changed to %q", data)
+	}
+}
@@ -11,12 +11,10 @@ import (
 func writeHeaders(cf *couchdbfile.CouchDbFile, outputdir string) error {
 	err := dumpIDNodeHeaders(cf, cf.Header.IDTreeState.Offset, outputdir)
 	if err != nil {
-		slog.Error(err)
 		return err
 	}
 	err = dumpSeqNodeHeaders(cf, cf.Header.SeqTreeState.Offset, outputdir)
 	if err != nil {
-		slog.Error(err)
 		return err
 	}
 	return nil
@@ -25,19 +23,16 @@ func writeHeaders(cf *couchdbfile.CouchDbFile, outputdir string) error {
 func writeNodeToFile(cf *couchdbfile.CouchDbFile, offset int64, filename string) error {
 	f, err := os.Create(filename)
 	if err != nil {
-		slog.Error(err)
 		return err
 	}
 	defer f.Close()
 	buf, err := cf.ReadNodeBytes(offset)
 	if err != nil {
-		slog.Error(err)
 		return err
 	}
 	defer leakybucket.PutBytes(buf)
 	_, err = f.Write(*buf)
 	if err != nil {
-		slog.Error(err)
 		return err
 	}
 	return nil
@@ -47,21 +42,18 @@ func dumpIDNodeHeaders(cf *couchdbfile.CouchDbFile, offset int64, outputdir stri
 	for {
 		kpNode, kvNode, err := cf.ReadIDNode(offset)
 		if err != nil {
-			slog.Error(err)
 			return err
 		}
 		if kpNode != nil {
 			filename := fmt.Sprintf("id-kp-%d.bin", offset)
 			err := writeNodeToFile(cf, offset, path.Join(outputdir, filename))
 			if err != nil {
-				slog.Error(err)
 				return err
 			}
 			// Pointer node, dig deeper
 			for _, node := range kpNode.Pointers {
 				err = dumpIDNodeHeaders(cf, node.Offset, outputdir)
 				if err != nil {
-					slog.Error(err)
 					return err
 				}
 			}
@@ -70,7 +62,6 @@ func dumpIDNodeHeaders(cf *couchdbfile.CouchDbFile, offset int64, outputdir stri
 			filename := fmt.Sprintf("id-kv-%d.bin", offset)
 			err := writeNodeToFile(cf, offset, path.Join(outputdir, filename))
 			if err != nil {
-				slog.Error(err)
 				return err
 			}
 			return nil
@@ -82,21 +73,18 @@ func dumpSeqNodeHeaders(cf *couchdbfile.CouchDbFile, offset int64, outputdir str
 	for {
 		kpNode, kvNode, err := cf.ReadSeqNode(offset)
 		if err != nil {
-			slog.Error(err)
 			return err
 		}
 		if kpNode != nil {
 			filename := fmt.Sprintf("seq-kp-%d.bin", offset)
 			err := writeNodeToFile(cf, offset, path.Join(outputdir, filename))
 			if err != nil {
-				slog.Error(err)
 				return err
 			}
 			// Pointer node, dig deeper
 			for _, node := range kpNode.Pointers {
 				err = dumpSeqNodeHeaders(cf, node.Offset, outputdir)
 				if err != nil {
-					slog.Error(err)
 					return err
 				}
 			}
@@ -105,7 +93,6 @@ func dumpSeqNodeHeaders(cf *couchdbfile.CouchDbFile, offset int64, outputdir str
 			filename := fmt.Sprintf("seq-kv-%d.bin", offset)
 			err := writeNodeToFile(cf, offset, path.Join(outputdir, filename))
 			if err != nil {
-				slog.Error(err)
 				return err
 			}
 			return nil
@@ -117,7 +104,6 @@ func processIDNode(cf *couchdbfile.CouchDbFile, offset int64) error {
 	for {
 		kpNode, kvNode, err := cf.ReadIDNode(offset)
 		if err != nil {
-			slog.Error(err)
 			return err
 		}
 		if kpNode != nil {
@@ -125,7 +111,6 @@ func processIDNode(cf *couchdbfile.CouchDbFile, offset int64) error {
 			for _, node := range kpNode.Pointers {
 				err = processIDNode(cf, node.Offset)
 				if err != nil {
-					slog.Error(err)
 					return err
 				}
 			}
@@ -135,7 +120,6 @@ func processIDNode(cf *couchdbfile.CouchDbFile, offset int64) error {
 			for _, document := range kvNode.Documents {
 				err = cf.WriteDocument(&document, output)
 				if err != nil {
-					slog.Error(err)
 					return err
 				}
 			}
@@ -151,18 +135,16 @@ func processSeqNode(cf *couchdbfile.CouchDbFile, offset int64) error {
 	for {
 		kpNode, kvNode, err := cf.ReadSeqNode(offset)
 		if err != nil {
-			slog.Error(err)
 			return err
 		}
 		if kpNode != nil && kvNode != nil {
-			log.Info("Empty Node.")
+			slog.Info("Empty Node.")
 		}
 		if kpNode != nil {
 			// Pointer node, dig deeper
 			for _, node := range kpNode.Pointers {
 				err = processSeqNode(cf, node.Offset)
 				if err != nil {
-					slog.Error(err)
 					return err
 				}
 			}
@@ -172,7 +154,6 @@ func processSeqNode(cf *couchdbfile.CouchDbFile, offset int64) error {
 			for _, document := range kvNode.Documents {
 				err = cf.WriteDocument(&document, output)
 				if err != nil {
-					slog.Error(err)
 					return err
 				}
 			}
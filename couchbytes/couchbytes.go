@@ -19,18 +19,18 @@ const (
 	snappyPrefix   = 1
 	magicNumber    = 131
 	deflateSuffix  = 80
+	// binaryExt is tag of Erlang binary term
+	binaryExt = 109
 )
 
 // ReadDbHeaderBytes reads DB header from input Reader at given offset and returns it as byte array
 func ReadDbHeaderBytes(input io.ReadSeeker, offset int64) (*[]byte, error) {
 	dataSize, bytesSkipped, err := readUint32Skip4K(input, offset)
 	if err != nil {
-		slog.Error(err)
 		return nil, err
 	}
 	buf, _, err := readAndSkip4K(input, offset+4+bytesSkipped, dataSize)
 	if err != nil {
-		slog.Error(err)
 		return nil, err
 	}
 	/*
@@ -38,13 +38,11 @@ func ReadDbHeaderBytes(input io.ReadSeeker, offset int64) (*[]byte, error) {
 		var md5Hash [16]byte
 		err = binary.Read(bufReader, binary.BigEndian, &md5Hash)
 		if err != nil {
-			slog.Error(err)
 			return nil, err
 		}
 		var magicNumber uint8
 		err = binary.Read(bufReader, binary.BigEndian, &magicNumber)
 		if err != nil {
-			slog.Error(err)
 			return nil, err
 		}*/
 	t := (*buf)[17:]
@@ -55,57 +53,126 @@ func ReadDbHeaderBytes(input io.ReadSeeker, offset int64) (*[]byte, error) {
 func ReadNodeBytes(input io.ReadSeeker, offset int64) (*[]byte, error) {
 	dataSize, bytesSkipped, err := readUint32Skip4K(input, offset)
 	if err != nil {
-		slog.Error(err)
 		return nil, err
 	}
 	buf, _, err := readAndSkip4K(input, offset+4+bytesSkipped, dataSize)
 	if err != nil {
-		slog.Error(err)
 		return nil, err
 	}
-	return uncompressBuffer(buf)
+	return uncompressBuffer(buf, offset)
 }
 
 // ReadDocumentBytes reads actual stored document from input Reader at given offset and returns it as byte array
 func ReadDocumentBytes(input io.ReadSeeker, offset int64) (*[]byte, error) {
-	combinedSize, bytesSkipped, err := readUint32Skip4K(input, offset)
+	buf, docSize, err := readSummaryChunk(input, offset)
+	if err != nil {
+		return nil, err
+	}
+	/*
+		md5Hash := (*buf)[:16]
+		slog.Debug(hex.EncodeToString(md5Hash))
+	*/
+	docSlice := (*buf)[24 : docSize+24]
+	docBytes, err := uncompressBuffer(&docSlice, offset)
+	if err != nil {
+		return nil, err
+	}
+	return docBytes, nil
+}
+
+// ReadAttachmentsBytes reads list of attachments stored after the document
+// body at given offset and returns it as byte array
+func ReadAttachmentsBytes(input io.ReadSeeker, offset int64) (*[]byte, error) {
+	buf, docSize, err := readSummaryChunk(input, offset)
 	if err != nil {
-		slog.Error(err)
 		return nil, err
 	}
+	// Attachments are the second binary of {Body, Atts} tuple
+	start := 24 + int(docSize)
+	if len(*buf) < start+5 || (*buf)[start] != binaryExt ||
+		int(binary.BigEndian.Uint32((*buf)[start+1:start+5])) > len(*buf)-start-5 {
+		leakybucket.PutBytes(buf)
+		return nil, &CorruptBlockError{Offset: offset, Reason: "attachments longer than the block"}
+	}
+	attsSize := int(binary.BigEndian.Uint32((*buf)[start+1 : start+5]))
+	attsSlice := (*buf)[start+5 : start+5+attsSize]
+	return uncompressBuffer(&attsSlice, offset)
+}
+
+// readSummaryChunk reads document chunk at given offset: MD5 hash and
+// {Body, Atts} tuple of two binaries. It returns the chunk and body size.
+func readSummaryChunk(input io.ReadSeeker, offset int64) (*[]byte, uint32, error) {
+	combinedSize, bytesSkipped, err := readUint32Skip4K(input, offset)
+	if err != nil {
+		return nil, 0, err
+	}
 	md5Flag := (combinedSize & (1 << 31)) >> 31
 	dataSize := combinedSize &^ (1 << 31)
 	// slog.Debugf("Offset: %v md5Flag: %v dataSize: %v", offset, md5Flag, dataSize)
 	if md5Flag != 1 {
-		err := fmt.Errorf("Unknown document block header %v", md5Flag)
-		slog.Error(err)
-		return nil, err
+		return nil, 0, &CorruptBlockError{Offset: offset, Reason: fmt.Sprintf("unknown document block header %v", md5Flag)}
 	}
 	buf, _, err := readAndSkip4K(input, offset+4+bytesSkipped, dataSize+16)
 	if err != nil {
-		slog.Error(err)
+		return nil, 0, err
+	}
+	if len(*buf) < 24 || int(binary.BigEndian.Uint32((*buf)[20:24])) > len(*buf)-24 {
+		leakybucket.PutBytes(buf)
+		return nil, 0, &CorruptBlockError{Offset: offset, Reason: "document body longer than the block"}
+	}
+	return buf, binary.BigEndian.Uint32((*buf)[20:24]), nil
+}
+
+// ReadChunkBytes reads chunk at given offset as it is stored in the file,
+// MD5 hash included and without uncompressing it. Chunks longer than
+// maxSize are reported as corrupt instead of being read.
+func ReadChunkBytes(input io.ReadSeeker, offset int64, maxSize int64) (*[]byte, error) {
+	combinedSize, bytesSkipped, err := readUint32Skip4K(input, offset)
+	if err != nil {
 		return nil, err
 	}
-	/*
-		md5Hash := (*buf)[:16]
-		slog.Debug(hex.EncodeToString(md5Hash))
-	*/
-	docSize := binary.BigEndian.Uint32((*buf)[20:24])
+	dataSize := combinedSize &^ (1 << 31)
+	if combinedSize&(1<<31) != 0 {
+		// MD5 hash precedes the data
+		dataSize += 16
+	}
+	if int64(dataSize) > maxSize {
+		return nil, &CorruptBlockError{Offset: offset, Reason: fmt.Sprintf("chunk size %d exceeds the limit", dataSize)}
+	}
+	buf, _, err := readAndSkip4K(input, offset+4+bytesSkipped, dataSize)
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
 
-	docSlice := (*buf)[24 : docSize+24]
-	docBytes, err := uncompressBuffer(&docSlice)
+// ReadChunkData reads data of chunk at given offset, without MD5 hash and
+// without uncompressing it. Attachments are stored in such chunks.
+// Chunks longer than maxSize are reported as corrupt instead of being read.
+func ReadChunkData(input io.ReadSeeker, offset int64, maxSize int64) (*[]byte, error) {
+	combinedSize, _, err := readUint32Skip4K(input, offset)
 	if err != nil {
-		slog.Error(err)
 		return nil, err
 	}
-	return docBytes, nil
+	buf, err := ReadChunkBytes(input, offset, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	if combinedSize&(1<<31) != 0 {
+		data := (*buf)[16:]
+		return &data, nil
+	}
+	return buf, nil
 }
 
 // uncompressBuffer uncompresses buffer if needed
 // For whatever reason there is inconistancy inside
 // CouchDB on how Snappy and Deflate compressions are
-// described in the data file
-func uncompressBuffer(buf *[]byte) (*[]byte, error) {
+// described in the data file. Offset of the block is used for errors.
+func uncompressBuffer(buf *[]byte, offset int64) (*[]byte, error) {
+	if len(*buf) < 2 {
+		return nil, &CorruptBlockError{Offset: offset, Reason: "block too short"}
+	}
 	b := uint8((*buf)[0])
 	switch b {
 	case snappyPrefix:
@@ -114,8 +181,10 @@ func uncompressBuffer(buf *[]byte) (*[]byte, error) {
 		// Uncompress and go
 		res, err := snappy.Decode(*destBuf, (*buf)[1:])
 		if err != nil {
-			slog.Error(err)
-			return nil, err
+			return nil, &CorruptBlockError{Offset: offset, Reason: "corrupt Snappy block", Err: err}
+		}
+		if len(res) == 0 || res[0] != magicNumber {
+			return nil, &CorruptBlockError{Offset: offset, Reason: "missing magic number in uncompressed block"}
 		}
 		// Release compressed buffer
 		leakybucket.PutBytes(buf)
@@ -126,17 +195,13 @@ func uncompressBuffer(buf *[]byte) (*[]byte, error) {
 		b := uint8((*buf)[1])
 		if b == deflateSuffix {
 			// slog.Debug("Deflate compressed node")
-			err := fmt.Errorf("Deflate un-compression is not implemented yet")
-			slog.Error(err)
-			return nil, err
+			return nil, fmt.Errorf("%w: deflate compressed block at offset %d", ErrUnsupportedCompression, offset)
 		}
 		// slog.Debug("Uncompressed node")
 		t := (*buf)[1:]
 		return &t, nil
 	default:
-		err := fmt.Errorf("Unknown block prefix %v", b)
-		slog.Error(err)
-		return nil, err
+		return nil, &CorruptBlockError{Offset: offset, Reason: fmt.Sprintf("unknown block prefix %v", b)}
 	}
 }
 
@@ -144,7 +209,6 @@ func uncompressBuffer(buf *[]byte) (*[]byte, error) {
 func readUint32Skip4K(input io.ReadSeeker, offset int64) (uint32, int64, error) {
 	buf, bytesSkipped, err := readAndSkip4K(input, offset, 4)
 	if err != nil {
-		slog.Error(err)
 		return 0, 0, err
 	}
 	defer leakybucket.PutBytes(buf)
@@ -152,7 +216,6 @@ func readUint32Skip4K(input io.ReadSeeker, offset int64) (uint32, int64, error)
 	var result uint32
 	err = binary.Read(&bufReader, binary.BigEndian, &result)
 	if err != nil {
-		slog.Error(err)
 		return 0, 0, err
 	}
 	return result, bytesSkipped, nil
@@ -164,10 +227,7 @@ func readAndSkip4K(input io.ReadSeeker, offset int64, dataSize uint32) (*[]byte,
 	// need to be removed before processing
 	_, err := input.Seek(offset, io.SeekStart)
 	if err != nil {
-		if err != io.EOF {
-			slog.Error("Error on seeking while reading buffer.", err)
-			return nil, 0, err
-		}
+		return nil, 0, err
 	}
 	// Get lower bound of 4K multiplier to offset
 	lowerBound := offset / int64(BlockAlignment)
@@ -179,12 +239,14 @@ func readAndSkip4K(input io.ReadSeeker, offset int64, dataSize uint32) (*[]byte,
 
 	// Read into byte array
 	buf := leakybucket.GetBytes(int32(dataSize) + int32(upperBound-lowerBound))
-	_, err = input.Read(*buf)
+	_, err = io.ReadFull(input, *buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		leakybucket.PutBytes(buf)
+		return nil, 0, &CorruptBlockError{Offset: offset, Reason: "block extends past the end of file", Err: io.ErrUnexpectedEOF}
+	}
 	if err != nil {
-		if err != io.EOF {
-			slog.Error("Error reading buffer.", err)
-			return nil, 0, err
-		}
+		leakybucket.PutBytes(buf)
+		return nil, 0, err
 	}
 	for i := upperBound; i > lowerBound; i-- {
 		// Cycle from back to forward and remove byte on 4K boundary
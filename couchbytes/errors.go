@@ -0,0 +1,31 @@
+package couchbytes
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnsupportedCompression is returned for blocks compressed with a
+// method uncouch can not uncompress, such as deflate
+var ErrUnsupportedCompression = errors.New("Unsupported compression")
+
+// CorruptBlockError is returned when block at Offset can not be read
+type CorruptBlockError struct {
+	Offset int64
+	Reason string
+	// Err is the underlying error, if any
+	Err error
+}
+
+// Error implements error
+func (e *CorruptBlockError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("Corrupt block at offset %d: %s: %v", e.Offset, e.Reason, e.Err)
+	}
+	return fmt.Sprintf("Corrupt block at offset %d: %s", e.Offset, e.Reason)
+}
+
+// Unwrap returns the underlying error
+func (e *CorruptBlockError) Unwrap() error {
+	return e.Err
+}
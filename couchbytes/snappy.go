@@ -0,0 +1,161 @@
+package couchbytes
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// snappyWindow is the largest back reference offset we support. Snappy
+// compresses input in 64K blocks and never references data outside the
+// block being compressed.
+const snappyWindow = 64 * 1024
+
+// snappyReader decodes Snappy block format from a stream keeping only
+// the last snappyWindow bytes of decoded data in memory
+type snappyReader struct {
+	r         *bufio.Reader
+	remaining uint64
+	// buf holds window of decoded data followed by data not read yet
+	buf         []byte
+	readPos     int
+	literalLeft int
+	err         error
+	// offset of the block, used for errors
+	offset int64
+}
+
+// newSnappyReader returns reader uncompressing Snappy block format stream
+// of block at offset
+func newSnappyReader(r *bufio.Reader, offset int64) (*snappyReader, error) {
+	z := &snappyReader{
+		r:      r,
+		buf:    make([]byte, 0, 3*snappyWindow),
+		offset: offset,
+	}
+	decodedLength, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, z.corrupt(err)
+	}
+	z.remaining = decodedLength
+	return z, nil
+}
+
+// Read implements io.Reader
+func (z *snappyReader) Read(p []byte) (int, error) {
+	for z.readPos == len(z.buf) {
+		if z.err != nil {
+			return 0, z.err
+		}
+		if z.remaining == 0 && z.literalLeft == 0 {
+			return 0, io.EOF
+		}
+		z.compact()
+		z.err = z.decode()
+	}
+	n := copy(p, z.buf[z.readPos:])
+	z.readPos += n
+	return n, nil
+}
+
+// compact drops decoded data which is already read and not needed
+// for back references any more
+func (z *snappyReader) compact() {
+	if len(z.buf) < 2*snappyWindow {
+		return
+	}
+	n := copy(z.buf, z.buf[len(z.buf)-snappyWindow:])
+	z.buf = z.buf[:n]
+	z.readPos = n
+}
+
+// decode decodes next element of the stream into buf
+func (z *snappyReader) decode() error {
+	if z.literalLeft > 0 {
+		return z.readLiteral()
+	}
+	tag, err := z.r.ReadByte()
+	if err != nil {
+		return z.corrupt(err)
+	}
+	var length, offset int
+	switch tag & 0x03 {
+	case 0x00:
+		length = int(tag >> 2)
+		if length >= 60 {
+			extra := length - 59
+			var b [4]byte
+			_, err := io.ReadFull(z.r, b[:extra])
+			if err != nil {
+				return z.corrupt(err)
+			}
+			length = int(binary.LittleEndian.Uint32(b[:]))
+		}
+		z.literalLeft = length + 1
+		return z.readLiteral()
+	case 0x01:
+		b, err := z.r.ReadByte()
+		if err != nil {
+			return z.corrupt(err)
+		}
+		length = 4 + int(tag>>2)&0x07
+		offset = int(tag&0xe0)<<3 | int(b)
+	case 0x02:
+		var b [2]byte
+		_, err := io.ReadFull(z.r, b[:])
+		if err != nil {
+			return z.corrupt(err)
+		}
+		length = 1 + int(tag>>2)
+		offset = int(binary.LittleEndian.Uint16(b[:]))
+	case 0x03:
+		var b [4]byte
+		_, err := io.ReadFull(z.r, b[:])
+		if err != nil {
+			return z.corrupt(err)
+		}
+		length = 1 + int(tag>>2)
+		offset = int(binary.LittleEndian.Uint32(b[:]))
+	}
+	if offset <= 0 || offset > len(z.buf) || uint64(length) > z.remaining {
+		return z.corrupt(fmt.Errorf("Invalid copy offset %d length %d", offset, length))
+	}
+	// Copy byte by byte as source and destination may overlap
+	start := len(z.buf) - offset
+	for i := 0; i < length; i++ {
+		z.buf = append(z.buf, z.buf[start+i])
+	}
+	z.remaining -= uint64(length)
+	return nil
+}
+
+// readLiteral reads literal bytes, long literals are read in parts
+func (z *snappyReader) readLiteral() error {
+	n := z.literalLeft
+	if free := cap(z.buf) - len(z.buf); n > free {
+		n = free
+	}
+	if uint64(n) > z.remaining {
+		return z.corrupt(errors.New("Literal longer than decoded length"))
+	}
+	start := len(z.buf)
+	z.buf = z.buf[:start+n]
+	_, err := io.ReadFull(z.r, z.buf[start:])
+	if err != nil {
+		z.buf = z.buf[:start]
+		return z.corrupt(err)
+	}
+	z.literalLeft -= n
+	z.remaining -= uint64(n)
+	return nil
+}
+
+// corrupt wraps error of malformed Snappy stream
+func (z *snappyReader) corrupt(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return &CorruptBlockError{Offset: z.offset, Reason: "corrupt Snappy stream", Err: err}
+}
@@ -0,0 +1,105 @@
+package couchbytes
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+
+	"github.com/golang/snappy"
+)
+
+// snappyInput returns n bytes mixing compressible text with random runs,
+// so encoded stream has both long literals and back references
+func snappyInput(n int, seed int64) []byte {
+	rnd := rand.New(rand.NewSource(seed))
+	text := []byte("{\"_id\":\"document\",\"value\":12345,\"tags\":[\"a\",\"b\"]}")
+	b := make([]byte, 0, n)
+	for len(b) < n {
+		if rnd.Intn(3) == 0 {
+			random := make([]byte, rnd.Intn(3*snappyWindow))
+			rnd.Read(random)
+			b = append(b, random...)
+		} else {
+			for i := rnd.Intn(2000); i > 0; i-- {
+				b = append(b, text...)
+			}
+		}
+	}
+	return b[:n]
+}
+
+// readSnappy decodes encoded with snappyReader, reading through wrap
+func readSnappy(encoded []byte, wrap func(io.Reader) io.Reader) ([]byte, error) {
+	z, err := newSnappyReader(bufio.NewReader(bytes.NewReader(encoded)), 0)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(wrap(z))
+}
+
+func TestSnappyReaderRoundTrip(t *testing.T) {
+	random := make([]byte, 5*snappyWindow)
+	rand.New(rand.NewSource(1)).Read(random)
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{"empty", nil},
+		{"single byte", []byte{'x'}},
+		{"window minus one", snappyInput(snappyWindow-1, 2)},
+		{"window", snappyInput(snappyWindow, 3)},
+		{"window plus one", snappyInput(snappyWindow+1, 4)},
+		{"two windows", snappyInput(2*snappyWindow, 5)},
+		{"buffer capacity", snappyInput(3*snappyWindow, 6)},
+		{"buffer capacity plus one", snappyInput(3*snappyWindow+1, 7)},
+		{"repeated byte", bytes.Repeat([]byte{'a'}, 4*snappyWindow+3)},
+		{"incompressible", random},
+		{"mixed", snappyInput(1<<21+17, 8)},
+	}
+	readers := map[string]func(io.Reader) io.Reader{
+		"whole":    func(r io.Reader) io.Reader { return r },
+		"one byte": iotest.OneByteReader,
+		"half":     iotest.HalfReader,
+	}
+	for _, tt := range tests {
+		encoded := snappy.Encode(nil, tt.input)
+		for kind, wrap := range readers {
+			t.Run(tt.name+"/"+kind, func(t *testing.T) {
+				got, err := readSnappy(encoded, wrap)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, tt.input) {
+					t.Errorf("decoded %d bytes differ from %d bytes encoded", len(got), len(tt.input))
+				}
+			})
+		}
+	}
+}
+
+func TestSnappyReaderCorrupt(t *testing.T) {
+	encoded := snappy.Encode(nil, snappyInput(3*snappyWindow, 9))
+	tests := []struct {
+		name    string
+		encoded []byte
+	}{
+		{"truncated", encoded[:len(encoded)/2]},
+		// Copy of 4 bytes from offset 1 with nothing decoded yet
+		{"copy before start", []byte{4, 0x01, 0x01}},
+		// Literal of 5 bytes in stream of decoded length 2
+		{"literal past length", []byte{2, 4 << 2, 'a', 'b', 'c', 'd', 'e'}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readSnappy(tt.encoded, func(r io.Reader) io.Reader { return r })
+			var cbe *CorruptBlockError
+			if !errors.As(err, &cbe) {
+				t.Errorf("got error %v, want CorruptBlockError", err)
+			}
+		})
+	}
+}
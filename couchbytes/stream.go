@@ -0,0 +1,113 @@
+package couchbytes
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// blockReader reads data from CouchDB file skipping the marker byte
+// at the beginning of every 4K block
+type blockReader struct {
+	r   *bufio.Reader
+	pos int64
+}
+
+// newBlockReader returns reader starting from given offset of input
+func newBlockReader(input io.ReadSeeker, offset int64) (*blockReader, error) {
+	_, err := input.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	return &blockReader{r: bufio.NewReader(input), pos: offset}, nil
+}
+
+// Read implements io.Reader
+func (br *blockReader) Read(p []byte) (int, error) {
+	if br.pos%BlockAlignment == 0 {
+		_, err := br.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		br.pos++
+	}
+	if left := BlockAlignment - br.pos%BlockAlignment; int64(len(p)) > left {
+		p = p[:left]
+	}
+	n, err := br.r.Read(p)
+	br.pos += int64(n)
+	return n, err
+}
+
+// NewDocumentReader returns reader streaming document stored at given
+// offset. Reader returns uncompressed Erlang term without the magic number,
+// the same data ReadDocumentBytes returns, without loading it into memory.
+func NewDocumentReader(input io.ReadSeeker, offset int64) (io.Reader, error) {
+	br, err := newBlockReader(input, offset)
+	if err != nil {
+		return nil, err
+	}
+	var header [4]byte
+	_, err = io.ReadFull(br, header[:])
+	if err != nil {
+		return nil, truncated(offset, err)
+	}
+	combinedSize := binary.BigEndian.Uint32(header[:])
+	md5Flag := (combinedSize & (1 << 31)) >> 31
+	if md5Flag != 1 {
+		return nil, &CorruptBlockError{Offset: offset, Reason: fmt.Sprintf("unknown document block header %v", md5Flag)}
+	}
+	// MD5 hash is followed by term_to_binary({Body, Atts}) header up to
+	// the length of Body binary
+	var prefix [24]byte
+	_, err = io.ReadFull(br, prefix[:])
+	if err != nil {
+		return nil, truncated(offset, err)
+	}
+	docSize := binary.BigEndian.Uint32(prefix[20:24])
+	body := bufio.NewReader(io.LimitReader(br, int64(docSize)))
+	return uncompressReader(body, offset)
+}
+
+// truncated converts end of input into CorruptBlockError of block at offset
+func truncated(offset int64, err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return &CorruptBlockError{Offset: offset, Reason: "block extends past the end of file", Err: io.ErrUnexpectedEOF}
+	}
+	return err
+}
+
+// uncompressReader is streaming counterpart of uncompressBuffer
+func uncompressReader(r *bufio.Reader, offset int64) (io.Reader, error) {
+	prefix, err := r.Peek(2)
+	if err != nil {
+		return nil, truncated(offset, err)
+	}
+	switch prefix[0] {
+	case snappyPrefix:
+		r.Discard(1)
+		sr, err := newSnappyReader(r, offset)
+		if err != nil {
+			return nil, err
+		}
+		// Skip the Magic Marker
+		uncompressed := bufio.NewReader(sr)
+		b, err := uncompressed.ReadByte()
+		if err != nil {
+			return nil, truncated(offset, err)
+		}
+		if b != magicNumber {
+			return nil, &CorruptBlockError{Offset: offset, Reason: fmt.Sprintf("unknown magic number %v in uncompressed block", b)}
+		}
+		return uncompressed, nil
+	case magicNumber:
+		if prefix[1] == deflateSuffix {
+			return nil, fmt.Errorf("%w: deflate compressed block at offset %d", ErrUnsupportedCompression, offset)
+		}
+		r.Discard(1)
+		return r, nil
+	default:
+		return nil, &CorruptBlockError{Offset: offset, Reason: fmt.Sprintf("unknown block prefix %v", prefix[0])}
+	}
+}
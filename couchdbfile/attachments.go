@@ -0,0 +1,162 @@
+package couchdbfile
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/pipedrive/uncouch/couchbytes"
+	"github.com/pipedrive/uncouch/erldeser"
+	"github.com/pipedrive/uncouch/leakybucket"
+	"github.com/pipedrive/uncouch/termite"
+)
+
+// Attachment is metadata of attachment stored with document revision
+type Attachment struct {
+	Name        string
+	ContentType string
+	// Length is size of the attachment, DiskLength its size as stored,
+	// which differs for gzip encoded attachments
+	Length     int64
+	DiskLength int64
+	// RevPos is revision number which added the attachment
+	RevPos int64
+	// Digest is MD5 hash of the stored data, if known
+	Digest []byte
+	// Encoding is identity or gzip
+	Encoding string
+	// chunks are offsets of the chunks holding the data
+	chunks []int64
+}
+
+// ReadAttachments reads attachments of the document. Errors are returned
+// as *DocumentError.
+func (cf *CouchDbFile) ReadAttachments(di *DocumentInfo) ([]Attachment, error) {
+	r, _ := di.LastRevision()
+	atts, err := cf.readAttachments(r.Offset)
+	if err != nil {
+		return nil, NewDocumentError(di, err)
+	}
+	return atts, nil
+}
+
+// readAttachments reads attachments of document stored at offset
+func (cf *CouchDbFile) readAttachments(offset int64) ([]Attachment, error) {
+	if offset < 0 {
+		return nil, ErrMissingBody
+	}
+	buf, err := couchbytes.ReadAttachmentsBytes(cf.input, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer leakybucket.PutBytes(buf)
+	s, err := erldeser.NewScanner(*buf)
+	if err != nil {
+		return nil, err
+	}
+	tb, err := termite.NewBuilder()
+	if err != nil {
+		return nil, err
+	}
+	t, err := tb.ReadTermite(s)
+	if err != nil {
+		return nil, err
+	}
+	defer t.Release()
+	terms, err := t.Path().List()
+	if err != nil {
+		return nil, err
+	}
+	atts := make([]Attachment, len(terms))
+	for i, a := range terms {
+		err = atts[i].readFromTermite(a)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return atts, nil
+}
+
+// readFromTermite reads attachment out of Termite structure
+// {Name, Type, Chunks, Length, DiskLength, RevPos, Md5, Encoding}. Files
+// written before gzip support have no Encoding, older ones store single
+// stream pointer instead of list of chunks.
+func (a *Attachment) readFromTermite(n termite.Node) error {
+	size, err := n.Len()
+	if err != nil {
+		return err
+	}
+	if size != 7 && size != 8 {
+		return fmt.Errorf("Unsupported attachment format, tuple of %d elements", size)
+	}
+	name, err := n.Path(0).Binary()
+	if err != nil {
+		return err
+	}
+	a.Name = string(name)
+	contentType, err := n.Path(1).Binary()
+	if err != nil {
+		return err
+	}
+	a.ContentType = string(contentType)
+	chunks, err := n.Path(2).List()
+	if err != nil {
+		return err
+	}
+	a.chunks = make([]int64, len(chunks))
+	for i, c := range chunks {
+		// Chunks are {Offset, Size}, or just Offset in older files
+		if c.Type() == erldeser.SmallTupleExt {
+			c = c.Path(0)
+		}
+		if a.chunks[i], err = c.Int(); err != nil {
+			return err
+		}
+	}
+	if a.Length, err = n.Path(3).Int(); err != nil {
+		return err
+	}
+	if a.DiskLength, err = n.Path(4).Int(); err != nil {
+		return err
+	}
+	if a.RevPos, err = n.Path(5).Int(); err != nil {
+		return err
+	}
+	digest, err := n.Path(6).Binary()
+	if err != nil {
+		return err
+	}
+	a.Digest = append([]byte(nil), digest...)
+	a.Encoding = "identity"
+	if size == 8 {
+		encoding, err := n.Path(7).Atom()
+		if err != nil {
+			return err
+		}
+		// Early gzip support stored true and false
+		switch encoding {
+		case "true":
+			a.Encoding = "gzip"
+		case "false":
+		default:
+			a.Encoding = encoding
+		}
+	}
+	return nil
+}
+
+// WriteAttachment writes data of the attachment as stored, gzip encoded
+// attachments stay encoded
+func (cf *CouchDbFile) WriteAttachment(a *Attachment, output io.Writer) error {
+	for _, offset := range a.chunks {
+		buf, err := couchbytes.ReadChunkData(cf.input, offset, cf.size-offset)
+		if err != nil {
+			return err
+		}
+		_, err = output.Write(*buf)
+		leakybucket.PutBytes(buf)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -5,6 +5,8 @@ package couchdbfile
 
 import (
 	"io"
+
+	"github.com/pipedrive/uncouch/jsonser"
 )
 
 // CouchDbFile is main interface to interact with single CouchDB file
@@ -12,6 +14,9 @@ type CouchDbFile struct {
 	Header DbHeader
 	input  io.ReadSeeker
 	size   int64
+	policy jsonser.UTF8Policy
+	ascii  bool
+	floats jsonser.FloatFormat
 }
 
 // New will return CouchDbFile
@@ -25,9 +30,24 @@ func New(input io.ReadSeeker, size int64) (cf *CouchDbFile, err error) {
 	cf.size = size
 	header, err := cf.ReadDbHeader()
 	if err != nil {
-		slog.Error(err)
 		return nil, err
 	}
 	cf.Header = *header
 	return cf, nil
 }
+
+// SetUTF8Policy sets how invalid UTF-8 in documents is written
+func (cf *CouchDbFile) SetUTF8Policy(policy jsonser.UTF8Policy) {
+	cf.policy = policy
+}
+
+// SetASCII makes documents ASCII only, non-ASCII characters are written
+// as \uXXXX escapes
+func (cf *CouchDbFile) SetASCII(ascii bool) {
+	cf.ascii = ascii
+}
+
+// SetFloatFormat sets how floats in documents are written
+func (cf *CouchDbFile) SetFloatFormat(format jsonser.FloatFormat) {
+	cf.floats = format
+}
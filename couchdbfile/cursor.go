@@ -0,0 +1,214 @@
+package couchdbfile
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Cursor walks documents of ID or Sequence Btree in key order, reading
+// one leaf node at a time
+type Cursor struct {
+	cf    *CouchDbFile
+	seq   bool
+	since int64
+	// stack holds offsets of nodes still to visit, next one on top
+	stack []int64
+	docs  []DocumentInfo
+	pos   int
+}
+
+// IDCursor returns cursor over ID Btree, documents come ordered by ID
+func (cf *CouchDbFile) IDCursor() *Cursor {
+	return cf.newCursor(cf.Header.IDTreeState.Offset, false, 0)
+}
+
+// SeqCursor returns cursor over Sequence Btree, documents come ordered
+// by update sequence. Documents with sequence up to since are skipped.
+func (cf *CouchDbFile) SeqCursor(since int64) *Cursor {
+	return cf.newCursor(cf.Header.SeqTreeState.Offset, true, since)
+}
+
+// newCursor returns cursor starting at root node offset
+func (cf *CouchDbFile) newCursor(root int64, seq bool, since int64) *Cursor {
+	var (
+		newCursor Cursor
+	)
+	c := &newCursor
+	c.cf = cf
+	c.seq = seq
+	c.since = since
+	// Empty tree has no root node
+	if root != 0 {
+		c.stack = append(c.stack, root)
+	}
+	return c
+}
+
+// Next returns next document info. It returns io.EOF after the last one.
+// When node can not be read, the error is returned and the next call
+// continues after the node.
+func (c *Cursor) Next() (*DocumentInfo, error) {
+	for {
+		for c.pos < len(c.docs) {
+			di := &c.docs[c.pos]
+			c.pos++
+			if c.seq && di.UpdateSeq <= c.since {
+				continue
+			}
+			return di, nil
+		}
+		if len(c.stack) == 0 {
+			return nil, io.EOF
+		}
+		offset := c.stack[len(c.stack)-1]
+		c.stack = c.stack[:len(c.stack)-1]
+		err := c.readNode(offset)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readNode reads node at offset, pushing children of pointer node to
+// the stack or keeping documents of leaf node
+func (c *Cursor) readNode(offset int64) error {
+	c.docs = nil
+	c.pos = 0
+	if c.seq {
+		kpNode, kvNode, err := c.cf.ReadSeqNode(offset)
+		if err != nil {
+			return err
+		}
+		if kvNode != nil {
+			c.docs = kvNode.Documents
+			return nil
+		}
+		// Pushed in reverse, so the first child is visited first
+		for i := len(kpNode.Pointers) - 1; i >= 0; i-- {
+			// Pointer key is the last key of the subtree
+			if kpNode.Pointers[i].Seq > c.since {
+				c.stack = append(c.stack, kpNode.Pointers[i].Offset)
+			}
+		}
+		return nil
+	}
+	kpNode, kvNode, err := c.cf.ReadIDNode(offset)
+	if err != nil {
+		return err
+	}
+	if kvNode != nil {
+		c.docs = kvNode.Documents
+		return nil
+	}
+	for i := len(kpNode.Pointers) - 1; i >= 0; i-- {
+		c.stack = append(c.stack, kpNode.Pointers[i].Offset)
+	}
+	return nil
+}
+
+// LookupID finds document info by document ID in ID Btree. It returns
+// nil when there is no such document.
+func (cf *CouchDbFile) LookupID(id []byte) (*DocumentInfo, error) {
+	offset := cf.Header.IDTreeState.Offset
+	for offset != 0 {
+		kpNode, kvNode, err := cf.ReadIDNode(offset)
+		if err != nil {
+			return nil, err
+		}
+		if kvNode != nil {
+			i := sort.Search(len(kvNode.Documents), func(i int) bool {
+				return bytes.Compare(kvNode.Documents[i].ID, id) >= 0
+			})
+			if i < len(kvNode.Documents) && bytes.Equal(kvNode.Documents[i].ID, id) {
+				return &kvNode.Documents[i], nil
+			}
+			return nil, nil
+		}
+		// Pointer key is the last key of the subtree
+		i := sort.Search(len(kpNode.Pointers), func(i int) bool {
+			return bytes.Compare(kpNode.Pointers[i].Key, id) >= 0
+		})
+		if i == len(kpNode.Pointers) {
+			return nil, nil
+		}
+		offset = kpNode.Pointers[i].Offset
+	}
+	return nil, nil
+}
+
+// DocCounts returns number of live and deleted documents from the
+// reductions stored in ID Btree root
+func (cf *CouchDbFile) DocCounts() (docCount, deletedCount int64, err error) {
+	offset := cf.Header.IDTreeState.Offset
+	if offset == 0 {
+		return 0, 0, nil
+	}
+	kpNode, kvNode, err := cf.ReadIDNode(offset)
+	if err != nil {
+		return 0, 0, err
+	}
+	if kpNode != nil {
+		for _, p := range kpNode.Pointers {
+			docCount += p.Count
+			deletedCount += p.Count2
+		}
+		return docCount, deletedCount, nil
+	}
+	for _, di := range kvNode.Documents {
+		if di.Deleted != 0 {
+			deletedCount++
+		} else {
+			docCount++
+		}
+	}
+	return docCount, deletedCount, nil
+}
+
+// String returns revision in CouchDB "Pos-RevId" form
+func (r *Revision) String() string {
+	return strconv.FormatInt(r.Pos, 10) + "-" + r.ID()
+}
+
+// ID returns revision id without the revision number
+func (r *Revision) ID() string {
+	// MD5 based revision ids are shown as hex, others as they are
+	if len(r.RevID) == 16 {
+		return hex.EncodeToString(r.RevID)
+	}
+	return string(r.RevID)
+}
+
+// wins returns true when r is chosen over other leaf revision the way
+// CouchDB picks the winning revision: live revisions first, then the
+// highest position, then the highest revision id
+func (r *Revision) wins(other *Revision) bool {
+	if (r.Deleted == 0) != (other.Deleted == 0) {
+		return r.Deleted == 0
+	}
+	if r.Pos != other.Pos {
+		return r.Pos > other.Pos
+	}
+	return bytes.Compare(r.RevID, other.RevID) > 0
+}
+
+// LastRevision returns the revision document body is read from. Documents
+// of corrupt nodes may have no revisions, then it returns revision with
+// offset -1 and false.
+func (di *DocumentInfo) LastRevision() (Revision, bool) {
+	if len(di.Revisions) == 0 {
+		return Revision{Offset: -1}, false
+	}
+	return di.Revisions[len(di.Revisions)-1], true
+}
+
+// Rev returns the revision document body is read from
+func (di *DocumentInfo) Rev() string {
+	r, ok := di.LastRevision()
+	if !ok {
+		return ""
+	}
+	return r.String()
+}
@@ -0,0 +1,155 @@
+package couchdbfile
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/pipedrive/uncouch/internal/couchtest"
+)
+
+// openTestFile opens database of documents "a" to "i" in two kv_nodes
+// under kp_node root, "b" is deleted
+func openTestFile(t *testing.T) *CouchDbFile {
+	t.Helper()
+	docs := []couchtest.Document{couchtest.Doc("a", 1, "a1", `{}`), couchtest.Deleted("b", 2, "b2")}
+	for _, id := range []string{"c", "d", "e", "f", "g", "i"} {
+		docs = append(docs, couchtest.Doc(id, 1, id+"1", `{}`))
+	}
+	return openFile(t, docs...)
+}
+
+// openFile opens database of docs
+func openFile(t *testing.T, docs ...couchtest.Document) *CouchDbFile {
+	t.Helper()
+	f, err := os.Open(couchtest.File(t, docs...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf, err := New(f, fi.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cf
+}
+
+func TestLookupID(t *testing.T) {
+	cf := openTestFile(t)
+	tests := []struct {
+		id string
+		// rev is empty when the document is not found
+		rev     string
+		deleted int8
+	}{
+		{"a", "1-a1", 0},
+		{"b", "2-b2", 1},
+		{"d", "1-d1", 0},
+		{"e", "1-e1", 0},
+		{"i", "1-i1", 0},
+		{"", "", 0},
+		{"0", "", 0},
+		{"dd", "", 0},
+		{"h", "", 0},
+		{"j", "", 0},
+	}
+	for _, tt := range tests {
+		di, err := cf.LookupID([]byte(tt.id))
+		if err != nil {
+			t.Fatalf("%q: %v", tt.id, err)
+		}
+		if tt.rev == "" {
+			if di != nil {
+				t.Errorf("%q: found %s", tt.id, di.ID)
+			}
+			continue
+		}
+		if di == nil {
+			t.Errorf("%q: not found", tt.id)
+			continue
+		}
+		if string(di.ID) != tt.id || di.Rev() != tt.rev || di.Deleted != tt.deleted {
+			t.Errorf("%q: got %s %s deleted %d, want %s deleted %d", tt.id, di.ID, di.Rev(), di.Deleted, tt.rev, tt.deleted)
+		}
+	}
+}
+
+func TestCursors(t *testing.T) {
+	cf := openTestFile(t)
+	read := func(c *Cursor) (ids string) {
+		for {
+			di, err := c.Next()
+			if err == io.EOF {
+				return ids
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids += string(di.ID)
+		}
+	}
+	if ids := read(cf.IDCursor()); ids != "abcdefgi" {
+		t.Errorf("got %s of ID cursor", ids)
+	}
+	if ids := read(cf.SeqCursor(3)); ids != "defgi" {
+		t.Errorf("got %s of sequence cursor since 3", ids)
+	}
+	docCount, deletedCount, err := cf.DocCounts()
+	if err != nil || docCount != 7 || deletedCount != 1 {
+		t.Errorf("got %d documents and %d deleted, %v", docCount, deletedCount, err)
+	}
+}
+
+// revStrings returns pos-id strings of revisions
+func revStrings(revs []Revision) []string {
+	var s []string
+	for i := range revs {
+		s = append(s, revs[i].String())
+	}
+	return s
+}
+
+func TestRevisionTree(t *testing.T) {
+	tests := []struct {
+		name   string
+		leaves []couchtest.Leaf
+		// path is from the root to the winning leaf
+		path        []string
+		otherLeaves []string
+		deleted     int8
+	}{
+		{"single revision", []couchtest.Leaf{{Pos: 2, Revs: []string{"b", "a"}}},
+			[]string{"1-a", "2-b"}, nil, 0},
+		{"longer branch wins", []couchtest.Leaf{{Pos: 2, Revs: []string{"x", "a"}}, {Pos: 3, Revs: []string{"c", "b", "a"}}},
+			[]string{"1-a", "2-b", "3-c"}, []string{"2-x"}, 0},
+		{"higher revision id wins", []couchtest.Leaf{{Pos: 2, Revs: []string{"c", "a"}}, {Pos: 2, Revs: []string{"b", "a"}}},
+			[]string{"1-a", "2-c"}, []string{"2-b"}, 0},
+		{"live branch wins over longer deleted", []couchtest.Leaf{{Pos: 3, Revs: []string{"d", "b", "a"}, Deleted: true}, {Pos: 2, Revs: []string{"c", "a"}}},
+			[]string{"1-a", "2-c"}, []string{"3-d"}, 0},
+		{"all branches deleted", []couchtest.Leaf{{Pos: 3, Revs: []string{"d", "b", "a"}, Deleted: true}, {Pos: 2, Revs: []string{"z", "a"}, Deleted: true}},
+			[]string{"1-a", "2-b", "3-d"}, []string{"2-z"}, 1},
+		{"three leaves", []couchtest.Leaf{{Pos: 2, Revs: []string{"b", "a"}}, {Pos: 2, Revs: []string{"d", "a"}}, {Pos: 2, Revs: []string{"c", "a"}}},
+			[]string{"1-a", "2-d"}, []string{"2-b", "2-c"}, 0},
+		{"stemmed roots", []couchtest.Leaf{{Pos: 3, Revs: []string{"f3", "f2"}}, {Pos: 5, Revs: []string{"e5", "e4"}}},
+			[]string{"4-e4", "5-e5"}, []string{"3-f3"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cf := openFile(t, couchtest.Document{ID: "doc", Leaves: tt.leaves})
+			di, err := cf.LookupID([]byte("doc"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			path, others := revStrings(di.Revisions), revStrings(di.OtherLeaves)
+			if !reflect.DeepEqual(path, tt.path) || !reflect.DeepEqual(others, tt.otherLeaves) || di.Deleted != tt.deleted {
+				t.Errorf("got path %v, other leaves %v, deleted %d, want %v, %v, %d",
+					path, others, di.Deleted, tt.path, tt.otherLeaves, tt.deleted)
+			}
+		})
+	}
+}
@@ -29,19 +29,20 @@ func (dbh *DbHeader) findHeader(input io.ReadSeeker, size int64) (offset int64,
 	for {
 		if latestBlockIndex < 0 {
 			// We reached beginning of the file and didn't find DB header block, something must be wrong
-			err = fmt.Errorf("Could not find DB Header block in the file")
-			slog.Error(err)
-			return -1, err
+			return -1, ErrNoHeader
 		}
 		offset, err = input.Seek(latestBlockIndex*couchbytes.BlockAlignment, io.SeekStart)
 		if err != nil {
-			slog.Error(err)
 			return -1, err
 		}
 		var headerFlag uint8
 		err = binary.Read(input, binary.BigEndian, &headerFlag)
+		if err == io.EOF {
+			// File size is multiple of the block size
+			latestBlockIndex--
+			continue
+		}
 		if err != nil {
-			slog.Error(err)
 			return -1, err
 		}
 		switch headerFlag {
@@ -51,38 +52,59 @@ func (dbh *DbHeader) findHeader(input io.ReadSeeker, size int64) (offset int64,
 			offset++
 			return offset, err
 		default:
-			err := fmt.Errorf("Unknown DB Header starting byte %v", headerFlag)
-			slog.Error(err)
-			return -1, err
+			return -1, corruptNode(offset, fmt.Sprintf("unknown DB Header starting byte %v", headerFlag), nil)
 		}
 	}
 }
 
 // readFromTermite reads header structure out of Termite structure
 func (dbh *DbHeader) readFromTermite(t *termite.Termite) error {
-	if string(t.Children[0].T.Binary) != "db_header" {
-		err := fmt.Errorf("Term header is \"%s\". Expecting \"db_header\"", string(t.Children[0].T.Binary))
-		slog.Error(err)
+	name, err := t.Path(0).Atom()
+	if err != nil {
 		return err
 	}
-	dbh.DiskVersion = uint8(t.Children[1].T.IntegerValue)
-	dbh.UpdateSeq = int32(t.Children[2].T.IntegerValue)
-
-	if len(t.Children[4].Children) >= 3 {
-		dbh.IDTreeState.Offset = int64(t.Children[4].Children[0].T.IntegerValue)
-		dbh.IDTreeState.Size = int32(t.Children[4].Children[2].T.IntegerValue)
-	} else {
-		dbh.IDTreeState.Offset = 0
-		dbh.IDTreeState.Size = 0
+	if name != "db_header" {
+		return fmt.Errorf("Term header is \"%s\". Expecting \"db_header\"", name)
 	}
+	diskVersion, err := t.Path(1).Int()
+	if err != nil {
+		return err
+	}
+	dbh.DiskVersion = uint8(diskVersion)
+	updateSeq, err := t.Path(2).Int()
+	if err != nil {
+		return err
+	}
+	dbh.UpdateSeq = int32(updateSeq)
 
-	if len(t.Children[5].Children) >= 3 {
-		dbh.SeqTreeState.Offset = int64(t.Children[5].Children[0].T.IntegerValue)
-		dbh.SeqTreeState.Size = int32(t.Children[5].Children[2].T.IntegerValue)
-	} else {
-		dbh.SeqTreeState.Offset = 0
-		dbh.SeqTreeState.Size = 0
+	if err = dbh.IDTreeState.readFromNode(t.Path(4)); err != nil {
+		return err
 	}
+	if err = dbh.SeqTreeState.readFromNode(t.Path(5)); err != nil {
+		return err
+	}
+	return nil
+}
 
+// readFromNode reads {Offset, Reduction, Size} btree state. Empty
+// tree is stored as nil and leaves the state zeroed.
+func (ts *TreeState) readFromNode(n termite.Node) error {
+	ts.Offset = 0
+	ts.Size = 0
+	if n.IsNil() {
+		return nil
+	}
+	offset, err := n.Path(0).Int()
+	if err != nil {
+		return err
+	}
+	ts.Offset = offset
+	if length, _ := n.Len(); length >= 3 {
+		size, err := n.Path(2).Int()
+		if err != nil {
+			return err
+		}
+		ts.Size = int32(size)
+	}
 	return nil
 }
@@ -2,44 +2,173 @@ package couchdbfile
 
 import (
 	"bytes"
+	"io"
 
+	"github.com/pipedrive/uncouch/binser"
 	"github.com/pipedrive/uncouch/couchbytes"
 	"github.com/pipedrive/uncouch/erldeser"
 	"github.com/pipedrive/uncouch/jsonser"
 	"github.com/pipedrive/uncouch/leakybucket"
 )
 
-type CouchDbDocument struct {
-	Id      string
-	Deleted int8
-	Rev     string
-	Value   map[string]interface{}
+// WriteDocument writes document as JSON object into output buffer.
+// Fields are written at the beginning of the object, replacing document
+// fields with the same keys. Errors are returned as *DocumentError.
+func (cf *CouchDbFile) WriteDocument(di *DocumentInfo, output *bytes.Buffer, fields ...jsonser.Field) error {
+	r, _ := di.LastRevision()
+	err := cf.writeDocument(r.Offset, output, fields)
+	if err != nil {
+		return NewDocumentError(di, err)
+	}
+	return nil
 }
 
-// WriteDocument writes document as JSON object into output buffer
-func (cf *CouchDbFile) WriteDocument(di *DocumentInfo, output *bytes.Buffer) error {
+// writeDocument writes document stored at offset into output buffer
+func (cf *CouchDbFile) writeDocument(offset int64, output *bytes.Buffer, fields []jsonser.Field) error {
+	if offset < 0 {
+		return ErrMissingBody
+	}
 	// Get buffer
-	docBytes, err := couchbytes.ReadDocumentBytes(cf.input, di.Revisions[len(di.Revisions)-1].Offset)
+	docBytes, err := couchbytes.ReadDocumentBytes(cf.input, offset)
 	if err != nil {
-		slog.Error(err)
 		return err
 	}
 	defer leakybucket.PutBytes(docBytes)
 	scanner, err := erldeser.NewScanner(*docBytes)
 	if err != nil {
-		slog.Error(err)
 		return err
 	}
 	js, err := jsonser.New(scanner)
 	if err != nil {
-		slog.Error(err)
 		return err
 	}
-	err = js.WriteJSONToBuffer(output)
+	js.SetUTF8Policy(cf.policy)
+	js.SetASCII(cf.ascii)
+	js.SetFloatFormat(cf.floats)
+	if len(fields) > 0 {
+		err = js.WriteJSONWithFields(output, fields)
+	} else {
+		err = js.WriteJSONToBuffer(output)
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// EncodeDocument writes document as CBOR or MessagePack map into output
+// buffer, encoded straight from the stored terms. Fields are written as by
+// WriteDocument. Errors are returned as *DocumentError.
+func (cf *CouchDbFile) EncodeDocument(di *DocumentInfo, output *bytes.Buffer, format binser.Format, fields ...binser.Field) error {
+	r, _ := di.LastRevision()
+	err := cf.encodeDocument(r.Offset, output, format, fields)
+	if err != nil {
+		return NewDocumentError(di, err)
+	}
+	return nil
+}
+
+// encodeDocument writes document stored at offset into output buffer
+func (cf *CouchDbFile) encodeDocument(offset int64, output *bytes.Buffer, format binser.Format, fields []binser.Field) error {
+	if offset < 0 {
+		return ErrMissingBody
+	}
+	docBytes, err := couchbytes.ReadDocumentBytes(cf.input, offset)
+	if err != nil {
+		return err
+	}
+	defer leakybucket.PutBytes(docBytes)
+	scanner, err := erldeser.NewScanner(*docBytes)
+	if err != nil {
+		return err
+	}
+	enc, err := binser.NewEncoder(scanner, format)
+	if err != nil {
+		return err
+	}
+	enc.SetUTF8Policy(cf.policy)
+	encoded, err := enc.AppendDocument(output.AvailableBuffer(), fields)
+	if err != nil {
+		return err
+	}
+	_, err = output.Write(encoded)
+	return err
+}
+
+// UnmarshalDocument decodes document body into v, which has to be non-nil
+// pointer. Body is decoded straight from the file, following the rules of
+// json.Decoder with UseNumber including struct tags. Errors are returned
+// as *DocumentError.
+func (cf *CouchDbFile) UnmarshalDocument(di *DocumentInfo, v interface{}) error {
+	r, _ := di.LastRevision()
+	err := cf.unmarshalDocument(r.Offset, v)
+	if err != nil {
+		return NewDocumentError(di, err)
+	}
+	return nil
+}
+
+// unmarshalDocument decodes document stored at offset into v
+func (cf *CouchDbFile) unmarshalDocument(offset int64, v interface{}) error {
+	if offset < 0 {
+		return ErrMissingBody
+	}
+	docBytes, err := couchbytes.ReadDocumentBytes(cf.input, offset)
+	if err != nil {
+		return err
+	}
+	defer leakybucket.PutBytes(docBytes)
+	scanner, err := erldeser.NewScanner(*docBytes)
 	if err != nil {
-		slog.Error(err)
 		return err
 	}
+	dec := jsonser.NewDecoder(scanner)
+	dec.SetUTF8Policy(cf.policy)
+	dec.SetFloatFormat(cf.floats)
+	return dec.DecodeInto(v)
+}
 
+// StreamDocument writes document as JSON object into output writer.
+// Document is read from the file as a stream, so memory use does not
+// depend on the document size. Fields are written as by WriteDocument.
+// Errors are returned as *DocumentError.
+func (cf *CouchDbFile) StreamDocument(di *DocumentInfo, output io.Writer, fields ...jsonser.Field) error {
+	r, _ := di.LastRevision()
+	err := cf.streamDocument(r.Offset, output, fields)
+	if err != nil {
+		return NewDocumentError(di, err)
+	}
+	return nil
+}
+
+// streamDocument writes document stored at offset into output writer
+func (cf *CouchDbFile) streamDocument(offset int64, output io.Writer, fields []jsonser.Field) error {
+	if offset < 0 {
+		return ErrMissingBody
+	}
+	docReader, err := couchbytes.NewDocumentReader(cf.input, offset)
+	if err != nil {
+		return err
+	}
+	scanner, err := erldeser.NewReaderScanner(docReader)
+	if err != nil {
+		return err
+	}
+	js, err := jsonser.New(scanner)
+	if err != nil {
+		return err
+	}
+	js.SetUTF8Policy(cf.policy)
+	js.SetASCII(cf.ascii)
+	js.SetFloatFormat(cf.floats)
+	if len(fields) > 0 {
+		err = js.WriteJSONWithFields(output, fields)
+	} else {
+		err = js.WriteJSON(output)
+	}
+	if err != nil {
+		return err
+	}
 	return nil
 }
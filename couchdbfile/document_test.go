@@ -0,0 +1,28 @@
+package couchdbfile
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDocumentWithoutRevisions(t *testing.T) {
+	var cf CouchDbFile
+	di := &DocumentInfo{ID: []byte("no-revisions")}
+	if r, ok := di.LastRevision(); ok || r.Offset != -1 {
+		t.Errorf("got last revision %+v and %v, want offset -1 and false", r, ok)
+	}
+	if rev := di.Rev(); rev != "" {
+		t.Errorf("got rev %q, want none", rev)
+	}
+	var output bytes.Buffer
+	err := cf.WriteDocument(di, &output)
+	var docErr *DocumentError
+	if !errors.As(err, &docErr) || docErr.Offset != -1 || !errors.Is(err, ErrMissingBody) {
+		t.Errorf("got error %v, want DocumentError at offset -1 for missing body", err)
+	}
+	_, err = cf.ReadAttachments(di)
+	if !errors.Is(err, ErrMissingBody) {
+		t.Errorf("got error %v reading attachments, want missing body", err)
+	}
+}
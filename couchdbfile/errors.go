@@ -0,0 +1,46 @@
+package couchdbfile
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pipedrive/uncouch/couchbytes"
+)
+
+// ErrNoHeader is returned when the file contains no DB header block
+var ErrNoHeader = errors.New("Could not find DB Header block in the file")
+
+// ErrMissingBody is returned for revisions whose body is not stored in
+// the file
+var ErrMissingBody = errors.New("Document body is missing")
+
+// DocumentError is returned when body of document ID stored at Offset
+// can not be read
+type DocumentError struct {
+	ID     string
+	Offset int64
+	Err    error
+}
+
+// Error implements error
+func (e *DocumentError) Error() string {
+	return fmt.Sprintf("Can not read document %q at offset %d: %v", e.ID, e.Offset, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *DocumentError) Unwrap() error {
+	return e.Err
+}
+
+// NewDocumentError returns err as DocumentError of the document, at offset
+// of its last revision or -1 when it has none
+func NewDocumentError(di *DocumentInfo, err error) *DocumentError {
+	r, _ := di.LastRevision()
+	return &DocumentError{ID: string(di.ID), Offset: r.Offset, Err: err}
+}
+
+// corruptNode returns CorruptBlockError for node or header at offset
+// which could be read but not decoded
+func corruptNode(offset int64, reason string, err error) error {
+	return &couchbytes.CorruptBlockError{Offset: offset, Reason: reason, Err: err}
+}
@@ -0,0 +1,423 @@
+package couchdbfile
+
+import (
+	"fmt"
+
+	"github.com/pipedrive/uncouch/erldeser"
+	"github.com/pipedrive/uncouch/erlterm"
+)
+
+// nodeDecoder reads Btree nodes straight from the scanner into node
+// structures in a single pass, without building intermediate Termite
+type nodeDecoder struct {
+	s *erldeser.Scanner
+	t erlterm.Term
+	// path holds revisions from the root to the node being read
+	path []Revision
+}
+
+// newNodeDecoder returns decoder reading from the scanner
+func newNodeDecoder(s *erldeser.Scanner) *nodeDecoder {
+	nd := &nodeDecoder{s: s}
+	nd.t.Reset()
+	return nd
+}
+
+// readNodeType reads {NodeType, ...} header of the node and returns node type
+func (nd *nodeDecoder) readNodeType() (string, error) {
+	_, err := nd.readTuple(2)
+	if err != nil {
+		return "", err
+	}
+	err = nd.scan(erldeser.AtomExt)
+	if err != nil {
+		return "", err
+	}
+	return string(nd.t.Binary), nil
+}
+
+// readKpNodeID reads [{Key, {Offset, {Count, Count2, _}, Size}}] list
+func (nd *nodeDecoder) readKpNodeID(n *KpNodeID) error {
+	length, err := nd.readListLength()
+	if err != nil {
+		return err
+	}
+	n.Length = int32(length)
+	n.Pointers = make([]PointerID, length)
+	for i := range n.Pointers {
+		p := &n.Pointers[i]
+		if _, err = nd.readTuple(2); err != nil {
+			return err
+		}
+		if p.Key, err = nd.readBinary(); err != nil {
+			return err
+		}
+		if _, err = nd.readTuple(3); err != nil {
+			return err
+		}
+		if p.Offset, err = nd.readInt(); err != nil {
+			return err
+		}
+		arity, err := nd.readMinTuple(2)
+		if err != nil {
+			return err
+		}
+		if p.Count, err = nd.readInt(); err != nil {
+			return err
+		}
+		if p.Count2, err = nd.readInt(); err != nil {
+			return err
+		}
+		if err = nd.skipN(arity - 2); err != nil {
+			return err
+		}
+		size, err := nd.readInt()
+		if err != nil {
+			return err
+		}
+		p.Size = int32(size)
+	}
+	return nd.readListTail(length)
+}
+
+// readKpNodeSeq reads [{Seq, {Offset, Size1, Size2}}] list
+func (nd *nodeDecoder) readKpNodeSeq(n *KpNodeSeq) error {
+	length, err := nd.readListLength()
+	if err != nil {
+		return err
+	}
+	n.Length = int32(length)
+	n.Pointers = make([]PointerSeq, length)
+	for i := range n.Pointers {
+		p := &n.Pointers[i]
+		if _, err = nd.readTuple(2); err != nil {
+			return err
+		}
+		if p.Seq, err = nd.readInt(); err != nil {
+			return err
+		}
+		if _, err = nd.readTuple(3); err != nil {
+			return err
+		}
+		if p.Offset, err = nd.readInt(); err != nil {
+			return err
+		}
+		if p.Size1, err = nd.readInt(); err != nil {
+			return err
+		}
+		if p.Size2, err = nd.readInt(); err != nil {
+			return err
+		}
+	}
+	return nd.readListTail(length)
+}
+
+// readKvNode reads list of ID tree {Id, {Seq, Deleted, Sizes, RevTree}}
+// or seq tree {Seq, {Id, Deleted, Sizes, RevTree}} entries
+func (nd *nodeDecoder) readKvNode(n *KvNode) error {
+	length, err := nd.readListLength()
+	if err != nil {
+		return err
+	}
+	n.Length = int32(length)
+	n.Documents = make([]DocumentInfo, length)
+	for i := range n.Documents {
+		err = nd.readDocumentInfo(&n.Documents[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nd.readListTail(length)
+}
+
+// readDocumentInfo reads single kv_node entry
+func (nd *nodeDecoder) readDocumentInfo(di *DocumentInfo) error {
+	if _, err := nd.readTuple(2); err != nil {
+		return err
+	}
+	err := nd.s.Scan(&nd.t)
+	if err != nil {
+		return err
+	}
+	idTree := nd.t.Term == erldeser.BinaryExt
+	if idTree {
+		di.ID = append([]byte(nil), nd.t.Binary...)
+	} else if di.UpdateSeq, err = nd.intValue(); err != nil {
+		return err
+	}
+	arity, err := nd.readMinTuple(4)
+	if err != nil {
+		return err
+	}
+	if idTree {
+		di.UpdateSeq, err = nd.readInt()
+	} else {
+		di.ID, err = nd.readBinary()
+	}
+	if err != nil {
+		return err
+	}
+	deleted, err := nd.readInt()
+	if err != nil {
+		return err
+	}
+	di.Deleted = int8(deleted)
+	if di.Size1, di.Size2, err = nd.readSizes(); err != nil {
+		return err
+	}
+	di.Revisions = make([]Revision, 0, 5)
+	if err = nd.readRevTree(di); err != nil {
+		return err
+	}
+	return nd.skipN(arity - 4)
+}
+
+// readRevTree reads [{Start, Tree}] revision tree. Revisions get the
+// path to the winning leaf, other leaves go to OtherLeaves.
+func (nd *nodeDecoder) readRevTree(di *DocumentInfo) error {
+	length, err := nd.readListLength()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < length; i++ {
+		if _, err = nd.readTuple(2); err != nil {
+			return err
+		}
+		// Start position of the branch
+		start, err := nd.readInt()
+		if err != nil {
+			return err
+		}
+		nd.path = nd.path[:0]
+		if err = nd.readRevNode(di, start); err != nil {
+			return err
+		}
+	}
+	return nd.readListTail(length)
+}
+
+// readRevNode reads {RevId, Leaf, Children} at position pos and its
+// children
+func (nd *nodeDecoder) readRevNode(di *DocumentInfo, pos int64) error {
+	if _, err := nd.readTuple(3); err != nil {
+		return err
+	}
+	r := Revision{Pos: pos}
+	var err error
+	if r.RevID, err = nd.readBinary(); err != nil {
+		return err
+	}
+	if err = nd.s.Scan(&nd.t); err != nil {
+		return err
+	}
+	if nd.t.Term == erldeser.NilExt {
+		r.Offset = -1
+	} else {
+		if nd.t.Term != erldeser.SmallTupleExt || nd.t.IntegerValue < 4 {
+			return nd.unexpected("revision leaf tuple")
+		}
+		arity := int(nd.t.IntegerValue)
+		deleted, err := nd.readInt()
+		if err != nil {
+			return err
+		}
+		r.Deleted = int8(deleted)
+		if r.Offset, err = nd.readInt(); err != nil {
+			return err
+		}
+		if r.UpdateSeq, err = nd.readInt(); err != nil {
+			return err
+		}
+		if r.Size1, r.Size2, err = nd.readSizes(); err != nil {
+			return err
+		}
+		if err = nd.skipN(arity - 4); err != nil {
+			return err
+		}
+	}
+	nd.path = append(nd.path, r)
+	length, err := nd.readListLength()
+	if err != nil {
+		return err
+	}
+	if length == 0 {
+		addLeaf(di, nd.path)
+	}
+	for i := 0; i < length; i++ {
+		if err = nd.readRevNode(di, pos+1); err != nil {
+			return err
+		}
+	}
+	nd.path = nd.path[:len(nd.path)-1]
+	return nd.readListTail(length)
+}
+
+// addLeaf adds leaf at the end of path to the document, keeping path of
+// the winning leaf in Revisions
+func addLeaf(di *DocumentInfo, path []Revision) {
+	leaf := path[len(path)-1]
+	winner, ok := di.LastRevision()
+	if !ok {
+		di.Revisions = append(di.Revisions[:0], path...)
+		return
+	}
+	if !leaf.wins(&winner) {
+		di.OtherLeaves = append(di.OtherLeaves, leaf)
+		return
+	}
+	di.OtherLeaves = append(di.OtherLeaves, winner)
+	di.Revisions = append(di.Revisions[:0], path...)
+}
+
+// readSizes reads {ActiveSize, ExternalSize} tuple or single integer
+// used by older file versions
+func (nd *nodeDecoder) readSizes() (int32, int32, error) {
+	err := nd.s.Scan(&nd.t)
+	if err != nil {
+		return 0, 0, err
+	}
+	if nd.t.Term != erldeser.SmallTupleExt {
+		size, err := nd.intValue()
+		return int32(size), 0, err
+	}
+	arity := int(nd.t.IntegerValue)
+	if arity < 2 {
+		return 0, 0, nd.unexpected("sizes tuple")
+	}
+	size1, err := nd.readInt()
+	if err != nil {
+		return 0, 0, err
+	}
+	size2, err := nd.readInt()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int32(size1), int32(size2), nd.skipN(arity - 2)
+}
+
+// scan reads next term and checks its type
+func (nd *nodeDecoder) scan(termType erlterm.TermType) error {
+	err := nd.s.Scan(&nd.t)
+	if err != nil {
+		return err
+	}
+	if nd.t.Term != termType {
+		return nd.unexpected(erldeser.TypeName(termType))
+	}
+	return nil
+}
+
+// readTuple reads tuple header and checks its arity
+func (nd *nodeDecoder) readTuple(arity int) (int, error) {
+	err := nd.scan(erldeser.SmallTupleExt)
+	if err != nil {
+		return 0, err
+	}
+	if int(nd.t.IntegerValue) != arity {
+		return 0, nd.unexpected(fmt.Sprintf("tuple of %d", arity))
+	}
+	return arity, nil
+}
+
+// readMinTuple reads tuple header with arity of at least minArity
+func (nd *nodeDecoder) readMinTuple(minArity int) (int, error) {
+	err := nd.scan(erldeser.SmallTupleExt)
+	if err != nil {
+		return 0, err
+	}
+	if int(nd.t.IntegerValue) < minArity {
+		return 0, nd.unexpected(fmt.Sprintf("tuple of at least %d", minArity))
+	}
+	return int(nd.t.IntegerValue), nil
+}
+
+// readListLength reads list header, empty list has length 0
+func (nd *nodeDecoder) readListLength() (int, error) {
+	err := nd.s.Scan(&nd.t)
+	if err != nil {
+		return 0, err
+	}
+	switch nd.t.Term {
+	case erldeser.NilExt:
+		return 0, nil
+	case erldeser.ListExt:
+		return int(nd.t.IntegerValue), nil
+	default:
+		return 0, nd.unexpected("list")
+	}
+}
+
+// readListTail reads nil at the end of non-empty list
+func (nd *nodeDecoder) readListTail(length int) error {
+	if length == 0 {
+		return nil
+	}
+	return nd.scan(erldeser.NilExt)
+}
+
+// readInt reads integer
+func (nd *nodeDecoder) readInt() (int64, error) {
+	err := nd.s.Scan(&nd.t)
+	if err != nil {
+		return 0, err
+	}
+	return nd.intValue()
+}
+
+// intValue returns value of integer term read last
+func (nd *nodeDecoder) intValue() (int64, error) {
+	switch nd.t.Term {
+	case erldeser.SmallIntegerExt, erldeser.IntegerExt:
+		return nd.t.IntegerValue, nil
+	case erldeser.SmallBigExt, erldeser.LargeBigExt:
+		if nd.t.BigValue.IsInt64() {
+			return nd.t.IntegerValue, nil
+		}
+	}
+	return 0, nd.unexpected("integer")
+}
+
+// readBinary reads binary and returns its copy
+func (nd *nodeDecoder) readBinary() ([]byte, error) {
+	err := nd.scan(erldeser.BinaryExt)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), nd.t.Binary...), nil
+}
+
+// skipN skips n terms
+func (nd *nodeDecoder) skipN(n int) error {
+	for i := 0; i < n; i++ {
+		err := nd.skip()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skip skips next term including everything nested in it
+func (nd *nodeDecoder) skip() error {
+	err := nd.s.Scan(&nd.t)
+	if err != nil {
+		return err
+	}
+	switch nd.t.Term {
+	case erldeser.SmallTupleExt:
+		return nd.skipN(int(nd.t.IntegerValue))
+	case erldeser.ListExt:
+		// Elements and the tail
+		return nd.skipN(int(nd.t.IntegerValue) + 1)
+	}
+	return nil
+}
+
+// unexpected returns error describing term read last
+func (nd *nodeDecoder) unexpected(want string) error {
+	got := erldeser.TypeName(nd.t.Term)
+	if nd.t.Term == erldeser.SmallTupleExt {
+		got = fmt.Sprintf("tuple of %d", nd.t.IntegerValue)
+	}
+	return fmt.Errorf("Unexpected term at offset %d: expected %s, got %s", nd.s.Offset(), want, got)
+}
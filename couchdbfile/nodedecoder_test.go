@@ -0,0 +1,224 @@
+package couchdbfile
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/pipedrive/uncouch/erldeser"
+	"github.com/pipedrive/uncouch/termite"
+)
+
+// Builders of Erlang terms stored in Btree nodes
+
+func termAtom(s string) []byte {
+	b := binary.BigEndian.AppendUint16([]byte{byte(erldeser.AtomExt)}, uint16(len(s)))
+	return append(b, s...)
+}
+
+func termBinary(s string) []byte {
+	b := binary.BigEndian.AppendUint32([]byte{byte(erldeser.BinaryExt)}, uint32(len(s)))
+	return append(b, s...)
+}
+
+func termInt(i int64) []byte {
+	if i >= 0 && i < 256 {
+		return []byte{byte(erldeser.SmallIntegerExt), byte(i)}
+	}
+	return binary.BigEndian.AppendUint32([]byte{byte(erldeser.IntegerExt)}, uint32(i))
+}
+
+func termTuple(elements ...[]byte) []byte {
+	b := []byte{byte(erldeser.SmallTupleExt), byte(len(elements))}
+	for _, e := range elements {
+		b = append(b, e...)
+	}
+	return b
+}
+
+func termList(elements ...[]byte) []byte {
+	if len(elements) == 0 {
+		return []byte{byte(erldeser.NilExt)}
+	}
+	b := binary.BigEndian.AppendUint32([]byte{byte(erldeser.ListExt)}, uint32(len(elements)))
+	for _, e := range elements {
+		b = append(b, e...)
+	}
+	return append(b, byte(erldeser.NilExt))
+}
+
+// termRevTree returns revision tree of single branch with revisions
+// revisions deep, only the last one has body stored at offset
+func termRevTree(revisions int, offset, seq int64) []byte {
+	node := termTuple(
+		termBinary(fmt.Sprintf("rev-%d-0123456789abcdef", revisions)),
+		termTuple(termInt(0), termInt(offset), termInt(seq), termTuple(termInt(120), termInt(340))),
+		termList(),
+	)
+	for pos := revisions - 1; pos > 0; pos-- {
+		node = termTuple(
+			termBinary(fmt.Sprintf("rev-%d-0123456789abcdef", pos)),
+			termList(),
+			termList(node),
+		)
+	}
+	return termList(termTuple(termInt(1), node))
+}
+
+// nodeKinds are kinds of nodes returned by testNodes
+var nodeKinds = []string{"kp_node id", "kp_node seq", "kv_node id", "kv_node seq"}
+
+// testNodes returns ID and seq tree nodes of n entries each
+func testNodes(n int) map[string][]byte {
+	var kpID, kpSeq, kvID, kvSeq [][]byte
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("document-%06d", i)
+		seq := int64(1000 + i)
+		offset := int64(4096 * (i + 1))
+		sizes := termTuple(termInt(120), termInt(340))
+		kpID = append(kpID, termTuple(termBinary(id),
+			termTuple(termInt(offset), termTuple(termInt(int64(i)), termInt(0), termList()), termInt(512))))
+		kpSeq = append(kpSeq, termTuple(termInt(seq),
+			termTuple(termInt(offset), termInt(int64(i)), termInt(0))))
+		kvID = append(kvID, termTuple(termBinary(id),
+			termTuple(termInt(seq), termInt(0), sizes, termRevTree(3, offset, seq))))
+		kvSeq = append(kvSeq, termTuple(termInt(seq),
+			termTuple(termBinary(id), termInt(0), sizes, termRevTree(3, offset, seq))))
+	}
+	return map[string][]byte{
+		"kp_node id":  termTuple(termAtom("kp_node"), termList(kpID...)),
+		"kp_node seq": termTuple(termAtom("kp_node"), termList(kpSeq...)),
+		"kv_node id":  termTuple(termAtom("kv_node"), termList(kvID...)),
+		"kv_node seq": termTuple(termAtom("kv_node"), termList(kvSeq...)),
+	}
+}
+
+// newTestNode returns empty node structure of the test node kind
+func newTestNode(kind string) interface{} {
+	switch kind {
+	case "kp_node id":
+		return &KpNodeID{}
+	case "kp_node seq":
+		return &KpNodeSeq{}
+	}
+	return &KvNode{}
+}
+
+// readNodeDecoder reads node with nodeDecoder
+func readNodeDecoder(input []byte, node interface{}) error {
+	s, err := erldeser.NewScanner(input)
+	if err != nil {
+		return err
+	}
+	nd := newNodeDecoder(s)
+	if _, err = nd.readNodeType(); err != nil {
+		return err
+	}
+	switch n := node.(type) {
+	case *KpNodeID:
+		return nd.readKpNodeID(n)
+	case *KpNodeSeq:
+		return nd.readKpNodeSeq(n)
+	case *KvNode:
+		return nd.readKvNode(n)
+	}
+	return fmt.Errorf("unknown node %T", node)
+}
+
+// readNodeTermite reads node through Termite, the way nodes were read
+// before nodeDecoder
+func readNodeTermite(input []byte, node interface{}) error {
+	s, err := erldeser.NewScanner(input)
+	if err != nil {
+		return err
+	}
+	tb, err := termite.NewBuilder()
+	if err != nil {
+		return err
+	}
+	t, err := tb.ReadTermite(s)
+	if err != nil {
+		return err
+	}
+	defer t.Release()
+	switch n := node.(type) {
+	case *KpNodeID:
+		return n.readFromTermite(t)
+	case *KpNodeSeq:
+		return n.readFromTermite(t)
+	case *KvNode:
+		return n.readFromTermite(t)
+	}
+	return fmt.Errorf("unknown node %T", node)
+}
+
+func TestNodeDecoderMatchesTermite(t *testing.T) {
+	nodes := testNodes(20)
+	for _, kind := range nodeKinds {
+		input := nodes[kind]
+		t.Run(kind, func(t *testing.T) {
+			decoded, termiteNode := newTestNode(kind), newTestNode(kind)
+			if err := readNodeDecoder(input, decoded); err != nil {
+				t.Fatal(err)
+			}
+			if err := readNodeTermite(input, termiteNode); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decoded, termiteNode) {
+				t.Errorf("decoder read\n%+v\nTermite read\n%+v", decoded, termiteNode)
+			}
+		})
+	}
+}
+
+func TestNodeDecoderRevisions(t *testing.T) {
+	node := newTestNode("kv_node id").(*KvNode)
+	if err := readNodeDecoder(testNodes(1)["kv_node id"], node); err != nil {
+		t.Fatal(err)
+	}
+	di := node.Documents[0]
+	if string(di.ID) != "document-000000" || di.UpdateSeq != 1000 || di.Size1 != 120 || di.Size2 != 340 {
+		t.Errorf("got document info %+v", di)
+	}
+	if len(di.Revisions) != 3 {
+		t.Fatalf("got %d revisions, want 3", len(di.Revisions))
+	}
+	for i, r := range di.Revisions {
+		wantOffset := int64(-1)
+		if i == 2 {
+			wantOffset = 4096
+		}
+		if r.Pos != int64(i+1) || r.Offset != wantOffset {
+			t.Errorf("revision %d has position %d and offset %d, want %d and %d", i, r.Pos, r.Offset, i+1, wantOffset)
+		}
+	}
+	if di.Rev() != "3-rev-3-0123456789abcdef" {
+		t.Errorf("got rev %s", di.Rev())
+	}
+}
+
+func benchmarkReadNode(b *testing.B, read func([]byte, interface{}) error) {
+	nodes := testNodes(100)
+	for _, kind := range nodeKinds {
+		input := nodes[kind]
+		b.Run(kind, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				err := read(input, newTestNode(kind))
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkReadNodeTermite(b *testing.B) {
+	benchmarkReadNode(b, readNodeTermite)
+}
+
+func BenchmarkReadNodeDecoder(b *testing.B) {
+	benchmarkReadNode(b, readNodeDecoder)
+}
@@ -1,9 +1,7 @@
 package couchdbfile
 
 import (
-	"encoding/json"
 	"fmt"
-	"strings"
 
 	"github.com/pipedrive/uncouch/leakybucket"
 	"github.com/pipedrive/uncouch/termite"
@@ -17,54 +15,46 @@ func (cf *CouchDbFile) ReadNodeBytes(offset int64) (*[]byte, error) {
 	return couchbytes.ReadNodeBytes(cf.input, offset)
 }
 
+// ReadChunkBytes reads node or document chunk from given offset as it is
+// stored, without uncompressing it
+func (cf *CouchDbFile) ReadChunkBytes(offset int64) (*[]byte, error) {
+	return couchbytes.ReadChunkBytes(cf.input, offset, cf.size-offset)
+}
+
 // ReadIDNode reads ID Btree node from the given offset
 func (cf *CouchDbFile) ReadIDNode(offset int64) (*KpNodeID, *KvNode, error) {
 	// slog.Debugf("Starting readNode with offset %d", offset)
 	buf, err := couchbytes.ReadNodeBytes(cf.input, offset)
 	if err != nil {
-		slog.Error(err)
 		return nil, nil, err
 	}
 	defer leakybucket.PutBytes(buf)
 	s, err := erldeser.NewScanner(*buf)
 	if err != nil {
-		slog.Error(err)
 		return nil, nil, err
 	}
-	tb, err := termite.NewBuilder()
+	nd := newNodeDecoder(s)
+	nodeType, err := nd.readNodeType()
 	if err != nil {
-		slog.Error(err)
-		return nil, nil, err
+		return nil, nil, corruptNode(offset, "malformed btree node", err)
 	}
-	t, err := tb.ReadTermite(s)
-	if err != nil {
-		slog.Error(err)
-		return nil, nil, err
-	}
-	// Switch
-	switch string(t.Children[0].T.Binary) {
+	switch nodeType {
 	case "kp_node":
 		var kpNode KpNodeID
-		err = kpNode.readFromTermite(t)
+		err = nd.readKpNodeID(&kpNode)
 		if err != nil {
-			slog.Error(err)
-			return nil, nil, err
+			return nil, nil, corruptNode(offset, "malformed btree node", err)
 		}
-		t.Release()
 		return &kpNode, nil, nil
 	case "kv_node":
 		var kvNode KvNode
-		err = kvNode.readFromTermite(t)
+		err = nd.readKvNode(&kvNode)
 		if err != nil {
-			slog.Error(err)
-			return nil, nil, err
+			return nil, nil, corruptNode(offset, "malformed btree node", err)
 		}
-		t.Release()
 		return nil, &kvNode, nil
 	default:
-		err := fmt.Errorf("Unknown node type: %v", string(t.Children[0].T.Binary))
-		slog.Error(err)
-		return nil, nil, err
+		return nil, nil, corruptNode(offset, fmt.Sprintf("unknown node type %q", nodeType), nil)
 	}
 }
 
@@ -77,49 +67,35 @@ func (cf *CouchDbFile) ReadSeqNode(offset int64) (*KpNodeSeq, *KvNode, error) {
 
 	buf, err := couchbytes.ReadNodeBytes(cf.input, offset)
 	if err != nil {
-		slog.Error(err)
 		return nil, nil, err
 	}
 	defer leakybucket.PutBytes(buf)
 	s, err := erldeser.NewScanner(*buf)
 	if err != nil {
-		slog.Error(err)
-		return nil, nil, err
-	}
-	tb, err := termite.NewBuilder()
-	if err != nil {
-		slog.Error(err)
 		return nil, nil, err
 	}
-	t, err := tb.ReadTermite(s)
+	nd := newNodeDecoder(s)
+	nodeType, err := nd.readNodeType()
 	if err != nil {
-		slog.Error(err)
-		return nil, nil, err
+		return nil, nil, corruptNode(offset, "malformed btree node", err)
 	}
-	// Switch
-	switch string(t.Children[0].T.Binary) {
+	switch nodeType {
 	case "kp_node":
 		var kpNode KpNodeSeq
-		err = kpNode.readFromTermite(t)
+		err = nd.readKpNodeSeq(&kpNode)
 		if err != nil {
-			slog.Error(err)
-			return nil, nil, err
+			return nil, nil, corruptNode(offset, "malformed btree node", err)
 		}
-		// t.Release()
 		return &kpNode, nil, nil
 	case "kv_node":
 		var kvNode KvNode
-		err = kvNode.readFromTermite(t)
+		err = nd.readKvNode(&kvNode)
 		if err != nil {
-			slog.Error(err)
-			return nil, nil, err
+			return nil, nil, corruptNode(offset, "malformed btree node", err)
 		}
-		t.Release()
 		return nil, &kvNode, nil
 	default:
-		err := fmt.Errorf("Unknown node type: %v", string(t.Children[0].T.Binary))
-		slog.Error(err)
-		return nil, nil, err
+		return nil, nil, corruptNode(offset, fmt.Sprintf("unknown node type %q", nodeType), nil)
 	}
 }
 
@@ -127,77 +103,31 @@ func (cf *CouchDbFile) ReadSeqNode(offset int64) (*KpNodeSeq, *KvNode, error) {
 func (cf *CouchDbFile) ReadDbHeader() (*DbHeader, error) {
 	offset, err := cf.Header.findHeader(cf.input, cf.size)
 	if err != nil {
-		slog.Error(err)
 		return nil, err
 	}
 	buf, err := couchbytes.ReadDbHeaderBytes(cf.input, offset)
 	if err != nil {
-		slog.Error(err)
 		return nil, err
 	}
 	defer leakybucket.PutBytes(buf)
 	s, err := erldeser.NewScanner(*buf)
 	if err != nil {
-		slog.Error(err)
 		return nil, err
 	}
 	tb, err := termite.NewBuilder()
 	if err != nil {
-		slog.Error(err)
 		return nil, err
 	}
 	t, err := tb.ReadTermite(s)
 	if err != nil {
-		slog.Error(err)
-		return nil, err
+		return nil, corruptNode(offset, "malformed DB header", err)
 	}
 	// slog.Debugf("%+v", t)
 	var header DbHeader
 	err = header.readFromTermite(t)
-
 	t.Release()
-	return &header, err
-}
-
-func (cf *CouchDbFile) ReadOffset(offset int64, couchDbDocuments []CouchDbDocument) []CouchDbDocument {
-	for {
-		kpNode, kvNode, err := cf.ReadSeqNode(offset)
-		if err != nil {
-			panic(err)
-		}
-		if kpNode != nil && kvNode != nil {
-			log.Info("Empty Node.")
-		}
-		if kpNode != nil {
-			// Pointer node, dig deeper
-			for _, node := range kpNode.Pointers {
-				couchDbDocuments = cf.ReadOffset(node.Offset, couchDbDocuments)
-			}
-		} else if kvNode != nil {
-			for _, document := range kvNode.Documents {
-				output := leakybucket.GetBuffer()
-				err = cf.WriteDocument(&document, output)
-				if err != nil {
-					slog.Error(err)
-					continue
-				}
-
-				var pl map[string]interface{}
-				if err := json.Unmarshal(output.Bytes(), &pl); err != nil {
-					slog.Error(err)
-					continue
-				}
-				cd := CouchDbDocument{
-					Id:      strings.TrimSpace(string(document.ID)),
-					Deleted: document.Deleted,
-					Rev:     "",
-					Value:   pl,
-				}
-				couchDbDocuments = append(couchDbDocuments, cd)
-				leakybucket.PutBuffer(output)
-			}
-		}
-		break
+	if err != nil {
+		return nil, corruptNode(offset, "malformed DB header", err)
 	}
-	return couchDbDocuments
+	return &header, nil
 }
@@ -0,0 +1,176 @@
+package couchdbfile
+
+import (
+	"github.com/pipedrive/uncouch/erldeser"
+	"github.com/pipedrive/uncouch/termite"
+)
+
+// Nodes were read through Termite before nodeDecoder, the readers are
+// kept here as reference nodeDecoder is checked and benchmarked against
+
+// readFromTermite reads node structure out of Termite structure
+// {kp_node, [{Key, {Offset, {Count, Count2, _}, Size}}]}
+func (n *KpNodeID) readFromTermite(t *termite.Termite) error {
+	pointers, err := t.Path(1).List()
+	if err != nil {
+		return err
+	}
+	n.Length = int32(len(pointers))
+	n.Pointers = make([]PointerID, n.Length)
+
+	for i, p := range pointers {
+		key, err := p.Path(0).Binary()
+		if err != nil {
+			return err
+		}
+		n.Pointers[i].Key = append([]byte(nil), key...)
+		if n.Pointers[i].Offset, err = p.Path(1, 0).Int(); err != nil {
+			return err
+		}
+		if n.Pointers[i].Count, err = p.Path(1, 1, 0).Int(); err != nil {
+			return err
+		}
+		if n.Pointers[i].Count2, err = p.Path(1, 1, 1).Int(); err != nil {
+			return err
+		}
+		size, err := p.Path(1, 2).Int()
+		if err != nil {
+			return err
+		}
+		n.Pointers[i].Size = int32(size)
+	}
+	return nil
+}
+
+// readFromTermite reads node structure out of Termite structure
+// {kp_node, [{Seq, {Offset, Size1, Size2}}]}
+func (n *KpNodeSeq) readFromTermite(t *termite.Termite) error {
+	pointers, err := t.Path(1).List()
+	if err != nil {
+		return err
+	}
+	n.Length = int32(len(pointers))
+	n.Pointers = make([]PointerSeq, n.Length)
+
+	for i, p := range pointers {
+		if n.Pointers[i].Seq, err = p.Path(0).Int(); err != nil {
+			return err
+		}
+		if n.Pointers[i].Offset, err = p.Path(1, 0).Int(); err != nil {
+			return err
+		}
+		if n.Pointers[i].Size1, err = p.Path(1, 1).Int(); err != nil {
+			return err
+		}
+		if n.Pointers[i].Size2, err = p.Path(1, 2).Int(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readFromTermite reads node structure out of Termite structure.
+// ID tree entries are {Id, {Seq, Deleted, Sizes, RevTree}} and
+// seq tree entries are {Seq, {Id, Deleted, Sizes, RevTree}}.
+func (n *KvNode) readFromTermite(t *termite.Termite) error {
+	entries, err := t.Path(1).List()
+	if err != nil {
+		return err
+	}
+	n.Length = int32(len(entries))
+	n.Documents = make([]DocumentInfo, n.Length)
+
+	for i, e := range entries {
+		di := &n.Documents[i]
+		var id []byte
+		if e.Path(0).Type() == erldeser.BinaryExt {
+			id, err = e.Path(0).Binary()
+			if err != nil {
+				return err
+			}
+			if di.UpdateSeq, err = e.Path(1, 0).Int(); err != nil {
+				return err
+			}
+		} else {
+			if di.UpdateSeq, err = e.Path(0).Int(); err != nil {
+				return err
+			}
+			if id, err = e.Path(1, 0).Binary(); err != nil {
+				return err
+			}
+		}
+		di.ID = append([]byte(nil), id...)
+		deleted, err := e.Path(1, 1).Int()
+		if err != nil {
+			return err
+		}
+		di.Deleted = int8(deleted)
+		if di.Size1, di.Size2, err = readSizes(e.Path(1, 2)); err != nil {
+			return err
+		}
+		di.Revisions = make([]Revision, 0, 5)
+		// What this extra list tuple(2) wrapper is doing here?
+		// Branching?
+		pos, err := e.Path(1, 3, 0, 0).Int()
+		if err != nil {
+			return err
+		}
+		revNode := e.Path(1, 3, 0, 1)
+		for ; ; pos++ {
+			var r Revision
+			r.Pos = pos
+			revID, err := revNode.Path(0).Binary()
+			if err != nil {
+				return err
+			}
+			r.RevID = append([]byte(nil), revID...)
+			leaf := revNode.Path(1)
+			if leaf.IsNil() {
+				r.Offset = -1
+			} else {
+				deleted, err := leaf.Path(0).Int()
+				if err != nil {
+					return err
+				}
+				r.Deleted = int8(deleted)
+				if r.Offset, err = leaf.Path(1).Int(); err != nil {
+					return err
+				}
+				if r.UpdateSeq, err = leaf.Path(2).Int(); err != nil {
+					return err
+				}
+				if r.Size1, r.Size2, err = readSizes(leaf.Path(3)); err != nil {
+					return err
+				}
+			}
+			di.Revisions = append(di.Revisions, r)
+			children := revNode.Path(2)
+			if err := children.Err(); err != nil {
+				return err
+			}
+			if children.IsNil() {
+				break
+			}
+			revNode = children.Path(0)
+		}
+	}
+	return nil
+}
+
+// readSizes reads {ActiveSize, ExternalSize} tuple. Older file versions
+// store single integer instead, it is returned as the first size.
+func readSizes(n termite.Node) (int32, int32, error) {
+	if n.Type() != erldeser.SmallTupleExt {
+		size, err := n.Int()
+		return int32(size), 0, err
+	}
+	size1, err := n.Path(0).Int()
+	if err != nil {
+		return 0, 0, err
+	}
+	size2, err := n.Path(1).Int()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int32(size1), int32(size2), nil
+}
@@ -3,13 +3,21 @@
 package erldeser
 
 import (
+	"bufio"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"math"
+	"math/big"
+	"slices"
 
 	"github.com/pipedrive/uncouch/erlterm"
 )
 
+// ErrUnsupportedTerm is returned for terms of type the scanner can not read
+var ErrUnsupportedTerm = errors.New("Unsupported term type")
+
 // Actual values for different data types
 const (
 	NewFloatExt     erlterm.TermType = 'F'
@@ -22,16 +30,49 @@ const (
 	StringExt       erlterm.TermType = 'k'
 	ListExt         erlterm.TermType = 'l'
 	BinaryExt       erlterm.TermType = 'm'
-	SmallBigExt	    erlterm.TermType = 'n'
+	SmallBigExt     erlterm.TermType = 'n'
+	LargeBigExt     erlterm.TermType = 'o'
 )
 
-// Scanner implements term scanner from provided io.Reader
+// typeNames maps term types to names used in External Term Format documentation
+var typeNames = map[erlterm.TermType]string{
+	NewFloatExt:     "NEW_FLOAT_EXT",
+	SmallIntegerExt: "SMALL_INTEGER_EXT",
+	IntegerExt:      "INTEGER_EXT",
+	AtomExt:         "ATOM_EXT",
+	SmallTupleExt:   "SMALL_TUPLE_EXT",
+	LargeTupleExt:   "LARGE_TUPLE_EXT",
+	NilExt:          "NIL_EXT",
+	StringExt:       "STRING_EXT",
+	ListExt:         "LIST_EXT",
+	BinaryExt:       "BINARY_EXT",
+	SmallBigExt:     "SMALL_BIG_EXT",
+	LargeBigExt:     "LARGE_BIG_EXT",
+}
+
+// TypeName returns human readable name of the term type
+func TypeName(t erlterm.TermType) string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown term type %d", t)
+}
+
+// maxReadPart is the largest part terms read from io.Reader are read in
+const maxReadPart = 64 * 1024
+
+// Scanner implements term scanner from provided byte slice or io.Reader
 type Scanner struct {
 	input  []byte
 	offset int64
+	// r is set for scanners reading from io.Reader
+	r       *bufio.Reader
+	scratch [8]byte
+	// pending is number of binary payload bytes left unread by ScanHeader
+	pending int64
 }
 
-// New will return term scanner
+// NewScanner will return term scanner reading from byte slice
 func NewScanner(input []byte) (*Scanner, error) {
 	var (
 		newScanner Scanner
@@ -41,171 +82,352 @@ func NewScanner(input []byte) (*Scanner, error) {
 	return ns, nil
 }
 
+// NewReaderScanner will return term scanner reading from io.Reader.
+// Only bytes of the term being scanned are kept in memory.
+func NewReaderScanner(r io.Reader) (*Scanner, error) {
+	var (
+		newScanner Scanner
+	)
+	ns := &newScanner
+	if br, ok := r.(*bufio.Reader); ok {
+		ns.r = br
+	} else {
+		ns.r = bufio.NewReader(r)
+	}
+	return ns, nil
+}
+
 // Scan scans provided input and return deserialised Erlang term
 func (s *Scanner) Scan(t *erlterm.Term) error {
+	err := s.ScanHeader(t)
+	if err != nil {
+		return err
+	}
+	if t.Term == BinaryExt {
+		return s.readBinaryPayload(t)
+	}
+	return nil
+}
+
+// ScanHeader works as Scan, but leaves payload of binaries unread. Length
+// of the binary is stored into IntegerValue and the payload has to be
+// consumed with ReadPayload before scanning the next term.
+func (s *Scanner) ScanHeader(t *erlterm.Term) error {
 	if t == nil {
 		err := fmt.Errorf("Provided term is nil reference")
-		slog.Error(err)
 		return err
 	}
-	termType := erlterm.TermType(s.input[s.offset])
-	s.offset++
+	if s.pending > 0 {
+		err := fmt.Errorf("Scanning next term with %d bytes of binary payload unread", s.pending)
+		return err
+	}
+	b, err := s.next(1)
+	if err != nil {
+		return err
+	}
+	termType := erlterm.TermType(b[0])
 	switch termType {
 	case NewFloatExt:
-		s.readNewFloat(t)
+		err = s.readNewFloat(t)
 	case SmallIntegerExt:
-		s.readSmallInteger(t)
+		err = s.readSmallInteger(t)
 	case IntegerExt:
-		s.readInteger(t)
+		err = s.readInteger(t)
 	case AtomExt:
-		s.readAtom(t)
+		err = s.readAtom(t)
 	case SmallTupleExt:
-		s.readSmallTuple(t)
+		err = s.readSmallTuple(t)
 	case NilExt:
-		s.readNil(t)
+		err = s.readNil(t)
 	case StringExt:
-		s.readString(t)
+		err = s.readString(t)
 	case ListExt:
-		s.readList(t)
+		err = s.readList(t)
 	case BinaryExt:
-		s.readBinary(t)
+		err = s.readBinary(t)
 	case SmallBigExt:
-		s.readSmallBig(t)
+		err = s.readSmallBig(t)
+	case LargeBigExt:
+		err = s.readLargeBig(t)
 	default:
-		err := fmt.Errorf("Unhandled term type %v", termType)
-		slog.Error(err)
+		err = fmt.Errorf("%w %v at offset %d", ErrUnsupportedTerm, termType, s.offset-1)
+	}
+	if err != nil {
 		return err
 	}
 	return nil
 }
 
-// Rewind resets offset to be able to scan same buffer again
+// ScanPayload reads binary payload left unread by ScanHeader into the term
+func (s *Scanner) ScanPayload(t *erlterm.Term) error {
+	return s.readBinaryPayload(t)
+}
+
+// ReadPayload reads binary payload left unread by ScanHeader. It returns
+// io.EOF when whole payload has been read.
+func (s *Scanner) ReadPayload(p []byte) (int, error) {
+	if s.pending == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > s.pending {
+		p = p[:s.pending]
+	}
+	err := s.readInto(p)
+	if err != nil {
+		return 0, err
+	}
+	s.pending -= int64(len(p))
+	return len(p), nil
+}
+
+// Offset returns number of bytes scanned so far
+func (s *Scanner) Offset() int64 {
+	return s.offset
+}
+
+// Rewind resets offset to be able to scan same buffer again.
+// Scanners reading from io.Reader can not be rewound.
 func (s *Scanner) Rewind() {
-	s.offset = 0
+	if s.r == nil {
+		s.offset = 0
+		s.pending = 0
+	}
+}
+
+// next returns n following bytes from input. Returned slice is valid
+// until the next call.
+func (s *Scanner) next(n int64) ([]byte, error) {
+	if s.r == nil {
+		if s.offset+n > int64(len(s.input)) {
+			return nil, s.unexpectedEnd(n)
+		}
+		b := s.input[s.offset : s.offset+n]
+		s.offset += n
+		return b, nil
+	}
+	b := s.scratch[:n]
+	err := s.readInto(b)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// readInto fills provided slice from input
+func (s *Scanner) readInto(b []byte) error {
+	n := int64(len(b))
+	if s.r == nil {
+		if s.offset+n > int64(len(s.input)) {
+			return s.unexpectedEnd(n)
+		}
+		copy(b, s.input[s.offset:s.offset+n])
+		s.offset += n
+		return nil
+	}
+	read, err := io.ReadFull(s.r, b)
+	s.offset += int64(read)
+	if err != nil {
+		if err == io.EOF {
+			return s.unexpectedEnd(n)
+		}
+		return err
+	}
+	return nil
+}
+
+// unexpectedEnd returns error for input ending in the middle of a term
+func (s *Scanner) unexpectedEnd(n int64) error {
+	return fmt.Errorf("Unexpected end of input reading %d bytes at offset %d: %w", n, s.offset, io.ErrUnexpectedEOF)
 }
 
 // readNewFloat is reading serialised Erlang float
-func (s *Scanner) readNewFloat(t *erlterm.Term) {
-	bits := binary.BigEndian.Uint64(s.input[s.offset : s.offset+8])
-	s.offset += 8
+func (s *Scanner) readNewFloat(t *erlterm.Term) error {
+	b, err := s.next(8)
+	if err != nil {
+		return err
+	}
+	bits := binary.BigEndian.Uint64(b)
 	floatValue := math.Float64frombits(bits)
 	t.Term = NewFloatExt
 	t.FloatValue = floatValue
-	return
+	return nil
 }
 
 // readSmallInteger is reading serialised Erlang small integer
-func (s *Scanner) readSmallInteger(t *erlterm.Term) {
-	intValue := int64(s.input[s.offset])
-	s.offset++
+func (s *Scanner) readSmallInteger(t *erlterm.Term) error {
+	b, err := s.next(1)
+	if err != nil {
+		return err
+	}
 	t.Term = SmallIntegerExt
-	t.IntegerValue = intValue
-	return
+	t.IntegerValue = int64(b[0])
+	return nil
 }
 
 // readInteger is reading serialised Erlang integer
-func (s *Scanner) readInteger(t *erlterm.Term) {
-	intValue := binary.BigEndian.Uint32(s.input[s.offset : s.offset+4])
-	s.offset += 4
+func (s *Scanner) readInteger(t *erlterm.Term) error {
+	b, err := s.next(4)
+	if err != nil {
+		return err
+	}
 	t.Term = IntegerExt
-	t.IntegerValue = int64(intValue)
-	return
+	t.IntegerValue = int64(int32(binary.BigEndian.Uint32(b)))
+	return nil
 }
 
 // readAtom is reading serialised Erlang atom
-func (s *Scanner) readAtom(t *erlterm.Term) {
-	t.Term = AtomExt
-	atomLength := int64(binary.BigEndian.Uint16(s.input[s.offset : s.offset+2]))
-	s.offset += 2
-	if atomLength > int64(cap(t.Binary)) {
-		t.Binary = make([]byte, atomLength)
-	} else {
-		t.Binary = t.Binary[:atomLength]
+func (s *Scanner) readAtom(t *erlterm.Term) error {
+	b, err := s.next(2)
+	if err != nil {
+		return err
 	}
-	copy(t.Binary, s.input[s.offset:s.offset+atomLength])
-	s.offset += atomLength
-	return
+	t.Term = AtomExt
+	return s.readBytes(t, int64(binary.BigEndian.Uint16(b)))
 }
 
 // readSmallTuple is reading serialised Erlang small tuple
-func (s *Scanner) readSmallTuple(t *erlterm.Term) {
-	arity := int64(s.input[s.offset])
-	s.offset++
+func (s *Scanner) readSmallTuple(t *erlterm.Term) error {
+	b, err := s.next(1)
+	if err != nil {
+		return err
+	}
 	t.Term = SmallTupleExt
-	t.IntegerValue = arity
-	return
+	t.IntegerValue = int64(b[0])
+	return nil
 }
 
 // readNil is reading serialised Erlang empty list
-func (s *Scanner) readNil(t *erlterm.Term) {
+func (s *Scanner) readNil(t *erlterm.Term) error {
 	t.Term = NilExt
-	return
+	return nil
 }
 
 // readString is reading serialised Erlang string
-func (s *Scanner) readString(t *erlterm.Term) {
-	t.Term = StringExt
-	stringLength := int64(binary.BigEndian.Uint16(s.input[s.offset : s.offset+2]))
-	s.offset += 2
-
-	if stringLength > int64(cap(t.Binary)) {
-		t.Binary = make([]byte, stringLength)
-	} else {
-		t.Binary = t.Binary[:stringLength]
+func (s *Scanner) readString(t *erlterm.Term) error {
+	b, err := s.next(2)
+	if err != nil {
+		return err
 	}
-
-	copy(t.Binary, s.input[s.offset:s.offset+stringLength])
-	s.offset += stringLength
-	return
+	t.Term = StringExt
+	return s.readBytes(t, int64(binary.BigEndian.Uint16(b)))
 }
 
 // readList is reading serialised Erlang list
-func (s *Scanner) readList(t *erlterm.Term) {
-	listLength := int64(binary.BigEndian.Uint32(s.input[s.offset : s.offset+4]))
-	s.offset += 4
-
+func (s *Scanner) readList(t *erlterm.Term) error {
+	b, err := s.next(4)
+	if err != nil {
+		return err
+	}
 	t.Term = ListExt
-	t.IntegerValue = listLength
-	return
+	t.IntegerValue = int64(binary.BigEndian.Uint32(b))
+	return nil
 }
 
-// readBinary is reading serialised Erlang binary
-func (s *Scanner) readBinary(t *erlterm.Term) {
+// readBinary is reading serialised Erlang binary header, payload
+// is read by readBinaryPayload or ReadPayload
+func (s *Scanner) readBinary(t *erlterm.Term) error {
+	b, err := s.next(4)
+	if err != nil {
+		return err
+	}
 	t.Term = BinaryExt
-	binaryLength := int64(binary.BigEndian.Uint32(s.input[s.offset : s.offset+4]))
-	s.offset += 4
+	t.IntegerValue = int64(binary.BigEndian.Uint32(b))
+	t.Binary = t.Binary[:0]
+	s.pending = t.IntegerValue
+	return nil
+}
 
-	if binaryLength > int64(cap(t.Binary)) {
-		t.Binary = make([]byte, binaryLength)
-	} else {
-		t.Binary = t.Binary[:binaryLength]
-	}
+// readBinaryPayload reads payload of the binary into the term
+func (s *Scanner) readBinaryPayload(t *erlterm.Term) error {
+	length := s.pending
+	s.pending = 0
+	return s.readBytes(t, length)
+}
 
-	copy(t.Binary, s.input[s.offset:s.offset+binaryLength])
-	s.offset += binaryLength
-	return
+// readBytes reads length bytes into term Binary reusing its memory if possible
+func (s *Scanner) readBytes(t *erlterm.Term, length int64) error {
+	var err error
+	t.Binary, err = s.readSized(t.Binary[:0], length)
+	return err
+}
+
+// readSized reads length bytes appending them to dst. Length comes from
+// the input, so it is checked against the input before allocating: slice
+// input has to hold all of it and io.Reader input is read in parts, so
+// memory grows only as the bytes arrive.
+func (s *Scanner) readSized(dst []byte, length int64) ([]byte, error) {
+	if s.r == nil {
+		if s.offset+length > int64(len(s.input)) {
+			return dst, s.unexpectedEnd(length)
+		}
+		dst = append(dst, s.input[s.offset:s.offset+length]...)
+		s.offset += length
+		return dst, nil
+	}
+	for length > 0 {
+		n := length
+		if n > maxReadPart {
+			n = maxReadPart
+		}
+		start := len(dst)
+		dst = slices.Grow(dst, int(n))[:start+int(n)]
+		err := s.readInto(dst[start:])
+		if err != nil {
+			return dst[:start], err
+		}
+		length -= n
+	}
+	return dst, nil
 }
 
 // readSmallBig is reading serialised Erlang small big
-func (s *Scanner) readSmallBig(t *erlterm.Term) {
+func (s *Scanner) readSmallBig(t *erlterm.Term) error {
+	b, err := s.next(1)
+	if err != nil {
+		return err
+	}
 	t.Term = SmallBigExt
+	return s.readBigDigits(t, int64(b[0]))
+}
 
-	numberLength := int64(s.input[s.offset])
-	s.offset += 1
-
-	sign := int64(s.input[s.offset])
-	s.offset += 1
+// readLargeBig is reading serialised Erlang large big
+func (s *Scanner) readLargeBig(t *erlterm.Term) error {
+	b, err := s.next(4)
+	if err != nil {
+		return err
+	}
+	t.Term = LargeBigExt
+	return s.readBigDigits(t, int64(binary.BigEndian.Uint32(b)))
+}
 
-	total := int64(0)
-	base := int64(256)
-	for i := int64(0); i < numberLength; i++ {
-		partialValue := int64(s.input[s.offset])
-		total += partialValue * int64(math.Pow(float64(base), float64(i)))
-		s.offset++
+// readBigDigits reads sign and little-endian digits of big integer into term
+func (s *Scanner) readBigDigits(t *erlterm.Term, numberLength int64) error {
+	b, err := s.next(1)
+	if err != nil {
+		return err
 	}
-	total *= int64(math.Pow(float64(-1), float64(sign)))
+	sign := b[0]
 
-	t.IntegerValue = total
+	digits, err := s.readSized(nil, numberLength)
+	if err != nil {
+		return err
+	}
+	// Digits are stored little-endian, big.Int expects big-endian
+	for i, j := 0, len(digits)-1; i < j; i, j = i+1, j-1 {
+		digits[i], digits[j] = digits[j], digits[i]
+	}
 
-	return
-}
\ No newline at end of file
+	if t.BigValue == nil {
+		t.BigValue = new(big.Int)
+	}
+	t.BigValue.SetBytes(digits)
+	if sign != 0 {
+		t.BigValue.Neg(t.BigValue)
+	}
+	if t.BigValue.IsInt64() {
+		t.IntegerValue = t.BigValue.Int64()
+	} else {
+		t.IntegerValue = 0
+	}
+	return nil
+}
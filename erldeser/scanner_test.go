@@ -0,0 +1,162 @@
+package erldeser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"runtime"
+	"testing"
+
+	"github.com/pipedrive/uncouch/erlterm"
+)
+
+// encodeBig returns SMALL_BIG_EXT or LARGE_BIG_EXT of v, digits stored
+// little-endian after the sign byte
+func encodeBig(v *big.Int, large bool) []byte {
+	digits := new(big.Int).Abs(v).Bytes()
+	for i, j := 0, len(digits)-1; i < j; i, j = i+1, j-1 {
+		digits[i], digits[j] = digits[j], digits[i]
+	}
+	var b []byte
+	if large {
+		b = append(b, byte(LargeBigExt))
+		b = binary.BigEndian.AppendUint32(b, uint32(len(digits)))
+	} else {
+		b = append(b, byte(SmallBigExt), byte(len(digits)))
+	}
+	if v.Sign() < 0 {
+		b = append(b, 1)
+	} else {
+		b = append(b, 0)
+	}
+	return append(b, digits...)
+}
+
+// newScanners returns slice and reader scanners of input
+func newScanners(t *testing.T, input []byte) map[string]*Scanner {
+	s, err := NewScanner(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := NewReaderScanner(bytes.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]*Scanner{"slice": s, "reader": rs}
+}
+
+func TestScanBig(t *testing.T) {
+	twoTo := func(n uint) *big.Int {
+		return new(big.Int).Lsh(big.NewInt(1), n)
+	}
+	large, _ := new(big.Int).SetString("-123456789012345678901234567890123456789012345678901234567890", 10)
+	tests := []struct {
+		name  string
+		value *big.Int
+		large bool
+		// integer is expected IntegerValue, zero when out of int64 range
+		integer int64
+	}{
+		{"2^40", twoTo(40), false, 1 << 40},
+		{"2^63-1", big.NewInt(math.MaxInt64), false, math.MaxInt64},
+		{"2^63", twoTo(63), false, 0},
+		{"-2^63", new(big.Int).Neg(twoTo(63)), false, math.MinInt64},
+		{"-2^63-1", new(big.Int).Sub(new(big.Int).Neg(twoTo(63)), big.NewInt(1)), false, 0},
+		{"2^64", twoTo(64), false, 0},
+		{"large 2^2048", twoTo(2048), true, 0},
+		{"large negative", large, true, 0},
+		{"large small value", big.NewInt(-5), true, -5},
+	}
+	for _, tt := range tests {
+		for kind, s := range newScanners(t, encodeBig(tt.value, tt.large)) {
+			t.Run(tt.name+"/"+kind, func(t *testing.T) {
+				var term erlterm.Term
+				term.Reset()
+				err := s.Scan(&term)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := SmallBigExt
+				if tt.large {
+					want = LargeBigExt
+				}
+				if term.Term != want {
+					t.Errorf("got term %v, want %v", TypeName(term.Term), TypeName(want))
+				}
+				if term.BigValue.Cmp(tt.value) != 0 {
+					t.Errorf("got %v, want %v", term.BigValue, tt.value)
+				}
+				if term.IntegerValue != tt.integer {
+					t.Errorf("got integer value %d, want %d", term.IntegerValue, tt.integer)
+				}
+			})
+		}
+	}
+}
+
+func TestScanBigReusesTerm(t *testing.T) {
+	input := append(encodeBig(new(big.Int).Lsh(big.NewInt(1), 70), false), encodeBig(big.NewInt(-7), false)...)
+	s, err := NewScanner(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var term erlterm.Term
+	term.Reset()
+	if err = s.Scan(&term); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Scan(&term); err != nil {
+		t.Fatal(err)
+	}
+	if term.BigValue.Int64() != -7 || term.IntegerValue != -7 {
+		t.Errorf("got %v and %d, want -7", term.BigValue, term.IntegerValue)
+	}
+}
+
+func TestScanCorruptLength(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{"binary", []byte{byte(BinaryExt), 0xff, 0xff, 0xff, 0xff, 'a', 'b', 'c'}},
+		{"large big", []byte{byte(LargeBigExt), 0xff, 0xff, 0xff, 0xff, 0, 1, 2, 3}},
+	}
+	for _, tt := range tests {
+		for kind, s := range newScanners(t, tt.input) {
+			t.Run(tt.name+"/"+kind, func(t *testing.T) {
+				var term erlterm.Term
+				term.Reset()
+				var before, after runtime.MemStats
+				runtime.ReadMemStats(&before)
+				err := s.Scan(&term)
+				runtime.ReadMemStats(&after)
+				if !errors.Is(err, io.ErrUnexpectedEOF) {
+					t.Errorf("got error %v, want unexpected EOF", err)
+				}
+				if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+					t.Errorf("allocated %d bytes for input of %d bytes", allocated, len(tt.input))
+				}
+			})
+		}
+	}
+}
+
+func TestScanLongBinaryFromReader(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789"), 3*maxReadPart/10+7)
+	input := binary.BigEndian.AppendUint32([]byte{byte(BinaryExt)}, uint32(len(payload)))
+	input = append(input, payload...)
+	for kind, s := range newScanners(t, input) {
+		var term erlterm.Term
+		term.Reset()
+		err := s.Scan(&term)
+		if err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		if !bytes.Equal(term.Binary, payload) {
+			t.Errorf("%s: payload of %d bytes read as %d bytes", kind, len(payload), len(term.Binary))
+		}
+	}
+}
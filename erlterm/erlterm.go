@@ -2,6 +2,10 @@
 // Only small subset of Erlang terms is supported.
 package erlterm
 
+import (
+	"math/big"
+)
+
 const defaultBinarySize = 256
 
 // TermType is Erlanf data type tag used in serialisation
@@ -13,6 +17,9 @@ type Term struct {
 	IntegerValue int64
 	FloatValue   float64
 	Binary       []byte
+	// BigValue holds arbitrary precision value of big integers.
+	// IntegerValue is set as well when the value fits into int64.
+	BigValue *big.Int
 }
 
 // Reset resets content of the term and readies it for (re)use
@@ -24,5 +31,8 @@ func (t *Term) Reset() error {
 	} else {
 		t.Binary = t.Binary[:0]
 	}
+	if t.BigValue != nil {
+		t.BigValue.SetInt64(0)
+	}
 	return nil
 }
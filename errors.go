@@ -0,0 +1,105 @@
+package uncouch
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/pipedrive/uncouch/couchbytes"
+	"github.com/pipedrive/uncouch/couchdbfile"
+	"github.com/pipedrive/uncouch/erldeser"
+	"github.com/pipedrive/uncouch/jsonser"
+)
+
+// Errors returned by uncouch. Returned errors may wrap them, so check
+// them with errors.Is and errors.As.
+var (
+	// ErrNotFound is returned by Get when there is no document with given ID
+	ErrNotFound = errors.New("Document not found")
+	// ErrNoHeader is returned by Open when the file contains no DB header
+	ErrNoHeader = couchdbfile.ErrNoHeader
+	// ErrUnsupportedCompression is returned for blocks compressed with
+	// deflate
+	ErrUnsupportedCompression = couchbytes.ErrUnsupportedCompression
+	// ErrUnsupportedTerm is returned for Erlang terms uncouch can not read
+	ErrUnsupportedTerm = erldeser.ErrUnsupportedTerm
+	// ErrMalformedBody is returned when document body is not Erlang
+	// serialised JSON
+	ErrMalformedBody = jsonser.ErrMalformedBody
+	// ErrInvalidUTF8 is returned for documents with invalid UTF-8 when
+	// InvalidUTF8Fail policy is used
+	ErrInvalidUTF8 = jsonser.ErrInvalidUTF8
+	// ErrMissingBody is returned for revisions whose body is not stored
+	ErrMissingBody = couchdbfile.ErrMissingBody
+)
+
+// CorruptBlockError is returned when block or node at Offset can not be
+// read. Truncated files wrap io.ErrUnexpectedEOF.
+type CorruptBlockError struct {
+	Offset int64
+	Reason string
+	// Err is the underlying error, if any
+	Err error
+}
+
+// Error implements error
+func (e *CorruptBlockError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("Corrupt block at offset %d: %s: %v", e.Offset, e.Reason, e.Err)
+	}
+	return fmt.Sprintf("Corrupt block at offset %d: %s", e.Offset, e.Reason)
+}
+
+// Unwrap returns the underlying error
+func (e *CorruptBlockError) Unwrap() error {
+	return e.Err
+}
+
+// DocumentError is returned when body of document ID stored at Offset can
+// not be read. It wraps the error describing why. Offset is -1 when it
+// is not known.
+type DocumentError struct {
+	ID     string
+	Offset int64
+	Err    error
+}
+
+// Error implements error
+func (e *DocumentError) Error() string {
+	return fmt.Sprintf("Can not read document %q at offset %d: %v", e.ID, e.Offset, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *DocumentError) Unwrap() error {
+	return e.Err
+}
+
+// UnmarshalTypeError is returned by Unmarshal when a body value does not
+// fit the Go value it is stored into
+type UnmarshalTypeError struct {
+	// Value is JSON type of the value: object, array, number, string,
+	// bool or null
+	Value string
+	Type  reflect.Type
+	// Field is dotted path of the struct field, if any
+	Field string
+}
+
+// Error implements error
+func (e *UnmarshalTypeError) Error() string {
+	return (*jsonser.UnmarshalTypeError)(e).Error()
+}
+
+// apiError returns err with error types of the packages uncouch is built
+// on turned into the types above, so errors.As works with them
+func apiError(err error) error {
+	switch e := err.(type) {
+	case *couchdbfile.DocumentError:
+		return &DocumentError{ID: e.ID, Offset: e.Offset, Err: apiError(e.Err)}
+	case *couchbytes.CorruptBlockError:
+		return &CorruptBlockError{Offset: e.Offset, Reason: e.Reason, Err: apiError(e.Err)}
+	case *jsonser.UnmarshalTypeError:
+		return (*UnmarshalTypeError)(e)
+	}
+	return err
+}
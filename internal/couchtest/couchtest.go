@@ -0,0 +1,461 @@
+// Package couchtest writes small CouchDB database files for tests. Files
+// have uncompressed terms, ID and Sequence Btrees of a few nodes and
+// document revision trees built of leaf revisions.
+package couchtest
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// nodeSize is number of documents in single kv_node, kept small so even
+// tiny databases have kp_node roots
+const nodeSize = 4
+
+const blockSize = 4096
+
+// Document is document with its revision tree
+type Document struct {
+	ID string
+	// Leaves are leaf revisions of the revision tree. Leaves sharing
+	// revisions towards the root form branches of the same tree.
+	Leaves []Leaf
+}
+
+// Leaf is leaf revision with its path to the root
+type Leaf struct {
+	// Pos is the revision number of the leaf, 1 when zero
+	Pos int
+	// Revs are revision ids from the leaf to the root, as listed by
+	// _revisions
+	Revs    []string
+	Deleted bool
+	// Body is JSON object of the revision, empty object when not set
+	Body        string
+	Attachments []Attachment
+}
+
+// Attachment is attachment stored with leaf revision
+type Attachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+	RevPos      int
+}
+
+// Doc returns document with single revision pos-rev of body
+func Doc(id string, pos int, rev string, body string) Document {
+	return Document{ID: id, Leaves: []Leaf{{Pos: pos, Revs: []string{rev}, Body: body}}}
+}
+
+// Deleted returns deleted document with single revision pos-rev
+func Deleted(id string, pos int, rev string) Document {
+	return Document{ID: id, Leaves: []Leaf{{Pos: pos, Revs: []string{rev}, Deleted: true}}}
+}
+
+// File writes database of documents into a temporary directory of the
+// test and returns its path. Documents get update sequences in the order
+// given, starting at 1.
+func File(t testing.TB, docs ...Document) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.couch")
+	b, err := Encode(docs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Encode returns database file of documents
+func Encode(docs ...Document) ([]byte, error) {
+	var w fileWriter
+	// First block holds the header of an empty database
+	w.writeHeader(header(list{}, list{}, 0))
+	entries := make([]entry, len(docs))
+	for i, doc := range docs {
+		tree, err := w.writeRevTree(doc, int64(i+1))
+		if err != nil {
+			return nil, fmt.Errorf("Document %q: %w", doc.ID, err)
+		}
+		deleted := 1
+		for _, l := range doc.Leaves {
+			if !l.Deleted {
+				deleted = 0
+			}
+		}
+		entries[i] = entry{id: doc.ID, seq: int64(i + 1), deleted: deleted, tree: tree}
+	}
+
+	var seqPointers list
+	for i := 0; i < len(entries); i += nodeSize {
+		chunk := entries[i:min(i+nodeSize, len(entries))]
+		var kvs list
+		for _, e := range chunk {
+			kvs = append(kvs, tuple{e.seq, tuple{e.id, e.deleted, sizes, e.tree}})
+		}
+		offset := w.writeChunk(encode(tuple{atom("kv_node"), kvs}))
+		last := chunk[len(chunk)-1].seq
+		seqPointers = append(seqPointers, tuple{last, tuple{offset, int64(len(chunk)), 100}})
+	}
+	var seqRoot interface{} = list{}
+	if len(seqPointers) > 0 {
+		offset := w.writeChunk(encode(tuple{atom("kp_node"), seqPointers}))
+		seqRoot = tuple{offset, int64(len(entries)), 100}
+	}
+
+	byID := append([]entry(nil), entries...)
+	sort.Slice(byID, func(i, j int) bool { return byID[i].id < byID[j].id })
+	var idPointers list
+	var docCount, deletedCount int64
+	for i := 0; i < len(byID); i += nodeSize {
+		chunk := byID[i:min(i+nodeSize, len(byID))]
+		var kvs list
+		var live, deleted int64
+		for _, e := range chunk {
+			kvs = append(kvs, tuple{e.id, tuple{e.seq, e.deleted, sizes, e.tree}})
+			if e.deleted != 0 {
+				deleted++
+			} else {
+				live++
+			}
+		}
+		offset := w.writeChunk(encode(tuple{atom("kv_node"), kvs}))
+		last := chunk[len(chunk)-1].id
+		idPointers = append(idPointers, tuple{last, tuple{offset, tuple{live, deleted, sizes}, 100}})
+		docCount += live
+		deletedCount += deleted
+	}
+	var idRoot interface{} = list{}
+	if len(idPointers) > 0 {
+		offset := w.writeChunk(encode(tuple{atom("kp_node"), idPointers}))
+		idRoot = tuple{offset, tuple{docCount, deletedCount, sizes}, 100}
+	}
+	w.writeHeader(header(idRoot, seqRoot, int64(len(entries))))
+	return w.buf, nil
+}
+
+// entry is document in Btree nodes
+type entry struct {
+	id      string
+	seq     int64
+	deleted int
+	tree    list
+}
+
+// sizes are {ActiveSize, ExternalSize} of nodes and revisions
+var sizes = tuple{100, 200}
+
+// header returns db_header term of Btree roots, [] for empty trees
+func header(idRoot, seqRoot interface{}, updateSeq int64) tuple {
+	return tuple{atom("db_header"), 6, updateSeq, 0, idRoot, seqRoot,
+		nil, 0, nil, nil, 1000, "uuid", list{}, 0}
+}
+
+// revNode is node of revision tree
+type revNode struct {
+	id       string
+	leaf     *Leaf
+	children []*revNode
+}
+
+// writeRevTree writes bodies of document leaves and returns its
+// [{Start, Tree}] revision tree. Leaves of the same root share a tree.
+func (w *fileWriter) writeRevTree(doc Document, seq int64) (list, error) {
+	type root struct {
+		start int
+		node  *revNode
+	}
+	var roots []*root
+	for i := range doc.Leaves {
+		l := &doc.Leaves[i]
+		if len(l.Revs) == 0 {
+			return nil, fmt.Errorf("Leaf %d has no revisions", i)
+		}
+		pos := l.Pos
+		if pos == 0 {
+			pos = 1
+		}
+		start := pos - len(l.Revs) + 1
+		if start < 1 {
+			return nil, fmt.Errorf("Leaf %d-%s has more revisions than its position", pos, l.Revs[0])
+		}
+		rootID := l.Revs[len(l.Revs)-1]
+		var r *root
+		for _, existing := range roots {
+			if existing.start == start && existing.node.id == rootID {
+				r = existing
+			}
+		}
+		if r == nil {
+			r = &root{start: start, node: &revNode{id: rootID}}
+			roots = append(roots, r)
+		}
+		node := r.node
+		for j := len(l.Revs) - 2; j >= 0; j-- {
+			var child *revNode
+			for _, c := range node.children {
+				if c.id == l.Revs[j] {
+					child = c
+				}
+			}
+			if child == nil {
+				child = &revNode{id: l.Revs[j]}
+				node.children = append(node.children, child)
+			}
+			node = child
+		}
+		node.leaf = l
+	}
+	var tree list
+	for _, r := range roots {
+		term, err := w.writeRevNode(r.node, seq)
+		if err != nil {
+			return nil, err
+		}
+		tree = append(tree, tuple{r.start, term})
+	}
+	return tree, nil
+}
+
+// writeRevNode returns {RevId, Leaf, Children} of node, writing bodies
+// of leaves. Children are sorted by revision id as in CouchDB.
+func (w *fileWriter) writeRevNode(node *revNode, seq int64) (tuple, error) {
+	sort.Slice(node.children, func(i, j int) bool { return node.children[i].id < node.children[j].id })
+	var children list
+	for _, c := range node.children {
+		term, err := w.writeRevNode(c, seq)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, term)
+	}
+	if node.leaf == nil {
+		return tuple{node.id, list{}, children}, nil
+	}
+	body, err := w.writeBody(node.leaf)
+	if err != nil {
+		return nil, err
+	}
+	deleted := 0
+	if node.leaf.Deleted {
+		deleted = 1
+	}
+	return tuple{node.id, tuple{deleted, body, seq, sizes, list{}}, children}, nil
+}
+
+// writeBody writes attachments and {Body, Atts} summary of leaf and
+// returns offset of the summary
+func (w *fileWriter) writeBody(l *Leaf) (int64, error) {
+	var atts list
+	for _, a := range l.Attachments {
+		offset := w.writeChunk(a.Data)
+		digest := md5.Sum(a.Data)
+		atts = append(atts, tuple{a.Name, a.ContentType, list{tuple{offset, len(a.Data)}},
+			len(a.Data), len(a.Data), a.RevPos, digest[:], atom("identity")})
+	}
+	text := l.Body
+	if text == "" {
+		text = "{}"
+	}
+	body, err := fromJSON(text)
+	if err != nil {
+		return 0, err
+	}
+	summary := encode(tuple{encode(body), encode(atts)})
+	return w.writeSummary(summary), nil
+}
+
+// fileWriter builds file content, skipping block prefix bytes at every
+// 4 KiB boundary
+type fileWriter struct {
+	buf []byte
+}
+
+// write appends data, adding zero prefix byte to every block it enters
+func (w *fileWriter) write(data []byte) {
+	for _, c := range data {
+		if len(w.buf)%blockSize == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		w.buf = append(w.buf, c)
+	}
+}
+
+// writeChunk writes data with its size and returns its offset
+func (w *fileWriter) writeChunk(data []byte) int64 {
+	offset := int64(len(w.buf))
+	w.write(binary.BigEndian.AppendUint32(nil, uint32(len(data))))
+	w.write(data)
+	return offset
+}
+
+// writeSummary writes data with its MD5 hash and returns its offset
+func (w *fileWriter) writeSummary(data []byte) int64 {
+	offset := int64(len(w.buf))
+	digest := md5.Sum(data)
+	w.write(binary.BigEndian.AppendUint32(nil, uint32(len(data))|1<<31))
+	w.write(digest[:])
+	w.write(data)
+	return offset
+}
+
+// writeHeader writes header block with MD5 hash of the term
+func (w *fileWriter) writeHeader(term tuple) {
+	for len(w.buf)%blockSize != 0 {
+		w.buf = append(w.buf, 0)
+	}
+	data := encode(term)
+	digest := md5.Sum(data)
+	w.buf = append(w.buf, 1)
+	w.write(binary.BigEndian.AppendUint32(nil, uint32(len(data)+len(digest))))
+	w.write(digest[:])
+	w.write(data)
+}
+
+// Erlang terms written by encode
+type (
+	atom  string
+	tuple []interface{}
+	list  []interface{}
+)
+
+// encode returns external term format of term with the magic number
+func encode(term interface{}) []byte {
+	return appendTerm([]byte{131}, term)
+}
+
+// appendTerm appends term without the magic number
+func appendTerm(b []byte, term interface{}) []byte {
+	switch v := term.(type) {
+	case nil:
+		return appendTerm(b, atom("null"))
+	case bool:
+		return appendTerm(b, atom(fmt.Sprint(v)))
+	case atom:
+		b = binary.BigEndian.AppendUint16(append(b, 'd'), uint16(len(v)))
+		return append(b, v...)
+	case int:
+		return appendTerm(b, int64(v))
+	case int64:
+		switch {
+		case v >= 0 && v < 256:
+			return append(b, 'a', byte(v))
+		case v >= math.MinInt32 && v <= math.MaxInt32:
+			return binary.BigEndian.AppendUint32(append(b, 'b'), uint32(v))
+		}
+		return appendTerm(b, big.NewInt(v))
+	case *big.Int:
+		if v.IsInt64() && v.Int64() >= math.MinInt32 && v.Int64() <= math.MaxInt32 {
+			return appendTerm(b, v.Int64())
+		}
+		digits := new(big.Int).Abs(v).Bytes()
+		if len(digits) < 256 {
+			b = append(b, 'n', byte(len(digits)))
+		} else {
+			b = binary.BigEndian.AppendUint32(append(b, 'o'), uint32(len(digits)))
+		}
+		if v.Sign() < 0 {
+			b = append(b, 1)
+		} else {
+			b = append(b, 0)
+		}
+		for i := len(digits) - 1; i >= 0; i-- {
+			b = append(b, digits[i])
+		}
+		return b
+	case float64:
+		return binary.BigEndian.AppendUint64(append(b, 'F'), math.Float64bits(v))
+	case string:
+		b = binary.BigEndian.AppendUint32(append(b, 'm'), uint32(len(v)))
+		return append(b, v...)
+	case []byte:
+		b = binary.BigEndian.AppendUint32(append(b, 'm'), uint32(len(v)))
+		return append(b, v...)
+	case tuple:
+		b = append(b, 'h', byte(len(v)))
+		for _, e := range v {
+			b = appendTerm(b, e)
+		}
+		return b
+	case list:
+		if len(v) == 0 {
+			return append(b, 'j')
+		}
+		b = binary.BigEndian.AppendUint32(append(b, 'l'), uint32(len(v)))
+		for _, e := range v {
+			b = appendTerm(b, e)
+		}
+		return append(b, 'j')
+	}
+	panic(fmt.Sprintf("couchtest: can not encode %T", term))
+}
+
+// fromJSON returns the term CouchDB stores for JSON text: objects are
+// {[{Key, Value}]}, integers are kept exact and keys keep their order
+func fromJSON(text string) (interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	var read func() (interface{}, error)
+	read = func() (interface{}, error) {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch v := token.(type) {
+		case json.Delim:
+			var elements list
+			for dec.More() {
+				if v == '{' {
+					key, err := dec.Token()
+					if err != nil {
+						return nil, err
+					}
+					value, err := read()
+					if err != nil {
+						return nil, err
+					}
+					elements = append(elements, tuple{key.(string), value})
+					continue
+				}
+				value, err := read()
+				if err != nil {
+					return nil, err
+				}
+				elements = append(elements, value)
+			}
+			if _, err = dec.Token(); err != nil {
+				return nil, err
+			}
+			if v == '{' {
+				return tuple{elements}, nil
+			}
+			return elements, nil
+		case json.Number:
+			if n, ok := new(big.Int).SetString(string(v), 10); ok {
+				return n, nil
+			}
+			return v.Float64()
+		}
+		return token, nil
+	}
+	term, err := read()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := term.(tuple); !ok {
+		return nil, fmt.Errorf("Body %s is not JSON object", text)
+	}
+	return term, nil
+}
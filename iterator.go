@@ -0,0 +1,67 @@
+package uncouch
+
+import (
+	"io"
+
+	"github.com/pipedrive/uncouch/couchdbfile"
+)
+
+// Iterator walks documents of the database. Call Next before reading the
+// first document and check Err after Next returns false.
+type Iterator struct {
+	db          *DB
+	c           *couchdbfile.Cursor
+	di          *couchdbfile.DocumentInfo
+	skipDeleted bool
+	err         error
+}
+
+// Next advances to the next document. It returns false when there are no
+// more documents or an error occurred.
+func (it *Iterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	for {
+		di, err := it.c.Next()
+		if err != nil {
+			it.di = nil
+			if err != io.EOF {
+				it.err = apiError(err)
+			}
+			return false
+		}
+		if it.skipDeleted && di.Deleted != 0 {
+			continue
+		}
+		it.di = di
+		return true
+	}
+}
+
+// Err returns error which stopped the iteration, if any
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Meta returns metadata of the current document without reading its body
+func (it *Iterator) Meta() Document {
+	return *newDocument(it.di)
+}
+
+// Document reads the current document with its body
+func (it *Iterator) Document() (*Document, error) {
+	return it.db.readDocument(it.di)
+}
+
+// WriteBody streams body of the current document as JSON object to
+// output, memory use does not depend on the document size
+func (it *Iterator) WriteBody(output io.Writer) error {
+	return apiError(it.db.cf.StreamDocument(it.di, output))
+}
+
+// Unmarshal decodes body of the current document into v like json.Decoder
+// with UseNumber does, without rendering JSON first
+func (it *Iterator) Unmarshal(v interface{}) error {
+	return apiError(it.db.cf.UnmarshalDocument(it.di, v))
+}
@@ -0,0 +1,224 @@
+package jsonser
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/pipedrive/uncouch/erldeser"
+	"github.com/pipedrive/uncouch/erlterm"
+)
+
+// Decoder builds Go values from Erlang serialised JSON read by the
+// scanner, without rendering JSON text first. Objects are decoded as
+// map[string]interface{} or *OrderedMap, arrays as []interface{},
+// numbers as json.Number, strings as string, true and false as bool
+// and null as nil, the same values encoding/json produces with UseNumber.
+type Decoder struct {
+	s       *erldeser.Scanner
+	t       erlterm.Term
+	ordered bool
+	policy  UTF8Policy
+	floats  FloatFormat
+}
+
+// NewDecoder returns decoder reading from the scanner
+func NewDecoder(s *erldeser.Scanner) *Decoder {
+	d := &Decoder{s: s}
+	d.t.Reset()
+	return d
+}
+
+// Decode reads single value from the scanner with default settings
+func Decode(s *erldeser.Scanner) (interface{}, error) {
+	return NewDecoder(s).Decode()
+}
+
+// UseOrderedMap makes objects decode as *OrderedMap keeping key order
+func (d *Decoder) UseOrderedMap() {
+	d.ordered = true
+}
+
+// SetUTF8Policy sets how invalid UTF-8 in keys and strings is decoded.
+// UTF8Replace uses U+FFFD and UTF8Escape the Latin-1 character of the byte.
+func (d *Decoder) SetUTF8Policy(policy UTF8Policy) {
+	d.policy = policy
+}
+
+// SetFloatFormat sets format of json.Number values of floats
+func (d *Decoder) SetFloatFormat(format FloatFormat) {
+	d.floats = format
+}
+
+// Decode reads single value from the scanner
+func (d *Decoder) Decode() (interface{}, error) {
+	err := d.s.Scan(&d.t)
+	if err != nil {
+		return nil, err
+	}
+	return d.value()
+}
+
+// value decodes value of the term read last
+func (d *Decoder) value() (interface{}, error) {
+	t := &d.t
+	switch t.Term {
+	case erldeser.NewFloatExt:
+		var f []byte
+		if d.floats == FloatCouchDB {
+			f = appendCouchDBFloat(nil, t.FloatValue)
+		} else {
+			f = appendFloat(nil, t.FloatValue)
+		}
+		return json.Number(f), nil
+	case erldeser.SmallIntegerExt, erldeser.IntegerExt:
+		return json.Number(strconv.FormatInt(t.IntegerValue, 10)), nil
+	case erldeser.SmallBigExt, erldeser.LargeBigExt:
+		return json.Number(t.BigValue.String()), nil
+	case erldeser.AtomExt:
+		switch string(t.Binary) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%w: unexpected atom %q", ErrMalformedBody, t.Binary)
+	case erldeser.BinaryExt:
+		return d.decodeString(t.Binary)
+	case erldeser.StringExt:
+		// Actually array of small integers
+		values := make([]interface{}, len(t.Binary))
+		for i, b := range t.Binary {
+			values[i] = json.Number(strconv.Itoa(int(b)))
+		}
+		return values, nil
+	case erldeser.NilExt:
+		return []interface{}{}, nil
+	case erldeser.ListExt:
+		return d.array(int(t.IntegerValue))
+	case erldeser.SmallTupleExt:
+		return d.object()
+	default:
+		return nil, fmt.Errorf("%w: Don't know how to turn type %v into JSON value", ErrMalformedBody, erldeser.TypeName(t.Term))
+	}
+}
+
+// maxPresize is the most elements allocated up front for lists and
+// objects, their length comes from the input and may be corrupt
+const maxPresize = 1024
+
+// presize returns capacity to allocate for length elements
+func presize(length int) int {
+	if length > maxPresize {
+		return maxPresize
+	}
+	return length
+}
+
+// array decodes list of length elements and its tail
+func (d *Decoder) array(length int) (interface{}, error) {
+	values := make([]interface{}, 0, presize(length))
+	for i := 0; i < length; i++ {
+		v, err := d.Decode()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	err := d.listTail()
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// object decodes {[{Key, Value}]} object whose tuple header was read last
+func (d *Decoder) object() (interface{}, error) {
+	err := d.s.Scan(&d.t)
+	if err != nil {
+		return nil, err
+	}
+	length := 0
+	switch d.t.Term {
+	case erldeser.ListExt:
+		length = int(d.t.IntegerValue)
+	case erldeser.NilExt:
+	default:
+		return nil, fmt.Errorf("%w: Erlang serialised JSON object should start as tuple containing list, we got %v", ErrMalformedBody, erldeser.TypeName(d.t.Term))
+	}
+	var (
+		om *OrderedMap
+		m  map[string]interface{}
+	)
+	if d.ordered {
+		om = NewOrderedMap(presize(length))
+	} else {
+		m = make(map[string]interface{}, presize(length))
+	}
+	for i := 0; i < length; i++ {
+		err = d.s.Scan(&d.t)
+		if err != nil {
+			return nil, err
+		}
+		if d.t.Term != erldeser.SmallTupleExt {
+			return nil, fmt.Errorf("%w: Erlang serialised JSON key-value pair should be inside tuple, we got %v", ErrMalformedBody, erldeser.TypeName(d.t.Term))
+		}
+		err = d.s.Scan(&d.t)
+		if err != nil {
+			return nil, err
+		}
+		if d.t.Term != erldeser.BinaryExt {
+			return nil, fmt.Errorf("%w: Erlang serialised JSON key should be binary, we got %v", ErrMalformedBody, erldeser.TypeName(d.t.Term))
+		}
+		key, err := d.decodeString(d.t.Binary)
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.Decode()
+		if err != nil {
+			return nil, err
+		}
+		if d.ordered {
+			om.Set(key, v)
+		} else {
+			m[key] = v
+		}
+	}
+	if length > 0 {
+		err = d.listTail()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if d.ordered {
+		return om, nil
+	}
+	return m, nil
+}
+
+// listTail reads nil at the end of non-empty list
+func (d *Decoder) listTail() error {
+	err := d.s.Scan(&d.t)
+	if err != nil {
+		return err
+	}
+	if d.t.Term != erldeser.NilExt {
+		return fmt.Errorf("%w: Erlang serialised list should end with extra nil, but ends with %v", ErrMalformedBody, erldeser.TypeName(d.t.Term))
+	}
+	return nil
+}
+
+// decodeString returns b as string, handling invalid UTF-8 by policy
+func (d *Decoder) decodeString(b []byte) (string, error) {
+	if utf8.Valid(b) {
+		return string(b), nil
+	}
+	valid, err := AppendValidUTF8(nil, b, d.policy)
+	if err != nil {
+		return "", err
+	}
+	return string(valid), nil
+}
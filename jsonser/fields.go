@@ -0,0 +1,131 @@
+package jsonser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/pipedrive/uncouch/erldeser"
+)
+
+// Field is top level field written into document before the fields
+// stored in the document
+type Field struct {
+	Key string
+	// Value is JSON encoded value
+	Value []byte
+}
+
+// StringField returns field with string value
+func StringField(key, value string) Field {
+	var enc stringEncoder
+	quoted, _ := enc.appendString(nil, []byte(value))
+	return Field{Key: key, Value: quoted}
+}
+
+// IntField returns field with integer value
+func IntField(key string, value int64) Field {
+	return Field{Key: key, Value: strconv.AppendInt(nil, value, 10)}
+}
+
+// BoolField returns field with boolean value
+func BoolField(key string, value bool) Field {
+	return Field{Key: key, Value: strconv.AppendBool(nil, value)}
+}
+
+// hasField tells if key is one of the fields
+func hasField(fields []Field, key []byte) bool {
+	for i := range fields {
+		if fields[i].Key == string(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteJSONWithFields works as WriteJSON, but writes fields at the
+// beginning of the document object. Document fields with the same keys
+// are left out, so fields take precedence.
+func (js *JSONSer) WriteJSONWithFields(w io.Writer, fields []Field) error {
+	return writeTo(w, func(collector writer) error {
+		t := js.getTerm()
+		defer js.putTerm(t)
+		err := js.s.ScanHeader(t)
+		if err != nil {
+			return err
+		}
+		if t.Term != erldeser.SmallTupleExt {
+			return fmt.Errorf("%w: document should be JSON object, we got %v", ErrMalformedBody, erldeser.TypeName(t.Term))
+		}
+		return js.readJSONObject(collector, fields)
+	})
+}
+
+// AppendWithFields appends JSON object with fields written at its
+// beginning, object keys in fields are left out the same way
+// WriteJSONWithFields does. Values of the object are copied as they are.
+func AppendWithFields(dst []byte, object []byte, fields []Field) ([]byte, error) {
+	var enc stringEncoder
+	dec := json.NewDecoder(bytes.NewReader(object))
+	t, err := dec.Token()
+	if err != nil {
+		return dst, err
+	}
+	if t != json.Delim('{') {
+		return dst, fmt.Errorf("%w: document should be JSON object", ErrMalformedBody)
+	}
+	dst = append(dst, '{')
+	for i, f := range fields {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst, err = enc.appendString(dst, []byte(f.Key))
+		if err != nil {
+			return dst, err
+		}
+		dst = append(dst, ':')
+		dst = append(dst, f.Value...)
+	}
+	first := len(fields) == 0
+	for dec.More() {
+		t, err = dec.Token()
+		if err != nil {
+			return dst, err
+		}
+		key, _ := t.(string)
+		var value json.RawMessage
+		err = dec.Decode(&value)
+		if err != nil {
+			return dst, err
+		}
+		if hasField(fields, []byte(key)) {
+			continue
+		}
+		if !first {
+			dst = append(dst, ',')
+		}
+		first = false
+		dst, err = enc.appendString(dst, []byte(key))
+		if err != nil {
+			return dst, err
+		}
+		dst = append(dst, ':')
+		dst = append(dst, value...)
+	}
+	return append(dst, '}'), nil
+}
+
+// discard is writer dropping everything, used to skip values
+type discard struct{}
+
+// Write implements io.Writer
+func (discard) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// WriteString implements io.StringWriter
+func (discard) WriteString(s string) (int, error) {
+	return len(s), nil
+}
@@ -0,0 +1,94 @@
+package jsonser
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// FloatFormat decides how floats are written
+type FloatFormat int
+
+const (
+	// FloatJSON writes floats the same way encoding/json does, integral
+	// floats have no fraction
+	FloatJSON FloatFormat = iota
+	// FloatCouchDB writes floats the same way CouchDB does with jiffy,
+	// integral floats keep ".0" so they stay floats
+	FloatCouchDB
+)
+
+// floatFormatNames are names used by ParseFloatFormat and String
+var floatFormatNames = map[FloatFormat]string{
+	FloatJSON:    "json",
+	FloatCouchDB: "couchdb",
+}
+
+// String implements Stringer
+func (f FloatFormat) String() string {
+	if name, ok := floatFormatNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("FloatFormat(%d)", int(f))
+}
+
+// ParseFloatFormat returns float format of name json or couchdb
+func ParseFloatFormat(name string) (FloatFormat, error) {
+	for f, n := range floatFormatNames {
+		if n == name {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown float format %q, expecting json or couchdb", name)
+}
+
+// appendCouchDBFloat formats float as jiffy does: shortest representation
+// that reads back the same, decimal form for exponents from -6 to 20
+// with at least one digit after the point, exponential form otherwise.
+// Negative zero is written as 0.0.
+func appendCouchDBFloat(b []byte, f float64) []byte {
+	if f == 0 {
+		return append(b, "0.0"...)
+	}
+	abs := math.Abs(f)
+	if abs < 1e-6 || abs >= 1e21 {
+		// jiffy writes exponents with sign and without leading zeros,
+		// such as 1e+21 and 1.5e-7, the same as encoding/json does
+		return appendFloat(b, f)
+	}
+	start := len(b)
+	b = strconv.AppendFloat(b, f, 'f', -1, 64)
+	if bytes.IndexByte(b[start:], '.') < 0 {
+		b = append(b, ".0"...)
+	}
+	return b
+}
+
+// appendFloat formats float in the format set by SetFloatFormat
+func (js *JSONSer) appendFloat(b []byte, f float64) []byte {
+	if js.floats == FloatCouchDB {
+		return appendCouchDBFloat(b, f)
+	}
+	return appendFloat(b, f)
+}
+
+// appendFloat formats float the same way encoding/json does, so numbers
+// keep their form when documents are decoded with UseNumber
+func appendFloat(b []byte, f float64) []byte {
+	abs := math.Abs(f)
+	format := byte('f')
+	if abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	b = strconv.AppendFloat(b, f, format, -1, 64)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		n := len(b)
+		if n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	return b
+}
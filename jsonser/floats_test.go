@@ -0,0 +1,82 @@
+package jsonser
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+// couchDBFloats are floats as CouchDB writes them. jiffy formats floats
+// with double-conversion ToShortest, using decimal form for exponents from
+// -6 to 20, trailing ".0" for integral values, "e" with explicit sign for
+// exponents and a single zero.
+var couchDBFloats = []struct {
+	value float64
+	want  string
+}{
+	{0, "0.0"},
+	{math.Copysign(0, -1), "0.0"},
+	{1, "1.0"},
+	{-1, "-1.0"},
+	{100, "100.0"},
+	{0.1, "0.1"},
+	{-2.5, "-2.5"},
+	{1.25, "1.25"},
+	{123.456, "123.456"},
+	{1.0 / 3, "0.3333333333333333"},
+	{9007199254740993, "9007199254740992.0"},
+	{1e20, "100000000000000000000.0"},
+	{123456789012345678901, "123456789012345680000.0"},
+	{1e21, "1e+21"},
+	{-1e21, "-1e+21"},
+	{1.5e300, "1.5e+300"},
+	{math.MaxFloat64, "1.7976931348623157e+308"},
+	{1e-6, "0.000001"},
+	{1.5e-6, "0.0000015"},
+	{1e-7, "1e-7"},
+	{-1.5e-7, "-1.5e-7"},
+	{1.2345e-10, "1.2345e-10"},
+	{5e-324, "5e-324"},
+}
+
+func TestAppendCouchDBFloat(t *testing.T) {
+	for _, tt := range couchDBFloats {
+		got := string(appendCouchDBFloat(nil, tt.value))
+		if got != tt.want {
+			t.Errorf("%g: got %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestAppendFloatMatchesEncodingJSON(t *testing.T) {
+	for _, tt := range couchDBFloats {
+		want, err := json.Marshal(tt.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := string(appendFloat(nil, tt.value))
+		if got != string(want) {
+			t.Errorf("%g: got %s, encoding/json writes %s", tt.value, got, want)
+		}
+	}
+}
+
+func TestWriteJSONFloatFormat(t *testing.T) {
+	input := etfList(etfFloat(1), etfFloat(0.5), etfFloat(1e21))
+	tests := []struct {
+		format FloatFormat
+		want   string
+	}{
+		{FloatJSON, "[1,0.5,1e+21]"},
+		{FloatCouchDB, "[1.0,0.5,1e+21]"},
+	}
+	for _, tt := range tests {
+		got, err := renderJSON(t, input, func(js *JSONSer) { js.SetFloatFormat(tt.format) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%v: got %s, want %s", tt.format, got, tt.want)
+		}
+	}
+}
@@ -3,21 +3,30 @@
 package jsonser
 
 import (
+	"bufio"
 	"bytes"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"regexp"
+	"io"
 	"strconv"
-	"strings"
+	"unicode/utf8"
 
 	"github.com/pipedrive/uncouch/erldeser"
 	"github.com/pipedrive/uncouch/erlterm"
 )
 
+// ErrMalformedBody is returned when document body is not Erlang
+// serialised JSON
+var ErrMalformedBody = errors.New("Malformed document body")
+
 // JSONSer implements JSON serialiser from provided scanner
 type JSONSer struct {
 	termPool []*erlterm.Term
 	s        *erldeser.Scanner
+	enc      stringEncoder
+	floats   FloatFormat
+	// scratch is reused for escaping strings
+	scratch []byte
 }
 
 // New will return JSON serialiser
@@ -30,7 +39,35 @@ func New(s *erldeser.Scanner) (*JSONSer, error) {
 	return js, nil
 }
 
-const maxTermPoolSize = 500
+// SetUTF8Policy sets how invalid UTF-8 in keys and strings is written,
+// UTF8Replace is used by default
+func (js *JSONSer) SetUTF8Policy(policy UTF8Policy) {
+	js.enc.policy = policy
+}
+
+// SetFloatFormat sets how floats are written, FloatJSON is used by default
+func (js *JSONSer) SetFloatFormat(format FloatFormat) {
+	js.floats = format
+}
+
+// SetASCII makes strings ASCII only, non-ASCII characters are written as
+// \uXXXX escapes like jiffy does with uescape option
+func (js *JSONSer) SetASCII(ascii bool) {
+	js.enc.ascii = ascii
+}
+
+const (
+	maxTermPoolSize = 500
+	// binaries longer than longBinarySize are streamed in parts
+	longBinarySize = 32 * 1024
+)
+
+// writer is output JSON is written into. It is implemented by
+// bytes.Buffer and bufio.Writer.
+type writer interface {
+	io.Writer
+	io.StringWriter
+}
 
 // getTerm returns Term object, trying to reuse if possible
 func (js *JSONSer) getTerm() (t *erlterm.Term) {
@@ -55,268 +92,334 @@ func (js *JSONSer) putTerm(t *erlterm.Term) {
 func (js *JSONSer) WriteJSONToBuffer(collector *bytes.Buffer) error {
 	err := js.readJSONValue(collector)
 	if err != nil {
-		slog.Error(err)
 		return err
 	}
 	return nil
 }
 
-// readJSONKeyValue reads JSON key-value pairs from Erlang serialised form
-func (js *JSONSer) readJSONKeyValue(collector *bytes.Buffer) error {
+// WriteJSON writes Erlang serialised JSON to given writer as normal JSON.
+// Long strings are written in parts, so the scanner reading from a stream
+// does not need to hold the whole document in memory.
+func (js *JSONSer) WriteJSON(w io.Writer) error {
+	return writeTo(w, js.readJSONValue)
+}
+
+// writeTo calls write with collector writing to w. Writers without
+// WriteString are buffered, the buffer is flushed after write and its
+// error is returned together with the error of write.
+func writeTo(w io.Writer, write func(collector writer) error) error {
+	collector, ok := w.(writer)
+	if ok {
+		return write(collector)
+	}
+	bw := bufio.NewWriter(w)
+	err := write(bw)
+	flushErr := bw.Flush()
+	if flushErr != nil {
+		return errors.Join(err, flushErr)
+	}
+	return err
+}
+
+// readJSONObject reads {[{Key, Value}]} object whose tuple header was
+// already read. Fields are written first and body keys they contain are
+// skipped.
+func (js *JSONSer) readJSONObject(collector writer, fields []Field) error {
 	t := js.getTerm()
 	defer js.putTerm(t)
-	js.s.Scan(t)
+	err := js.s.Scan(t)
+	if err != nil {
+		return err
+	}
 	switch t.Term {
-	case erldeser.SmallTupleExt:
-		// read key
-		err := js.readJSONKey(collector)
+	case erldeser.ListExt:
+	case erldeser.NilExt:
+		t.IntegerValue = 0
+	default:
+		err := fmt.Errorf("%w: Erlang serialised JSON object should start as tuple containing list, we got %v", ErrMalformedBody, erldeser.TypeName(t.Term))
+		return err
+	}
+	_, err = collector.WriteString("{")
+	if err != nil {
+		return err
+	}
+	for i, f := range fields {
+		if i > 0 {
+			_, err = collector.WriteString(",")
+			if err != nil {
+				return err
+			}
+		}
+		err = js.writeString(collector, []byte(f.Key))
 		if err != nil {
-			slog.Error(err)
 			return err
 		}
-		// read value
-		err = js.readJSONValue(collector)
+		_, err = collector.WriteString(":")
 		if err != nil {
-			slog.Error(err)
 			return err
 		}
-		return nil
-	default:
-		err := fmt.Errorf("Erlang serialised JSON key-value pair should be inside tuple, we got %v", t.Term)
-		slog.Error(err)
-		slog.Debug(collector.String())
+		_, err = collector.Write(f.Value)
+		if err != nil {
+			return err
+		}
+	}
+	first := len(fields) == 0
+	// For each element in the list
+	for i := int64(0); i < t.IntegerValue; i++ {
+		written, err := js.readJSONKeyValue(collector, fields, first)
+		if err != nil {
+			return err
+		}
+		first = first && !written
+	}
+	if t.Term == erldeser.ListExt {
+		// We have extra nil at the end of the list?
+		t := js.getTerm()
+		defer js.putTerm(t)
+		err = js.s.Scan(t)
+		if err != nil {
+			return err
+		}
+		if t.Term != erldeser.NilExt {
+			err = fmt.Errorf("%w: Erlang serialised list should end with extra nil, but ends with %v", ErrMalformedBody, erldeser.TypeName(t.Term))
+			return err
+		}
+	}
+	_, err = collector.WriteString("}")
+	if err != nil {
 		return err
 	}
+	return nil
 }
 
-// readJSONKey is reading Erlang encoded JSON document key
-func (js *JSONSer) readJSONKey(collector *bytes.Buffer) error {
+// readJSONKeyValue reads JSON key-value pair from Erlang serialised form.
+// Pairs with key in fields are skipped, it returns whether pair was written.
+func (js *JSONSer) readJSONKeyValue(collector writer, fields []Field, first bool) (bool, error) {
 	t := js.getTerm()
 	defer js.putTerm(t)
-	js.s.Scan(t)
+	err := js.s.Scan(t)
+	if err != nil {
+		return false, err
+	}
+	if t.Term != erldeser.SmallTupleExt {
+		err := fmt.Errorf("%w: Erlang serialised JSON key-value pair should be inside tuple, we got %v", ErrMalformedBody, erldeser.TypeName(t.Term))
+		return false, err
+	}
+	// read key
+	err = js.s.Scan(t)
+	if err != nil {
+		return false, err
+	}
 	if t.Term != erldeser.BinaryExt {
-		err := fmt.Errorf("Erlang serialised JSON key should be binary, we got %v", t.Term)
-		slog.Error(err)
-		return err
+		err := fmt.Errorf("%w: Erlang serialised JSON key should be binary, we got %v", ErrMalformedBody, erldeser.TypeName(t.Term))
+		return false, err
 	}
-	_, err := collector.WriteString("\"")
+	if hasField(fields, t.Binary) {
+		return false, js.readJSONValue(discard{})
+	}
+	if !first {
+		_, err = collector.WriteString(",")
+		if err != nil {
+			return false, err
+		}
+	}
+	err = js.writeString(collector, t.Binary)
 	if err != nil {
-		slog.Error(err)
-		return err
+		return false, err
 	}
-	_, err = collector.WriteString(string(t.Binary))
+	_, err = collector.WriteString(":")
 	if err != nil {
-		slog.Error(err)
-		return err
+		return false, err
 	}
-	_, err = collector.WriteString("\":")
+	// read value
+	err = js.readJSONValue(collector)
 	if err != nil {
-		slog.Error(err)
-		return err
+		return false, err
 	}
-	return nil
+	return true, nil
 }
 
 // readJSONValue is reading Erlang encoded JSON document value
-func (js *JSONSer) readJSONValue(collector *bytes.Buffer) error {
+func (js *JSONSer) readJSONValue(collector writer) error {
 	t := js.getTerm()
 	defer js.putTerm(t)
-	js.s.Scan(t)
+	err := js.s.ScanHeader(t)
+	if err != nil {
+		return err
+	}
 	switch t.Term {
 	case erldeser.NewFloatExt:
-		_, err := collector.WriteString(strconv.FormatFloat(t.FloatValue, 'g', -1, 64))
+		js.scratch = js.appendFloat(js.scratch[:0], t.FloatValue)
+		_, err := collector.Write(js.scratch)
 		if err != nil {
-			slog.Error(err)
 			return err
 		}
 	case erldeser.SmallIntegerExt:
 		_, err := collector.WriteString(strconv.FormatInt(int64(t.IntegerValue), 10))
 		if err != nil {
-			slog.Error(err)
 			return err
 		}
 	case erldeser.IntegerExt:
 		_, err := collector.WriteString(strconv.FormatInt(int64(t.IntegerValue), 10))
 		if err != nil {
-			slog.Error(err)
 			return err
 		}
 	case erldeser.AtomExt:
 		_, err := collector.Write(t.Binary)
 		if err != nil {
-			slog.Error(err)
 			return err
 		}
 	case erldeser.SmallTupleExt:
-		t := js.getTerm()
-		defer js.putTerm(t)
-		js.s.Scan(t)
-		switch t.Term {
-		case erldeser.ListExt:
-			_, err := collector.WriteString("{")
-			if err != nil {
-				slog.Error(err)
-				return err
-			}
-			// For each element in the list
-			for i := int64(0); i < t.IntegerValue; i++ {
-				err := js.readJSONKeyValue(collector)
-				if err != nil {
-					slog.Error(err)
-					return err
-				}
-				if i < t.IntegerValue-1 {
-					_, err = collector.WriteString(",")
-					if err != nil {
-						slog.Error(err)
-						return err
-					}
-				}
-			}
-			// We have extra nil at the end of the list?
-			t := js.getTerm()
-			defer js.putTerm(t)
-			js.s.Scan(t)
-			if t.Term != erldeser.NilExt {
-				err = fmt.Errorf("Erlang serialised list should end with extra nil, but ends with %v", t.Term)
-				slog.Error(err)
-				return err
-			}
-			_, err = collector.WriteString("}")
-			if err != nil {
-				slog.Error(err)
-				return err
-			}
-			return nil
-		case erldeser.NilExt:
-			_, err := collector.WriteString("{}")
-			if err != nil {
-				slog.Error(err)
-				return err
-			}
-		default:
-			slog.Debug(collector.String())
-			err := fmt.Errorf("Erlang serialised JSON object should start as tuple containing list, we got %v", t.Term)
-			slog.Error(err)
+		err := js.readJSONObject(collector, nil)
+		if err != nil {
 			return err
 		}
 	case erldeser.NilExt:
-		_, err := collector.WriteString("null")
+		// Empty list is empty array, null is stored as atom
+		_, err := collector.WriteString("[]")
 		if err != nil {
-			slog.Error(err)
 			return err
 		}
 	case erldeser.StringExt:
 		// Actually array of small integers!!
 		_, err := collector.WriteString("[")
 		if err != nil {
-			slog.Error(err)
 			return err
 		}
 		l := len(t.Binary)
 		for i := 0; i < l; i++ {
 			_, err = collector.WriteString(strconv.FormatInt(int64(t.Binary[i]), 10))
 			if err != nil {
-				slog.Error(err)
 				return err
 			}
 			if i < l-1 {
 				_, err = collector.WriteString(",")
 				if err != nil {
-					slog.Error(err)
 					return err
 				}
 			}
 		}
 		_, err = collector.WriteString("]")
 		if err != nil {
-			slog.Error(err)
 			return err
 		}
-	case erldeser.SmallBigExt:
-		_, err := collector.WriteString(strconv.FormatInt(int64(t.IntegerValue), 10))
+	case erldeser.SmallBigExt, erldeser.LargeBigExt:
+		_, err := collector.WriteString(t.BigValue.String())
 		if err != nil {
-			slog.Error(err)
 			return err
 		}
 	case erldeser.ListExt:
 		_, err := collector.WriteString("[")
 		if err != nil {
-			slog.Error(err)
 			return err
 		}
 		for i := int64(0); i < t.IntegerValue; i++ {
 			err = js.readJSONValue(collector)
 			if err != nil {
-				slog.Error(err)
 				return err
 			}
 			if i < t.IntegerValue-1 {
 				_, err = collector.WriteString(",")
 				if err != nil {
-					slog.Error(err)
 					return err
 				}
 			}
 		}
 		t := js.getTerm()
 		defer js.putTerm(t)
-		js.s.Scan(t)
+		err = js.s.Scan(t)
+		if err != nil {
+			return err
+		}
 		if t.Term != erldeser.NilExt {
-			err = fmt.Errorf("Erlang serialised list should end with extra nil, but ends with %v", t.Term)
-			slog.Error(err)
+			err = fmt.Errorf("%w: Erlang serialised list should end with extra nil, but ends with %v", ErrMalformedBody, erldeser.TypeName(t.Term))
 			return err
 		}
 		_, err = collector.WriteString("]")
 		if err != nil {
-			slog.Error(err)
 			return err
 		}
 	case erldeser.BinaryExt:
-		bin := string(t.Binary)
-		quoted := strconv.Quote(bin)
-		if !validate_str(quoted) {
-			log.Info(fmt.Sprintf("String contains invalid characters: %v.", quoted))
-			var err error
-			quoted, err = sanitize(quoted)
+		if t.IntegerValue > longBinarySize {
+			err = js.writeLongBinary(collector)
 			if err != nil {
-				slog.Error(err)
 				return err
 			}
-			log.Info(fmt.Sprintf("Sanitization result: %v.", quoted))
+			return nil
 		}
-
-		_, err := collector.WriteString(quoted)
+		err = js.s.ScanPayload(t)
+		if err != nil {
+			return err
+		}
+		err = js.writeString(collector, t.Binary)
 		if err != nil {
-			slog.Error(err)
 			return err
 		}
 
 	default:
-		err := fmt.Errorf("Don't know how to turn type %v into JSON value", t.Term)
-		slog.Error(err)
+		err := fmt.Errorf("%w: Don't know how to turn type %v into JSON value", ErrMalformedBody, erldeser.TypeName(t.Term))
 		return err
 	}
 	return nil
 }
 
-func validate_str(str string) bool {
-	if !strings.Contains(str, `\`) {
-		return true
+// writeLongBinary writes binary payload from the scanner as JSON string
+// in parts. Parts are split on UTF-8 character boundaries.
+func (js *JSONSer) writeLongBinary(collector writer) error {
+	_, err := collector.WriteString("\"")
+	if err != nil {
+		return err
 	}
-
-	adhocjson := []byte(`{"validate":` + str + `}`)
-
-	return json.Valid(adhocjson)
+	buf := make([]byte, longBinarySize+utf8.UTFMax)
+	carry := 0
+	for {
+		n, err := js.s.ReadPayload(buf[carry:longBinarySize])
+		if err != nil && err != io.EOF {
+			return err
+		}
+		end := carry + n
+		last := err == io.EOF
+		// Keep incomplete character at the end for the next part
+		split := end
+		if !last {
+			for i := 1; i < utf8.UTFMax && i <= end; i++ {
+				if utf8.RuneStart(buf[end-i]) {
+					if !utf8.FullRune(buf[end-i : end]) {
+						split = end - i
+					}
+					break
+				}
+			}
+		}
+		if split > 0 {
+			js.scratch, err = js.enc.appendEscaped(js.scratch[:0], buf[:split])
+			if err != nil {
+				return err
+			}
+			_, err = collector.Write(js.scratch)
+			if err != nil {
+				return err
+			}
+		}
+		if last {
+			break
+		}
+		carry = copy(buf, buf[split:end])
+	}
+	_, err = collector.WriteString("\"")
+	if err != nil {
+		return err
+	}
+	return nil
 }
 
-func sanitize(src string) (string, error) {
-	re, err := regexp.Compile(`(\\[^bfrnt\\\"])`) // Valid escaped characters.
+// writeString writes binary as quoted JSON string
+func (js *JSONSer) writeString(collector writer, b []byte) error {
+	var err error
+	js.scratch, err = js.enc.appendString(js.scratch[:0], b)
 	if err != nil {
-		slog.Error(err)
-		return "", err
+		return err
 	}
-	repl := []byte(`\$1`)
-
-	return string(re.ReplaceAll([]byte(src), repl)), err
-}
\ No newline at end of file
+	_, err = collector.Write(js.scratch)
+	return err
+}
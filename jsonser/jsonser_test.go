@@ -0,0 +1,188 @@
+package jsonser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/pipedrive/uncouch/erldeser"
+)
+
+// Builders of Erlang serialised JSON used by the tests
+
+func etfBinary(s string) []byte {
+	b := binary.BigEndian.AppendUint32([]byte{byte(erldeser.BinaryExt)}, uint32(len(s)))
+	return append(b, s...)
+}
+
+func etfAtom(s string) []byte {
+	b := binary.BigEndian.AppendUint16([]byte{byte(erldeser.AtomExt)}, uint16(len(s)))
+	return append(b, s...)
+}
+
+func etfFloat(f float64) []byte {
+	return binary.BigEndian.AppendUint64([]byte{byte(erldeser.NewFloatExt)}, math.Float64bits(f))
+}
+
+func etfNil() []byte {
+	return []byte{byte(erldeser.NilExt)}
+}
+
+func etfBig(v *big.Int) []byte {
+	digits := new(big.Int).Abs(v).Bytes()
+	for i, j := 0, len(digits)-1; i < j; i, j = i+1, j-1 {
+		digits[i], digits[j] = digits[j], digits[i]
+	}
+	var b []byte
+	if len(digits) > 255 {
+		b = binary.BigEndian.AppendUint32([]byte{byte(erldeser.LargeBigExt)}, uint32(len(digits)))
+	} else {
+		b = []byte{byte(erldeser.SmallBigExt), byte(len(digits))}
+	}
+	if v.Sign() < 0 {
+		b = append(b, 1)
+	} else {
+		b = append(b, 0)
+	}
+	return append(b, digits...)
+}
+
+func etfList(elements ...[]byte) []byte {
+	if len(elements) == 0 {
+		return etfNil()
+	}
+	b := binary.BigEndian.AppendUint32([]byte{byte(erldeser.ListExt)}, uint32(len(elements)))
+	for _, e := range elements {
+		b = append(b, e...)
+	}
+	return append(b, etfNil()...)
+}
+
+// etfObject returns {[{Key, Value}]} of alternating keys and values
+func etfObject(pairs ...[]byte) []byte {
+	var tuples [][]byte
+	for i := 0; i+1 < len(pairs); i += 2 {
+		tuple := append([]byte{byte(erldeser.SmallTupleExt), 2}, pairs[i]...)
+		tuples = append(tuples, append(tuple, pairs[i+1]...))
+	}
+	return append([]byte{byte(erldeser.SmallTupleExt), 1}, etfList(tuples...)...)
+}
+
+// renderJSON writes Erlang serialised JSON in input with serialiser set
+// up by configure
+func renderJSON(t *testing.T, input []byte, configure func(js *JSONSer)) (string, error) {
+	t.Helper()
+	s, err := erldeser.NewScanner(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	js, err := New(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configure != nil {
+		configure(js)
+	}
+	var out bytes.Buffer
+	err = js.WriteJSONToBuffer(&out)
+	return out.String(), err
+}
+
+func TestWriteJSONBigIntegers(t *testing.T) {
+	parse := func(s string) *big.Int {
+		v, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			t.Fatalf("invalid integer %s", s)
+		}
+		return v
+	}
+	tests := []string{
+		"9223372036854775807",
+		"9223372036854775808",
+		"-9223372036854775808",
+		"-9223372036854775809",
+		"18446744073709551616",
+		"123456789012345678901234567890",
+		new(big.Int).Lsh(big.NewInt(3), 2100).String(),
+	}
+	for _, want := range tests {
+		got, err := renderJSON(t, etfList(etfBig(parse(want))), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != "["+want+"]" {
+			t.Errorf("got %s, want [%s]", got, want)
+		}
+	}
+}
+
+func TestWriteJSONEmptyValues(t *testing.T) {
+	input := etfObject(
+		etfBinary("tags"), etfNil(),
+		etfBinary("owner"), etfAtom("null"),
+		etfBinary("meta"), etfObject(),
+		etfBinary("nested"), etfList(etfNil(), etfObject()),
+	)
+	got, err := renderJSON(t, input, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"tags":[],"owner":null,"meta":{},"nested":[[],{}]}`
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+// plainWriter is io.Writer without WriteString, failing with err when set
+type plainWriter struct {
+	out bytes.Buffer
+	err error
+}
+
+func (w *plainWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	return w.out.Write(p)
+}
+
+func TestWriteJSONFlushesPlainWriter(t *testing.T) {
+	failure := errors.New("Disk full")
+	tests := []struct {
+		name  string
+		write func(js *JSONSer, w io.Writer) error
+		want  string
+	}{
+		{"WriteJSON", func(js *JSONSer, w io.Writer) error {
+			return js.WriteJSON(w)
+		}, `{"a":1}`},
+		{"WriteJSONWithFields", func(js *JSONSer, w io.Writer) error {
+			return js.WriteJSONWithFields(w, []Field{StringField("_id", "doc1")})
+		}, `{"_id":"doc1","a":1}`},
+	}
+	for _, tt := range tests {
+		for _, failing := range []error{nil, failure} {
+			s, err := erldeser.NewScanner(etfObject(etfBinary("a"), etfInteger(big.NewInt(1))))
+			if err != nil {
+				t.Fatal(err)
+			}
+			js, err := New(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Whole document fits into the buffer, so only Flush fails
+			w := &plainWriter{err: failing}
+			err = tt.write(js, w)
+			if !errors.Is(err, failing) {
+				t.Errorf("%s: got error %v, want %v", tt.name, err, failing)
+			}
+			if failing == nil && w.out.String() != tt.want {
+				t.Errorf("%s: got %s, want %s", tt.name, w.out.String(), tt.want)
+			}
+		}
+	}
+}
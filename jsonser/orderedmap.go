@@ -0,0 +1,78 @@
+package jsonser
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// OrderedMap is JSON object keeping keys in the order they are stored
+type OrderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// NewOrderedMap returns empty map with room for size keys
+func NewOrderedMap(size int) *OrderedMap {
+	return &OrderedMap{
+		keys:   make([]string, 0, size),
+		values: make(map[string]interface{}, size),
+	}
+}
+
+// Keys returns keys in order. The slice must not be modified.
+func (m *OrderedMap) Keys() []string {
+	return m.keys
+}
+
+// Get returns value of the key
+func (m *OrderedMap) Get(key string) (interface{}, bool) {
+	v, ok := m.values[key]
+	return v, ok
+}
+
+// Set sets value of the key. New keys are added to the end, existing keys
+// keep their position.
+func (m *OrderedMap) Set(key string, value interface{}) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+// Len returns number of keys
+func (m *OrderedMap) Len() int {
+	return len(m.keys)
+}
+
+// MarshalJSON implements json.Marshaler, keys are written in order
+func (m *OrderedMap) MarshalJSON() ([]byte, error) {
+	var (
+		buf    bytes.Buffer
+		enc    stringEncoder
+		quoted []byte
+		err    error
+	)
+	// Strings are written as they are, same as jiffy does
+	values := json.NewEncoder(&buf)
+	values.SetEscapeHTML(false)
+	buf.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		quoted, err = enc.appendString(quoted[:0], []byte(key))
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(quoted)
+		buf.WriteByte(':')
+		err = values.Encode(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		// Encoder ends each value with newline
+		buf.Truncate(buf.Len() - 1)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
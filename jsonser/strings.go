@@ -0,0 +1,172 @@
+package jsonser
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// ErrInvalidUTF8 is returned for strings with invalid UTF-8 when
+// UTF8Fail policy is used
+var ErrInvalidUTF8 = errors.New("Invalid UTF-8 in string")
+
+// UTF8Policy decides how invalid UTF-8 in keys and strings is written
+type UTF8Policy int
+
+const (
+	// UTF8Replace writes each invalid byte as U+FFFD replacement character
+	UTF8Replace UTF8Policy = iota
+	// UTF8Escape writes each invalid byte as \u00XX escape, as if it was
+	// Latin-1 character
+	UTF8Escape
+	// UTF8Fail fails the document with ErrInvalidUTF8
+	UTF8Fail
+)
+
+// utf8PolicyNames are names used by ParseUTF8Policy and String
+var utf8PolicyNames = map[UTF8Policy]string{
+	UTF8Replace: "replace",
+	UTF8Escape:  "escape",
+	UTF8Fail:    "fail",
+}
+
+// String implements Stringer
+func (p UTF8Policy) String() string {
+	if name, ok := utf8PolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("UTF8Policy(%d)", int(p))
+}
+
+// ParseUTF8Policy returns policy of name replace, escape or fail
+func ParseUTF8Policy(name string) (UTF8Policy, error) {
+	for p, n := range utf8PolicyNames {
+		if n == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown invalid UTF-8 policy %q, expecting replace, escape or fail", name)
+}
+
+// AppendValidUTF8 appends b to dst, handling invalid UTF-8 bytes by
+// policy. UTF8Replace uses U+FFFD and UTF8Escape the Latin-1 character of
+// the byte.
+func AppendValidUTF8(dst []byte, b []byte, policy UTF8Policy) ([]byte, error) {
+	start := 0
+	for i := 0; i < len(b); {
+		r, size := utf8.DecodeRune(b[i:])
+		if r != utf8.RuneError || size != 1 {
+			i += size
+			continue
+		}
+		dst = append(dst, b[start:i]...)
+		switch policy {
+		case UTF8Replace:
+			dst = utf8.AppendRune(dst, utf8.RuneError)
+		case UTF8Escape:
+			dst = utf8.AppendRune(dst, rune(b[i]))
+		default:
+			return dst, fmt.Errorf("%w: byte %#x at position %d", ErrInvalidUTF8, b[i], i)
+		}
+		i++
+		start = i
+	}
+	return append(dst, b[start:]...), nil
+}
+
+// hexDigits are lowercase, same as jiffy uses
+const hexDigits = "0123456789abcdef"
+
+// stringEncoder writes JSON strings the same way CouchDB does with jiffy:
+// quote, backslash and control characters are escaped, \b \f \n \r \t
+// with short forms, everything else is copied as UTF-8. In ASCII mode
+// non-ASCII characters are written as \uXXXX escapes, characters outside
+// Basic Multilingual Plane as surrogate pairs.
+type stringEncoder struct {
+	policy UTF8Policy
+	ascii  bool
+}
+
+// appendString appends b as quoted JSON string
+func (enc *stringEncoder) appendString(dst []byte, b []byte) ([]byte, error) {
+	dst = append(dst, '"')
+	dst, err := enc.appendEscaped(dst, b)
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, '"'), nil
+}
+
+// appendEscaped appends b escaped for use inside JSON string. Invalid
+// UTF-8 bytes are handled according to policy.
+func (enc *stringEncoder) appendEscaped(dst []byte, b []byte) ([]byte, error) {
+	start := 0
+	for i := 0; i < len(b); {
+		c := b[i]
+		if c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' {
+				i++
+				continue
+			}
+			dst = append(dst, b[start:i]...)
+			switch c {
+			case '"', '\\':
+				dst = append(dst, '\\', c)
+			case '\b':
+				dst = append(dst, '\\', 'b')
+			case '\f':
+				dst = append(dst, '\\', 'f')
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			case '\r':
+				dst = append(dst, '\\', 'r')
+			case '\t':
+				dst = append(dst, '\\', 't')
+			default:
+				dst = appendUEscape(dst, rune(c))
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRune(b[i:])
+		if r == utf8.RuneError && size == 1 {
+			dst = append(dst, b[start:i]...)
+			switch enc.policy {
+			case UTF8Replace:
+				if enc.ascii {
+					dst = appendUEscape(dst, utf8.RuneError)
+				} else {
+					dst = utf8.AppendRune(dst, utf8.RuneError)
+				}
+			case UTF8Escape:
+				dst = appendUEscape(dst, rune(c))
+			default:
+				return dst, fmt.Errorf("%w: byte %#x at position %d", ErrInvalidUTF8, c, i)
+			}
+			i++
+			start = i
+			continue
+		}
+		if enc.ascii {
+			dst = append(dst, b[start:i]...)
+			dst = appendUEscape(dst, r)
+			start = i + size
+		}
+		i += size
+	}
+	return append(dst, b[start:]...), nil
+}
+
+// appendUEscape appends r as \uXXXX escape, or surrogate pair of escapes
+// for characters outside Basic Multilingual Plane
+func appendUEscape(dst []byte, r rune) []byte {
+	if r >= 0x10000 {
+		r1, r2 := utf16.EncodeRune(r)
+		dst = appendUEscape(dst, r1)
+		return appendUEscape(dst, r2)
+	}
+	return append(dst, '\\', 'u',
+		hexDigits[r>>12&0xf], hexDigits[r>>8&0xf], hexDigits[r>>4&0xf], hexDigits[r&0xf])
+}
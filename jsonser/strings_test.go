@@ -0,0 +1,167 @@
+package jsonser
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestStringEncoder(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		// ascii is expected output in ASCII mode, same as want when empty
+		ascii string
+	}{
+		{"plain", "hello", `"hello"`, ""},
+		{"empty", "", `""`, ""},
+		{"quote and backslash", `say "a\b"`, `"say \"a\\b\""`, ""},
+		{"short escapes", "\b\f\n\r\t", `"\b\f\n\r\t"`, ""},
+		{"control characters", "\x00\x01\x1f", `"\u0000\u0001\u001f"`, ""},
+		{"delete is not escaped", "\x7f", "\"\x7f\"", ""},
+		{"slash is not escaped", "a/b", `"a/b"`, ""},
+		{"latin", "\u00e9", "\"\u00e9\"", `"\u00e9"`},
+		{"lowercase hex", "\u00ff\u0abc", "\"\u00ff\u0abc\"", `"\u00ff\u0abc"`},
+		{"line and paragraph separators", "a\u2028b\u2029c", "\"a\u2028b\u2029c\"", `"a\u2028b\u2029c"`},
+		{"outside BMP", "\U0001f600", "\"\U0001f600\"", `"\ud83d\ude00"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, ascii := range []bool{false, true} {
+				enc := stringEncoder{ascii: ascii}
+				got, err := enc.appendString(nil, []byte(tt.input))
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := tt.want
+				if ascii && tt.ascii != "" {
+					want = tt.ascii
+				}
+				if string(got) != want {
+					t.Errorf("ascii %v: got %s, want %s", ascii, got, want)
+				}
+				var decoded string
+				if err = json.Unmarshal(got, &decoded); err != nil || decoded != tt.input {
+					t.Errorf("ascii %v: %s decodes as %q, %v", ascii, got, decoded, err)
+				}
+			}
+		})
+	}
+}
+
+func TestStringEncoderInvalidUTF8(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		// replace is expected output of UTF8Replace, in ASCII mode the
+		// replacement characters are escaped
+		replace string
+		escape  string
+	}{
+		{"truncated two bytes", "a\xc3", "\"a\ufffd\"", `"a\u00c3"`},
+		{"truncated three bytes", "\xe2\x82z", "\"\ufffd\ufffdz\"", `"\u00e2\u0082z"`},
+		{"truncated four bytes", "\xf0\x9f\x98", "\"\ufffd\ufffd\ufffd\"", `"\u00f0\u009f\u0098"`},
+		{"overlong slash", "\xc0\xaf", "\"\ufffd\ufffd\"", `"\u00c0\u00af"`},
+		{"overlong three bytes", "\xe0\x80\xaf", "\"\ufffd\ufffd\ufffd\"", `"\u00e0\u0080\u00af"`},
+		{"surrogate", "\xed\xa0\x80", "\"\ufffd\ufffd\ufffd\"", `"\u00ed\u00a0\u0080"`},
+		{"lone continuation", "x\x80y", "\"x\ufffdy\"", `"x\u0080y"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enc := stringEncoder{policy: UTF8Replace}
+			got, err := enc.appendString(nil, []byte(tt.input))
+			if err != nil || string(got) != tt.replace {
+				t.Errorf("replace: got %s, %v, want %s", got, err, tt.replace)
+			}
+			enc = stringEncoder{policy: UTF8Replace, ascii: true}
+			got, err = enc.appendString(nil, []byte(tt.input))
+			wantASCII := strings.ReplaceAll(tt.replace, "\ufffd", `\ufffd`)
+			if err != nil || string(got) != wantASCII {
+				t.Errorf("replace ascii: got %s, %v, want %s", got, err, wantASCII)
+			}
+			enc = stringEncoder{policy: UTF8Escape}
+			got, err = enc.appendString(nil, []byte(tt.input))
+			if err != nil || string(got) != tt.escape {
+				t.Errorf("escape: got %s, %v, want %s", got, err, tt.escape)
+			}
+			enc = stringEncoder{policy: UTF8Fail}
+			_, err = enc.appendString(nil, []byte(tt.input))
+			if !errors.Is(err, ErrInvalidUTF8) {
+				t.Errorf("fail: got error %v, want ErrInvalidUTF8", err)
+			}
+		})
+	}
+}
+
+func TestWriteJSONEscapesKeys(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    string
+		policy UTF8Policy
+		want   string
+	}{
+		{"quote", `a"b`, UTF8Replace, `{"a\"b":"v"}`},
+		{"backslash", `a\b`, UTF8Replace, `{"a\\b":"v"}`},
+		{"newline", "a\nb", UTF8Replace, `{"a\nb":"v"}`},
+		{"control character", "a\x02", UTF8Replace, `{"a\u0002":"v"}`},
+		{"invalid UTF-8 replaced", "a\xff", UTF8Replace, "{\"a\ufffd\":\"v\"}"},
+		{"invalid UTF-8 escaped", "a\xff", UTF8Escape, `{"a\u00ff":"v"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := etfObject(etfBinary(tt.key), etfBinary("v"))
+			got, err := renderJSON(t, input, func(js *JSONSer) { js.SetUTF8Policy(tt.policy) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+			if !json.Valid([]byte(got)) {
+				t.Errorf("%s is not valid JSON", got)
+			}
+		})
+	}
+	input := etfObject(etfBinary("a\xff"), etfBinary("v"))
+	_, err := renderJSON(t, input, func(js *JSONSer) { js.SetUTF8Policy(UTF8Fail) })
+	if !errors.Is(err, ErrInvalidUTF8) {
+		t.Errorf("got error %v, want ErrInvalidUTF8 for invalid key", err)
+	}
+}
+
+func TestAppendValidUTF8(t *testing.T) {
+	got, err := AppendValidUTF8(nil, []byte("a\xe2\x82b"), UTF8Replace)
+	if err != nil || string(got) != "a\ufffd\ufffdb" {
+		t.Errorf("replace: got %q, %v", got, err)
+	}
+	got, err = AppendValidUTF8(nil, []byte("a\xe2\x82b"), UTF8Escape)
+	if err != nil || string(got) != "a\u00e2\u0082b" {
+		t.Errorf("escape: got %q, %v", got, err)
+	}
+	_, err = AppendValidUTF8(nil, []byte("a\xe2\x82b"), UTF8Fail)
+	if !errors.Is(err, ErrInvalidUTF8) {
+		t.Errorf("fail: got error %v, want ErrInvalidUTF8", err)
+	}
+}
+
+func TestWriteJSONLongStringSplitsCharacters(t *testing.T) {
+	// Characters straddle the boundaries of parts long strings are
+	// escaped in
+	value := strings.Repeat("a", longBinarySize-1) + "\u00e9\"" + strings.Repeat("\u20ac", longBinarySize) + "\xff"
+	for _, ascii := range []bool{false, true} {
+		got, err := renderJSON(t, etfList(etfBinary(value)), func(js *JSONSer) { js.SetASCII(ascii) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded []string
+		if err = json.Unmarshal([]byte(got), &decoded); err != nil {
+			t.Fatal(err)
+		}
+		want := strings.ToValidUTF8(value, "\ufffd")
+		if len(decoded) != 1 || decoded[0] != want {
+			t.Errorf("ascii %v: long string of %d bytes changed", ascii, len(value))
+		}
+	}
+}
@@ -0,0 +1,717 @@
+package jsonser
+
+import (
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/pipedrive/uncouch/erldeser"
+)
+
+// UnmarshalTypeError describes value which can not be stored into Go
+// value of the type
+type UnmarshalTypeError struct {
+	// Value is JSON type of the value: object, array, number, string,
+	// bool or null
+	Value string
+	Type  reflect.Type
+	// Field is dotted path of the struct field, if any
+	Field string
+}
+
+// Error implements error
+func (e *UnmarshalTypeError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("Can not unmarshal %s into field %s of type %v", e.Value, e.Field, e.Type)
+	}
+	return fmt.Sprintf("Can not unmarshal %s into Go value of type %v", e.Value, e.Type)
+}
+
+// Unmarshal reads single value from the scanner into v, which has to be
+// non-nil pointer. Values are stored following encoding/json rules,
+// including json struct tags with the string option, json.Unmarshaler and
+// encoding.TextUnmarshaler implementations and integer map keys. Numbers
+// in interface{} targets are json.Number, as with json.Decoder UseNumber.
+// OrderedMap targets keep the order of keys, including nested objects.
+func Unmarshal(s *erldeser.Scanner, v interface{}) error {
+	return NewDecoder(s).DecodeInto(v)
+}
+
+// DecodeInto reads single value from the scanner into v, see Unmarshal.
+// Values are stored while they are read, without building decoded tree
+// first, except for interface{}, OrderedMap, json.RawMessage and
+// json.Unmarshaler targets which get their whole value.
+func (d *Decoder) DecodeInto(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("Unmarshal needs non-nil pointer, got %T", v)
+	}
+	return d.decodeValue(rv.Elem(), "")
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	numberType          = reflect.TypeOf(json.Number(""))
+	rawMessageType      = reflect.TypeOf(json.RawMessage(nil))
+	orderedMapType      = reflect.TypeOf(OrderedMap{})
+)
+
+// decodeValue reads next value into dst. Field is path used for errors.
+func (d *Decoder) decodeValue(dst reflect.Value, field string) error {
+	err := d.s.Scan(&d.t)
+	if err != nil {
+		return err
+	}
+	return d.store(dst, field)
+}
+
+// store decodes value of the term read last into dst
+func (d *Decoder) store(dst reflect.Value, field string) error {
+	if d.isNull() {
+		setNull(dst)
+		return nil
+	}
+	for dst.Kind() == reflect.Pointer {
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		dst = dst.Elem()
+	}
+	if dst.Type() == rawMessageType {
+		raw, err := d.marshalValue()
+		if err != nil {
+			return err
+		}
+		dst.SetBytes(raw)
+		return nil
+	}
+	if dst.CanAddr() && dst.CanInterface() {
+		ptr := dst.Addr()
+		if ptr.Type().Implements(jsonUnmarshalerType) {
+			raw, err := d.marshalValue()
+			if err != nil {
+				return err
+			}
+			return ptr.Interface().(json.Unmarshaler).UnmarshalJSON(raw)
+		}
+		if ptr.Type().Implements(textUnmarshalerType) {
+			if d.t.Term != erldeser.BinaryExt {
+				return typeError(d.jsonType(), dst, field)
+			}
+			s, err := d.decodeString(d.t.Binary)
+			if err != nil {
+				return err
+			}
+			return ptr.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+		}
+	}
+	if dst.Kind() == reflect.Interface && dst.NumMethod() == 0 {
+		value, err := d.value()
+		if err != nil {
+			return err
+		}
+		dst.Set(reflect.ValueOf(value))
+		return nil
+	}
+	if dst.Type() == orderedMapType {
+		if d.t.Term != erldeser.SmallTupleExt {
+			return typeError(d.jsonType(), dst, field)
+		}
+		value, err := d.orderedValue()
+		if err != nil {
+			return err
+		}
+		dst.Set(reflect.ValueOf(*value.(*OrderedMap)))
+		return nil
+	}
+	switch d.t.Term {
+	case erldeser.ListExt, erldeser.NilExt, erldeser.StringExt:
+		return d.storeArray(dst, field)
+	case erldeser.SmallTupleExt:
+		return d.storeObject(dst, field)
+	}
+	value, err := d.value()
+	if err != nil {
+		return err
+	}
+	return assignScalar(dst, value, field)
+}
+
+// isNull tells if the term read last is null
+func (d *Decoder) isNull() bool {
+	return d.t.Term == erldeser.AtomExt && string(d.t.Binary) == "null"
+}
+
+// setNull stores null: it sets pointers, maps, slices and interfaces to
+// nil, others are left as they are
+func setNull(dst reflect.Value) {
+	switch dst.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Interface:
+		dst.Set(reflect.Zero(dst.Type()))
+	}
+}
+
+// jsonType returns JSON type name of the term read last
+func (d *Decoder) jsonType() string {
+	switch d.t.Term {
+	case erldeser.NewFloatExt, erldeser.SmallIntegerExt, erldeser.IntegerExt, erldeser.SmallBigExt, erldeser.LargeBigExt:
+		return "number"
+	case erldeser.AtomExt:
+		if d.isNull() {
+			return "null"
+		}
+		return "bool"
+	case erldeser.BinaryExt:
+		return "string"
+	case erldeser.ListExt, erldeser.NilExt, erldeser.StringExt:
+		return "array"
+	}
+	return "object"
+}
+
+// orderedValue decodes value of the term read last with objects as
+// *OrderedMap
+func (d *Decoder) orderedValue() (interface{}, error) {
+	ordered := d.ordered
+	d.ordered = true
+	value, err := d.value()
+	d.ordered = ordered
+	return value, err
+}
+
+// marshalValue returns JSON of the term read last, keeping key order
+func (d *Decoder) marshalValue() ([]byte, error) {
+	value, err := d.orderedValue()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+// assignScalar stores bool, string, json.Number or nil value into dst
+func assignScalar(dst reflect.Value, value interface{}, field string) error {
+	if value == nil {
+		setNull(dst)
+		return nil
+	}
+	for dst.Kind() == reflect.Pointer {
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		dst = dst.Elem()
+	}
+	switch v := value.(type) {
+	case bool:
+		if dst.Kind() != reflect.Bool {
+			return typeError("bool", dst, field)
+		}
+		dst.SetBool(v)
+	case string:
+		if dst.Kind() == reflect.Slice && dst.Type().Elem().Kind() == reflect.Uint8 {
+			// []byte is base64 encoded string
+			b, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				return err
+			}
+			dst.SetBytes(b)
+			return nil
+		}
+		if dst.Kind() != reflect.String {
+			return typeError("string", dst, field)
+		}
+		dst.SetString(v)
+	case json.Number:
+		return assignNumber(dst, v, field)
+	default:
+		return fmt.Errorf("Unexpected decoded value of type %T", value)
+	}
+	return nil
+}
+
+// assignNumber stores number into numeric, string or json.Number dst
+func assignNumber(dst reflect.Value, n json.Number, field string) error {
+	if dst.Type() == numberType {
+		dst.SetString(string(n))
+		return nil
+	}
+	switch dst.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(string(n), 10, 64)
+		if err != nil || dst.OverflowInt(i) {
+			return typeError("number "+string(n), dst, field)
+		}
+		dst.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, err := strconv.ParseUint(string(n), 10, 64)
+		if err != nil || dst.OverflowUint(u) {
+			return typeError("number "+string(n), dst, field)
+		}
+		dst.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(string(n), dst.Type().Bits())
+		if err != nil {
+			return typeError("number "+string(n), dst, field)
+		}
+		dst.SetFloat(f)
+	default:
+		return typeError("number", dst, field)
+	}
+	return nil
+}
+
+// storeArray decodes list, empty list or string of small integers read
+// last into slice or array dst
+func (d *Decoder) storeArray(dst reflect.Value, field string) error {
+	if dst.Kind() != reflect.Slice && dst.Kind() != reflect.Array {
+		return typeError("array", dst, field)
+	}
+	var (
+		length int
+		// small holds elements of string of small integers
+		small []byte
+	)
+	list := d.t.Term == erldeser.ListExt
+	switch d.t.Term {
+	case erldeser.ListExt:
+		length = int(d.t.IntegerValue)
+	case erldeser.StringExt:
+		small = append([]byte(nil), d.t.Binary...)
+		length = len(small)
+	}
+	// element decodes i-th element into elem, skipping it when elem is
+	// not valid
+	element := func(i int, elem reflect.Value) error {
+		if !list {
+			if !elem.IsValid() {
+				return nil
+			}
+			return assignNumber(elem, json.Number(strconv.Itoa(int(small[i]))), field+"["+strconv.Itoa(i)+"]")
+		}
+		if !elem.IsValid() {
+			return d.skipValue()
+		}
+		return d.decodeValue(elem, field+"["+strconv.Itoa(i)+"]")
+	}
+	if dst.Kind() == reflect.Slice {
+		slice := reflect.MakeSlice(dst.Type(), 0, presize(length))
+		for i := 0; i < length; i++ {
+			slice = reflect.Append(slice, reflect.Zero(dst.Type().Elem()))
+			err := element(i, slice.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+		dst.Set(slice)
+	} else {
+		for i := 0; i < length; i++ {
+			var elem reflect.Value
+			if i < dst.Len() {
+				elem = dst.Index(i)
+			}
+			err := element(i, elem)
+			if err != nil {
+				return err
+			}
+		}
+		for i := length; i < dst.Len(); i++ {
+			dst.Index(i).Set(reflect.Zero(dst.Type().Elem()))
+		}
+	}
+	if list {
+		return d.listTail()
+	}
+	return nil
+}
+
+// storeObject decodes {[{Key, Value}]} object whose tuple header was read
+// last into map or struct dst
+func (d *Decoder) storeObject(dst reflect.Value, field string) error {
+	var fields *structFields
+	switch dst.Kind() {
+	case reflect.Map:
+		keyType := dst.Type().Key()
+		switch keyType.Kind() {
+		case reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		default:
+			if !reflect.PointerTo(keyType).Implements(textUnmarshalerType) {
+				return typeError("object", dst, field)
+			}
+		}
+	case reflect.Struct:
+		fields = cachedFields(dst.Type())
+	default:
+		return typeError("object", dst, field)
+	}
+	err := d.s.Scan(&d.t)
+	if err != nil {
+		return err
+	}
+	length := 0
+	switch d.t.Term {
+	case erldeser.ListExt:
+		length = int(d.t.IntegerValue)
+	case erldeser.NilExt:
+	default:
+		return fmt.Errorf("%w: Erlang serialised JSON object should start as tuple containing list, we got %v", ErrMalformedBody, erldeser.TypeName(d.t.Term))
+	}
+	if dst.Kind() == reflect.Map && dst.IsNil() {
+		dst.Set(reflect.MakeMapWithSize(dst.Type(), presize(length)))
+	}
+	for i := 0; i < length; i++ {
+		key, err := d.objectKey()
+		if err != nil {
+			return err
+		}
+		if fields == nil {
+			err = d.storeMapValue(dst, key, joinField(field, key))
+		} else {
+			err = d.storeFieldValue(dst, fields, key, field)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if length > 0 {
+		return d.listTail()
+	}
+	return nil
+}
+
+// objectKey reads {Key, ...} header of key-value pair and returns the key
+func (d *Decoder) objectKey() (string, error) {
+	err := d.s.Scan(&d.t)
+	if err != nil {
+		return "", err
+	}
+	if d.t.Term != erldeser.SmallTupleExt {
+		return "", fmt.Errorf("%w: Erlang serialised JSON key-value pair should be inside tuple, we got %v", ErrMalformedBody, erldeser.TypeName(d.t.Term))
+	}
+	err = d.s.Scan(&d.t)
+	if err != nil {
+		return "", err
+	}
+	if d.t.Term != erldeser.BinaryExt {
+		return "", fmt.Errorf("%w: Erlang serialised JSON key should be binary, we got %v", ErrMalformedBody, erldeser.TypeName(d.t.Term))
+	}
+	return d.decodeString(d.t.Binary)
+}
+
+// storeMapValue decodes next value into map dst under key
+func (d *Decoder) storeMapValue(dst reflect.Value, key string, field string) error {
+	elem := reflect.New(dst.Type().Elem()).Elem()
+	err := d.decodeValue(elem, field)
+	if err != nil {
+		return err
+	}
+	kv, err := mapKey(dst.Type().Key(), key, field)
+	if err != nil {
+		return err
+	}
+	dst.SetMapIndex(kv, elem)
+	return nil
+}
+
+// storeFieldValue decodes next value into struct field of key
+func (d *Decoder) storeFieldValue(dst reflect.Value, fields *structFields, key string, field string) error {
+	f, ok := fields.lookup(key)
+	if !ok {
+		// Unknown keys are ignored, same as encoding/json does
+		return d.skipValue()
+	}
+	fv, err := fieldByIndex(dst, f.index)
+	if err != nil {
+		return err
+	}
+	if f.quoted {
+		return d.decodeQuoted(fv, joinField(field, f.name))
+	}
+	return d.decodeValue(fv, joinField(field, f.name))
+}
+
+// skipValue reads next value without storing it
+func (d *Decoder) skipValue() error {
+	err := d.s.Scan(&d.t)
+	if err != nil {
+		return err
+	}
+	n := 0
+	switch d.t.Term {
+	case erldeser.SmallTupleExt:
+		n = int(d.t.IntegerValue)
+	case erldeser.ListExt:
+		// Elements and the tail
+		n = int(d.t.IntegerValue) + 1
+	}
+	for i := 0; i < n; i++ {
+		err = d.skipValue()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mapKey converts object key into key of map with keys of keyType, the
+// same way encoding/json does
+func mapKey(keyType reflect.Type, key string, field string) (reflect.Value, error) {
+	if reflect.PointerTo(keyType).Implements(textUnmarshalerType) {
+		kv := reflect.New(keyType)
+		err := kv.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(key))
+		return kv.Elem(), err
+	}
+	kv := reflect.New(keyType).Elem()
+	switch keyType.Kind() {
+	case reflect.String:
+		kv.SetString(key)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(key, 10, 64)
+		if err != nil || kv.OverflowInt(i) {
+			return kv, typeError("number "+key, kv, field)
+		}
+		kv.SetInt(i)
+	default:
+		u, err := strconv.ParseUint(key, 10, 64)
+		if err != nil || kv.OverflowUint(u) {
+			return kv, typeError("number "+key, kv, field)
+		}
+		kv.SetUint(u)
+	}
+	return kv, nil
+}
+
+// decodeQuoted reads next value of field with the string option, which
+// holds JSON literal inside string
+func (d *Decoder) decodeQuoted(dst reflect.Value, field string) error {
+	err := d.s.Scan(&d.t)
+	if err != nil {
+		return err
+	}
+	if d.isNull() {
+		return d.store(dst, field)
+	}
+	if d.t.Term != erldeser.BinaryExt {
+		return fmt.Errorf("Invalid use of ,string struct tag, trying to unmarshal unquoted value into %v", dst.Type())
+	}
+	s, err := d.decodeString(d.t.Binary)
+	if err != nil {
+		return err
+	}
+	literal, ok := unquoteLiteral(s)
+	if !ok {
+		return fmt.Errorf("Invalid use of ,string struct tag, trying to unmarshal %q into %v", s, dst.Type())
+	}
+	return assignScalar(dst, literal, field)
+}
+
+// unquoteLiteral decodes string, number, true, false or null in s
+func unquoteLiteral(s string) (interface{}, bool) {
+	if s == "" || strings.TrimSpace(s) != s {
+		return nil, false
+	}
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var literal interface{}
+	if dec.Decode(&literal) != nil || dec.InputOffset() != int64(len(s)) {
+		return nil, false
+	}
+	switch literal.(type) {
+	case []interface{}, map[string]interface{}:
+		return nil, false
+	}
+	return literal, true
+}
+
+// typeError returns UnmarshalTypeError for value which does not fit dst
+func typeError(value string, dst reflect.Value, field string) error {
+	return &UnmarshalTypeError{Value: value, Type: dst.Type(), Field: field}
+}
+
+// joinField returns dotted path of the field
+func joinField(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+// fieldByIndex returns nested field, allocating nil embedded pointers
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, errors.New("Can not set embedded pointer to unexported struct")
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, nil
+}
+
+// structField is exported struct field JSON keys are stored into
+type structField struct {
+	name  string
+	index []int
+	// quoted is set by the string tag option
+	quoted bool
+}
+
+// structFields are fields of single struct type
+type structFields struct {
+	byName map[string]int
+	list   []structField
+}
+
+// lookup finds field by key, exact name first, then case-insensitively
+// like encoding/json does
+func (sf *structFields) lookup(key string) (structField, bool) {
+	if i, ok := sf.byName[key]; ok {
+		return sf.list[i], true
+	}
+	for _, f := range sf.list {
+		if strings.EqualFold(f.name, key) {
+			return f, true
+		}
+	}
+	return structField{}, false
+}
+
+// fieldCache holds structFields by reflect.Type
+var fieldCache sync.Map
+
+// cachedFields returns fields of struct type t
+func cachedFields(t reflect.Type) *structFields {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.(*structFields)
+	}
+	f, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return f.(*structFields)
+}
+
+// typeFields collects fields of struct type t, following json tags and
+// promoting fields of embedded structs. Fields of shallower depth win,
+// conflicting fields of the same depth are dropped. Of the tag options
+// only string matters for decoding, omitempty is ignored like
+// encoding/json does.
+func typeFields(t reflect.Type) *structFields {
+	type candidate struct {
+		structField
+		tagged bool
+	}
+	var (
+		candidates []candidate
+		visit      func(t reflect.Type, index []int)
+		visited    = map[reflect.Type]bool{}
+	)
+	visit = func(t reflect.Type, index []int) {
+		if visited[t] {
+			return
+		}
+		visited[t] = true
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			tag := sf.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, options, _ := strings.Cut(tag, ",")
+			if !validTagName(name) {
+				name = ""
+			}
+			fieldIndex := append(append([]int(nil), index...), i)
+			ft := sf.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+				visit(ft, fieldIndex)
+				continue
+			}
+			if !sf.IsExported() {
+				continue
+			}
+			tagged := name != ""
+			if !tagged {
+				name = sf.Name
+			}
+			quoted := false
+			if hasTagOption(options, "string") {
+				switch ft.Kind() {
+				case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+					reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+					reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+					quoted = true
+				}
+			}
+			candidates = append(candidates, candidate{structField{name, fieldIndex, quoted}, tagged})
+		}
+	}
+	visit(t, nil)
+
+	sf := &structFields{byName: map[string]int{}}
+	byName := map[string][]candidate{}
+	var order []string
+	for _, c := range candidates {
+		if _, ok := byName[c.name]; !ok {
+			order = append(order, c.name)
+		}
+		byName[c.name] = append(byName[c.name], c)
+	}
+	for _, name := range order {
+		cs := byName[name]
+		best := cs[0]
+		conflict := false
+		for _, c := range cs[1:] {
+			switch {
+			case len(c.index) < len(best.index), len(c.index) == len(best.index) && c.tagged && !best.tagged:
+				best, conflict = c, false
+			case len(c.index) == len(best.index) && c.tagged == best.tagged:
+				conflict = true
+			}
+		}
+		if conflict {
+			continue
+		}
+		sf.byName[name] = len(sf.list)
+		sf.list = append(sf.list, best.structField)
+	}
+	return sf
+}
+
+// hasTagOption reports whether comma separated options contain option
+func hasTagOption(options, option string) bool {
+	for options != "" {
+		var o string
+		o, options, _ = strings.Cut(options, ",")
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// validTagName reports whether name can be used as key, encoding/json
+// uses field name for tags with other characters
+func validTagName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !strings.ContainsRune("!#$%&()*+-./:;<=>?@[]^_{|}~ ", c) && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			return false
+		}
+	}
+	return true
+}
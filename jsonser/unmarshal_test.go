@@ -0,0 +1,239 @@
+package jsonser
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pipedrive/uncouch/erldeser"
+)
+
+// etfInteger returns the smallest integer term holding n
+func etfInteger(n *big.Int) []byte {
+	switch {
+	case n.IsInt64() && n.Int64() >= 0 && n.Int64() < 256:
+		return []byte{byte(erldeser.SmallIntegerExt), byte(n.Int64())}
+	case n.IsInt64() && n.Int64() >= -1<<31 && n.Int64() < 1<<31:
+		return binary.BigEndian.AppendUint32([]byte{byte(erldeser.IntegerExt)}, uint32(n.Int64()))
+	}
+	return etfBig(n)
+}
+
+// etfFromJSON returns Erlang serialised JSON of the JSON text, the way
+// CouchDB stores document bodies
+func etfFromJSON(t *testing.T, text string) []byte {
+	t.Helper()
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	var read func() []byte
+	read = func() []byte {
+		token, err := dec.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch v := token.(type) {
+		case json.Delim:
+			var elements [][]byte
+			for dec.More() {
+				if v == '{' {
+					key, err := dec.Token()
+					if err != nil {
+						t.Fatal(err)
+					}
+					elements = append(elements, etfBinary(key.(string)))
+				}
+				elements = append(elements, read())
+			}
+			if _, err = dec.Token(); err != nil {
+				t.Fatal(err)
+			}
+			if v == '{' {
+				return etfObject(elements...)
+			}
+			return etfList(elements...)
+		case string:
+			return etfBinary(v)
+		case json.Number:
+			if n, ok := new(big.Int).SetString(string(v), 10); ok {
+				return etfInteger(n)
+			}
+			f, err := v.Float64()
+			if err != nil {
+				t.Fatal(err)
+			}
+			return etfFloat(f)
+		case bool:
+			if v {
+				return etfAtom("true")
+			}
+			return etfAtom("false")
+		}
+		return etfAtom("null")
+	}
+	return read()
+}
+
+// upperKey is TextUnmarshaler storing text in upper case
+type upperKey string
+
+func (k *upperKey) UnmarshalText(text []byte) error {
+	*k = upperKey(strings.ToUpper(string(text)))
+	return nil
+}
+
+type unmarshalAddress struct {
+	Street string `json:"street,omitempty"`
+	Zip    int    `json:"zip,string"`
+	Code   *int   `json:"code,string,omitempty"`
+}
+
+type unmarshalEmbedded struct {
+	Source string `json:"source"`
+	Level  int
+}
+
+type unmarshalDocument struct {
+	unmarshalEmbedded
+	ID      string                 `json:"_id"`
+	Name    string                 `json:",omitempty"`
+	Active  bool                   `json:"active,string"`
+	Score   float64                `json:"score,string"`
+	Label   string                 `json:"label,string"`
+	Count   uint16                 `json:"count"`
+	Address unmarshalAddress       `json:"address"`
+	ByID    map[int]string         `json:"by_id"`
+	Sizes   map[uint8]int          `json:"sizes"`
+	Tags    map[upperKey]bool      `json:"tags"`
+	Kind    upperKey               `json:"kind"`
+	Raw     []byte                 `json:"raw"`
+	Numbers []float64              `json:"numbers"`
+	Extra   map[string]interface{} `json:"extra"`
+	Any     interface{}            `json:"any"`
+	Pair    [2]int                 `json:"pair"`
+	Matrix  [][]int                `json:"matrix"`
+	Ptr     **string               `json:"ptr"`
+	Skipped string                 `json:"-"`
+	Dash    string                 `json:"-,"`
+}
+
+// TestUnmarshalMatchesEncodingJSON checks Unmarshal stores values the
+// same way json.Decoder with UseNumber does
+func TestUnmarshalMatchesEncodingJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+	}{
+		{"full", `{
+			"_id": "doc1", "source": "import", "Level": 3, "name": "Ann",
+			"active": "true", "score": "1.5", "label": "\"quoted\"", "count": 65535,
+			"address": {"street": "Main", "zip": "12345", "code": "7"},
+			"by_id": {"-1": "minus", "42": "answer"}, "sizes": {"255": 1},
+			"tags": {"red": true, "blue": false}, "kind": "small", "raw": "aGVsbG8=",
+			"numbers": [1, 2.5, -3e-7], "extra": {"nested": {"a": [true, null, "x"]}},
+			"-": "dash", "Skipped": "kept", "unknown": 1
+		}`},
+		{"case insensitive keys", `{"_ID": "doc1", "NAME": "Ann", "ADDRESS": {"ZIP": "1"}}`},
+		{"quoted null", `{"active": "null", "address": {"zip": "null", "code": "null"}}`},
+		{"literal null", `{"active": null, "address": {"zip": null, "code": null}, "tags": null}`},
+		{"unquoted string option", `{"address": {"zip": 12345}}`},
+		{"string option not number", `{"address": {"zip": "12a"}}`},
+		{"string option with space", `{"address": {"zip": " 12"}}`},
+		{"string option unquoted string", `{"label": "plain"}`},
+		{"string option bool", `{"active": "yes"}`},
+		{"string option object", `{"score": "[1]"}`},
+		{"int key not number", `{"by_id": {"one": "x"}}`},
+		{"int key overflow", `{"sizes": {"256": 1}}`},
+		{"uint overflow", `{"count": 65536}`},
+		{"negative uint", `{"count": -1}`},
+		{"invalid base64", `{"raw": "not base64!"}`},
+		{"text unmarshaler number", `{"kind": 5}`},
+		{"array for object", `{"address": [1]}`},
+		{"float for int", `{"by_id": {"1": 1.5}}`},
+		{"unknown nested value skipped", `{"unknown": {"a": [1, {"b": [2, []]}], "c": {}}, "_id": "doc1"}`},
+		{"interface values", `{"any": {"n": 1.5, "big": 123456789012345678901234567890, "l": [1, "s", null, {}, []]}}`},
+		{"short fixed array", `{"pair": [1], "name": "Ann"}`},
+		{"long fixed array", `{"pair": [1, 2, 3], "name": "Ann"}`},
+		{"nested arrays", `{"matrix": [[1, 2], [], [3]]}`},
+		{"pointer to pointer", `{"ptr": "x"}`},
+		{"null pointer to pointer", `{"ptr": null}`},
+		{"object for array", `{"pair": {"a": 1}}`},
+		{"array element of wrong type", `{"matrix": [[1, "2"]]}`},
+		{"text unmarshaler object", `{"kind": {}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var want, got unmarshalDocument
+			dec := json.NewDecoder(strings.NewReader(tt.json))
+			dec.UseNumber()
+			wantErr := dec.Decode(&want)
+			s, err := erldeser.NewScanner(etfFromJSON(t, tt.json))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = Unmarshal(s, &got)
+			if (err != nil) != (wantErr != nil) {
+				t.Fatalf("got error %v, encoding/json returned %v", err, wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, want) {
+				t.Errorf("got\n%+v\nencoding/json decoded\n%+v", got, want)
+			}
+		})
+	}
+}
+
+// decodeETF decodes Erlang serialised JSON in input into v
+func decodeETF(t *testing.T, input []byte, v interface{}) error {
+	t.Helper()
+	s, err := erldeser.NewScanner(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Unmarshal(s, v)
+}
+
+func TestUnmarshalSmallIntegerString(t *testing.T) {
+	// Lists of small integers are stored as STRING_EXT
+	input := binary.BigEndian.AppendUint16([]byte{byte(erldeser.StringExt)}, 3)
+	input = append(input, 1, 2, 255)
+	var ints []int
+	if err := decodeETF(t, input, &ints); err != nil || !reflect.DeepEqual(ints, []int{1, 2, 255}) {
+		t.Errorf("got %v, %v", ints, err)
+	}
+	var pair [2]uint8
+	if err := decodeETF(t, input, &pair); err != nil || pair != [2]uint8{1, 2} {
+		t.Errorf("got %v, %v", pair, err)
+	}
+	var value interface{}
+	want := []interface{}{json.Number("1"), json.Number("2"), json.Number("255")}
+	if err := decodeETF(t, input, &value); err != nil || !reflect.DeepEqual(value, want) {
+		t.Errorf("got %v, %v", value, err)
+	}
+	var s string
+	if err := decodeETF(t, input, &s); err == nil {
+		t.Error("array was stored into string")
+	}
+}
+
+func TestUnmarshalKeepsKeyOrder(t *testing.T) {
+	input := etfFromJSON(t, `{"raw": {"z": 1, "a": [true, {"y": null, "b": 2}]}, "om": {"z": {"y": 1, "x": 2}, "a": 3}}`)
+	var doc struct {
+		Raw json.RawMessage `json:"raw"`
+		OM  *OrderedMap     `json:"om"`
+	}
+	if err := decodeETF(t, input, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"z":1,"a":[true,{"y":null,"b":2}]}`; string(doc.Raw) != want {
+		t.Errorf("got raw %s, want %s", doc.Raw, want)
+	}
+	om, err := json.Marshal(doc.OM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"z":{"y":1,"x":2},"a":3}`; string(om) != want {
+		t.Errorf("got ordered map %s, want %s", om, want)
+	}
+}
@@ -1,55 +1,153 @@
 // Package leakybucket implements leaky bucket recycling for byte slices
-// to reduce burden on GC
+// to reduce burden on GC.
+//
+// Byte slices are kept in power of two size classes, so a request is
+// served from the smallest class big enough for it. Total memory held by
+// the free lists is limited by memory ceiling, slices returned above the
+// ceiling are left for GC. All operations are safe for concurrent use.
 package leakybucket
 
 import (
 	"bytes"
+	"math/bits"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
+const (
+	// minClassShift is the smallest size class, 4 KiB
+	minClassShift = 12
+	// maxClassShift is the biggest size class, 64 MiB. Bigger slices
+	// are allocated directly and never pooled.
+	maxClassShift = 26
+	classCount    = maxClassShift - minClassShift + 1
+	// classSlack is extra capacity of new slices, so slices returned
+	// with a header cut off still fit into their original class
+	classSlack = 64
+
+	// DefaultMemoryCeiling is default limit for memory held in free lists
+	DefaultMemoryCeiling = 256 * 1024 * 1024
+
+	// bufferPresize is initial capacity of new bytes.Buffer objects
+	bufferPresize = 64 * 1024
+	// maxFreeObjects limits number of pooled Buffer and Builder objects
+	maxFreeObjects = 50
+)
+
+// sizeClass is free list for byte slices of single size class
+type sizeClass struct {
+	mu   sync.Mutex
+	free []*[]byte
+}
+
 var (
-	freeByteList   = make(chan *[]byte, 50)
-	freeBufferList = make(chan *bytes.Buffer, 50)
-	freeStrBuilderList   = make(chan *strings.Builder, 50)
+	classes [classCount]sizeClass
+
+	freeBufferList     = make(chan *bytes.Buffer, maxFreeObjects)
+	freeStrBuilderList = make(chan *strings.Builder, maxFreeObjects)
+
+	memoryCeiling atomic.Int64
+	pooledBytes   atomic.Int64
+
+	counters struct {
+		getHits, getMisses, oversized atomic.Int64
+		putPooled, putDropped         atomic.Int64
+		bufferHits, bufferMisses      atomic.Int64
+		builderHits, builderMisses    atomic.Int64
+		classHits, classMisses        [classCount]atomic.Int64
+	}
 )
 
+func init() {
+	memoryCeiling.Store(DefaultMemoryCeiling)
+}
+
+// SetMemoryCeiling sets limit for memory held by free lists in bytes.
+// Zero or negative value disables pooling of byte slices.
+func SetMemoryCeiling(ceiling int64) {
+	memoryCeiling.Store(ceiling)
+}
+
+// reserve accounts size bytes to pooled memory if it stays under ceiling
+func reserve(size int64) bool {
+	if pooledBytes.Add(size) > memoryCeiling.Load() {
+		pooledBytes.Add(-size)
+		return false
+	}
+	return true
+}
+
+// classFor returns index of the smallest class which fits size
+func classFor(size int) int {
+	if size <= 1<<minClassShift {
+		return 0
+	}
+	return bits.Len(uint(size-1)) - minClassShift
+}
+
 // GetBytes returns byte slice with cap at least the size provided
 // and len == size.
 // GetBytes panics if it can not provide byte slice
 func GetBytes(size int32) (b *[]byte) {
-	select {
-	case b = <-freeByteList:
-		if int32(cap(*b)) < size {
-			t := make([]byte, size)
-			b = &t
-		} else {
-			t := (*b)[:size]
-			b = &t
-		}
-	default:
-		// None free, so allocate a new one.
+	class := classFor(int(size))
+	if class >= classCount {
+		counters.oversized.Add(1)
 		t := make([]byte, size)
-		b = &t
+		return &t
+	}
+	sc := &classes[class]
+	sc.mu.Lock()
+	if n := len(sc.free); n > 0 {
+		b = sc.free[n-1]
+		sc.free[n-1] = nil
+		sc.free = sc.free[:n-1]
+	}
+	sc.mu.Unlock()
+	if b == nil {
+		// None free, so allocate a new one.
+		counters.getMisses.Add(1)
+		counters.classMisses[class].Add(1)
+		t := make([]byte, size, 1<<(class+minClassShift)+classSlack)
+		return &t
 	}
+	counters.getHits.Add(1)
+	counters.classHits[class].Add(1)
+	pooledBytes.Add(-int64(cap(*b)))
+	t := (*b)[:size]
+	*b = t
 	return b
 }
 
 // PutBytes adds byte array to reuse list
 func PutBytes(b *[]byte) {
-	select {
-	case freeByteList <- b:
-		// Buffer on free list; nothing more to do.
-	default:
-		// Free list full, just carry on.
+	if b == nil {
+		return
 	}
+	size := cap(*b)
+	// Largest class which is fully covered by the capacity
+	class := bits.Len(uint(size)) - 1 - minClassShift
+	if class < 0 || class >= classCount || !reserve(int64(size)) {
+		// Too small, too big or over ceiling, just carry on.
+		counters.putDropped.Add(1)
+		return
+	}
+	sc := &classes[class]
+	sc.mu.Lock()
+	sc.free = append(sc.free, b)
+	sc.mu.Unlock()
+	counters.putPooled.Add(1)
 }
 
 // GetBuffer returns bytes.Buffer object, trying to reuse if possible
 func GetBuffer() (b *bytes.Buffer) {
 	select {
 	case b = <-freeBufferList:
+		counters.bufferHits.Add(1)
+		pooledBytes.Add(-int64(b.Cap()))
 	default:
-		presizeBytes := make([]byte, 0, 1024*1024*5)
+		counters.bufferMisses.Add(1)
+		presizeBytes := make([]byte, 0, bufferPresize)
 		b = bytes.NewBuffer(presizeBytes)
 	}
 	return b
@@ -58,27 +156,37 @@ func GetBuffer() (b *bytes.Buffer) {
 // PutBuffer adds bytes.Buffer object to reuse list
 func PutBuffer(b *bytes.Buffer) {
 	b.Reset()
+	size := int64(b.Cap())
+	if size > 1<<maxClassShift || !reserve(size) {
+		counters.putDropped.Add(1)
+		return
+	}
 	select {
 	case freeBufferList <- b:
 		// Buffer on free list; nothing more to do.
 	default:
 		// Free list full, just carry on.
+		pooledBytes.Add(-size)
+		counters.putDropped.Add(1)
 	}
 	return
 }
 
+// GetStrBuilder returns strings.Builder object, trying to reuse if possible
 func GetStrBuilder() (b *strings.Builder) {
 	select {
 	case b = <-freeStrBuilderList:
+		counters.builderHits.Add(1)
 	default:
 		// None free, so allocate a new one.
+		counters.builderMisses.Add(1)
 		var s strings.Builder
 		b = &s
 	}
 	return b
 }
 
-// PutBytes adds byte array to reuse list
+// PutStrBuilder adds strings.Builder object to reuse list
 func PutStrBuilder(b *strings.Builder) {
 	b.Reset()
 	select {
@@ -87,4 +195,4 @@ func PutStrBuilder(b *strings.Builder) {
 	default:
 		// Free list full, just carry on.
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,194 @@
+package leakybucket
+
+import (
+	"sync"
+	"testing"
+)
+
+// resetPool empties free lists and sets memory ceiling for a test
+func resetPool(t *testing.T, ceiling int64) {
+	t.Helper()
+	for i := range classes {
+		sc := &classes[i]
+		sc.mu.Lock()
+		sc.free = nil
+		sc.mu.Unlock()
+	}
+	for len(freeBufferList) > 0 {
+		<-freeBufferList
+	}
+	pooledBytes.Store(0)
+	SetMemoryCeiling(ceiling)
+	t.Cleanup(func() { SetMemoryCeiling(DefaultMemoryCeiling) })
+}
+
+// freeCount returns number of slices on free list of class
+func freeCount(class int) int {
+	sc := &classes[class]
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return len(sc.free)
+}
+
+// heldBytes returns capacity of all slices and buffers on free lists
+func heldBytes() int64 {
+	var held int64
+	for i := range classes {
+		sc := &classes[i]
+		sc.mu.Lock()
+		for _, b := range sc.free {
+			held += int64(cap(*b))
+		}
+		sc.mu.Unlock()
+	}
+	return held
+}
+
+func TestClassFor(t *testing.T) {
+	tests := []struct {
+		size  int
+		class int
+	}{
+		{0, 0},
+		{1, 0},
+		{4096, 0},
+		{4097, 1},
+		{8192, 1},
+		{8193, 2},
+		{1 << 20, 8},
+		{1 << 26, classCount - 1},
+		{1<<26 + 1, classCount},
+	}
+	for _, tt := range tests {
+		if got := classFor(tt.size); got != tt.class {
+			t.Errorf("classFor(%d) = %d, want %d", tt.size, got, tt.class)
+		}
+	}
+}
+
+func TestPutBytesClass(t *testing.T) {
+	tests := []struct {
+		name string
+		cap  int
+		// class is -1 when the slice is dropped
+		class int
+	}{
+		{"below smallest class", 4095, -1},
+		{"exact class size", 4096, 0},
+		{"new slice with slack", 4096 + classSlack, 0},
+		{"slice with header cut off", 4096 + classSlack - 16, 0},
+		{"almost next class", 8191, 0},
+		{"next class", 8192, 1},
+		{"new slice of biggest class", 1<<maxClassShift + classSlack, classCount - 1},
+		{"above biggest class", 1 << (maxClassShift + 1), -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetPool(t, 1<<(maxClassShift+2))
+			b := make([]byte, 0, tt.cap)
+			PutBytes(&b)
+			for class := 0; class < classCount; class++ {
+				want := 0
+				if class == tt.class {
+					want = 1
+				}
+				if got := freeCount(class); got != want {
+					t.Errorf("class %d has %d free slices, want %d", class, got, want)
+				}
+			}
+			if held := heldBytes(); pooledBytes.Load() != held {
+				t.Errorf("pooled bytes %d, free lists hold %d", pooledBytes.Load(), held)
+			}
+		})
+	}
+}
+
+func TestGetBytesReusesSliceWithHeaderCutOff(t *testing.T) {
+	resetPool(t, DefaultMemoryCeiling)
+	b := GetBytes(5000)
+	if len(*b) != 5000 || cap(*b) != 8192+classSlack {
+		t.Fatalf("got len %d and cap %d", len(*b), cap(*b))
+	}
+	*b = (*b)[16:]
+	PutBytes(b)
+	reused := GetBytes(8192)
+	if reused != b || len(*reused) != 8192 {
+		t.Errorf("got new slice of len %d, want the returned one", len(*reused))
+	}
+	if pooledBytes.Load() != 0 {
+		t.Errorf("got %d pooled bytes after reuse", pooledBytes.Load())
+	}
+}
+
+func TestPooledBytesCeiling(t *testing.T) {
+	size := int64(4096 + classSlack)
+	resetPool(t, 3*size)
+	var slices []*[]byte
+	for i := 0; i < 4; i++ {
+		slices = append(slices, GetBytes(4096))
+	}
+	for _, b := range slices {
+		PutBytes(b)
+	}
+	if freeCount(0) != 3 || pooledBytes.Load() != 3*size {
+		t.Errorf("got %d free slices and %d pooled bytes, want 3 and %d", freeCount(0), pooledBytes.Load(), 3*size)
+	}
+	GetBytes(100)
+	if pooledBytes.Load() != 2*size {
+		t.Errorf("got %d pooled bytes, want %d", pooledBytes.Load(), 2*size)
+	}
+	// Buffer does not fit under the ceiling any more
+	PutBuffer(GetBuffer())
+	if len(freeBufferList) != 0 || pooledBytes.Load() != 2*size {
+		t.Errorf("got %d free buffers and %d pooled bytes", len(freeBufferList), pooledBytes.Load())
+	}
+}
+
+func TestZeroMemoryCeilingDisablesPooling(t *testing.T) {
+	resetPool(t, 0)
+	misses := counters.getMisses.Load()
+	b := GetBytes(4096)
+	PutBytes(b)
+	PutBuffer(GetBuffer())
+	if freeCount(0) != 0 || len(freeBufferList) != 0 || pooledBytes.Load() != 0 {
+		t.Errorf("got %d free slices, %d free buffers and %d pooled bytes", freeCount(0), len(freeBufferList), pooledBytes.Load())
+	}
+	if GetBytes(4096) == b || counters.getMisses.Load() != misses+2 {
+		t.Error("slice was reused with pooling off")
+	}
+}
+
+func TestConcurrentGetPut(t *testing.T) {
+	ceiling := int64(1 << 20)
+	resetPool(t, ceiling)
+	sizes := []int32{1, 4096, 5000, 70000, 1 << 18}
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				b := GetBytes(sizes[(g+i)%len(sizes)])
+				for j := range *b {
+					(*b)[j] = byte(g)
+				}
+				if i%3 == 0 {
+					*b = (*b)[1:]
+				}
+				PutBytes(b)
+				buf := GetBuffer()
+				buf.WriteByte(byte(g))
+				PutBuffer(buf)
+			}
+		}(g)
+	}
+	wg.Wait()
+	var bufferBytes int64
+	for len(freeBufferList) > 0 {
+		bufferBytes += int64((<-freeBufferList).Cap())
+	}
+	pooled := pooledBytes.Load()
+	if held := heldBytes() + bufferBytes; pooled != held || pooled > ceiling {
+		t.Errorf("pooled bytes %d, free lists hold %d, ceiling %d", pooled, held, ceiling)
+	}
+}
@@ -0,0 +1,85 @@
+package leakybucket
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ClassStats holds counters of single byte slice size class
+type ClassStats struct {
+	Size   int64
+	Free   int
+	Hits   int64
+	Misses int64
+}
+
+// Stats is snapshot of pool counters
+type Stats struct {
+	// MemoryCeiling is limit for PooledBytes
+	MemoryCeiling int64
+	// PooledBytes is memory currently held by free lists
+	PooledBytes int64
+	// GetHits and GetMisses count GetBytes calls served from and
+	// not served from free lists
+	GetHits   int64
+	GetMisses int64
+	// Oversized counts GetBytes calls bigger than the biggest size class
+	Oversized int64
+	// PutPooled and PutDropped count returned objects kept for reuse
+	// and left for GC
+	PutPooled  int64
+	PutDropped int64
+
+	BufferHits    int64
+	BufferMisses  int64
+	BuilderHits   int64
+	BuilderMisses int64
+
+	Classes []ClassStats
+}
+
+// GetStats returns snapshot of pool counters
+func GetStats() Stats {
+	s := Stats{
+		MemoryCeiling: memoryCeiling.Load(),
+		PooledBytes:   pooledBytes.Load(),
+		GetHits:       counters.getHits.Load(),
+		GetMisses:     counters.getMisses.Load(),
+		Oversized:     counters.oversized.Load(),
+		PutPooled:     counters.putPooled.Load(),
+		PutDropped:    counters.putDropped.Load(),
+		BufferHits:    counters.bufferHits.Load(),
+		BufferMisses:  counters.bufferMisses.Load(),
+		BuilderHits:   counters.builderHits.Load(),
+		BuilderMisses: counters.builderMisses.Load(),
+	}
+	for i := range classes {
+		sc := &classes[i]
+		sc.mu.Lock()
+		free := len(sc.free)
+		sc.mu.Unlock()
+		cs := ClassStats{
+			Size:   1 << (i + minClassShift),
+			Free:   free,
+			Hits:   counters.classHits[i].Load(),
+			Misses: counters.classMisses[i].Load(),
+		}
+		if cs.Free > 0 || cs.Hits > 0 || cs.Misses > 0 {
+			s.Classes = append(s.Classes, cs)
+		}
+	}
+	return s
+}
+
+// String implements Stringer for printing the stats
+func (s Stats) String() string {
+	var output strings.Builder
+	output.WriteString(fmt.Sprintf("bytes: hits %d, misses %d, oversized %d, pooled %d, dropped %d, held %d of %d bytes\n",
+		s.GetHits, s.GetMisses, s.Oversized, s.PutPooled, s.PutDropped, s.PooledBytes, s.MemoryCeiling))
+	for _, c := range s.Classes {
+		output.WriteString(fmt.Sprintf("  class %d: hits %d, misses %d, free %d\n", c.Size, c.Hits, c.Misses, c.Free))
+	}
+	output.WriteString(fmt.Sprintf("buffers: hits %d, misses %d\n", s.BufferHits, s.BufferMisses))
+	output.WriteString(fmt.Sprintf("builders: hits %d, misses %d\n", s.BuilderHits, s.BuilderMisses))
+	return output.String()
+}
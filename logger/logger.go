@@ -1,11 +1,18 @@
-// Package logger provides singleton logger for all the packages in the
-// application
+// Package logger provides Logger interface used by uncouch packages and
+// singleton zap logger used by the command line application.
+//
+// Library packages log through the proxy returned by Get, which discards
+// everything until an application installs its own logger with Set.
 package logger
 
 import (
+	"fmt"
+	"os"
 	"sync"
+	"sync/atomic"
 
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 // Package internal variable to implement singleton
@@ -14,18 +21,101 @@ var (
 	innerSugar  *zap.SugaredLogger
 
 	onceLogger sync.Once
+
+	// currentCore holds zapcore.Core all the loggers write into
+	currentCore atomic.Value
 )
 
 // GetLogger returns singleton logger object.
 func GetLogger() (*zap.Logger, *zap.SugaredLogger) {
-	var err error
-
 	onceLogger.Do(func() {
-		innerLogger, err = zap.NewDevelopment()
+		core, err := newCore("debug", "console")
 		if err != nil {
 			panic("Unbale to create logger. Quit application.")
 		}
+		currentCore.Store(coreHolder{core})
+		innerLogger = zap.New(&dynamicCore{}, zap.AddCaller())
 		innerSugar = innerLogger.Sugar()
 	})
 	return innerLogger, innerSugar
 }
+
+// Configure changes level and format of the singleton logger. Level is
+// one of debug, info, warn or error and format is console or json.
+// Logs are always written to stderr.
+func Configure(level, format string) error {
+	GetLogger()
+	core, err := newCore(level, format)
+	if err != nil {
+		return err
+	}
+	currentCore.Store(coreHolder{core})
+	return nil
+}
+
+// newCore builds zap core writing to stderr
+func newCore(level, format string) (zapcore.Core, error) {
+	var zapLevel zapcore.Level
+	err := zapLevel.UnmarshalText([]byte(level))
+	if err != nil {
+		return nil, fmt.Errorf("Unknown log level %q", level)
+	}
+	var encoder zapcore.Encoder
+	switch format {
+	case "console":
+		encoder = zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+	case "json":
+		encoder = zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	default:
+		return nil, fmt.Errorf("Unknown log format %q, expecting console or json", format)
+	}
+	return zapcore.NewCore(encoder, zapcore.Lock(os.Stderr), zapLevel), nil
+}
+
+// coreHolder wraps core so atomic.Value always stores the same type
+type coreHolder struct {
+	core zapcore.Core
+}
+
+// dynamicCore forwards to the core set up by Configure, so loggers
+// handed out before configuration follow the configuration as well
+type dynamicCore struct {
+	fields []zapcore.Field
+}
+
+// current returns configured core with fields added by With
+func (c *dynamicCore) current() zapcore.Core {
+	core := currentCore.Load().(coreHolder).core
+	if len(c.fields) > 0 {
+		core = core.With(c.fields)
+	}
+	return core
+}
+
+// Enabled implements zapcore.Core
+func (c *dynamicCore) Enabled(level zapcore.Level) bool {
+	return c.current().Enabled(level)
+}
+
+// With implements zapcore.Core
+func (c *dynamicCore) With(fields []zapcore.Field) zapcore.Core {
+	combined := make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	combined = append(combined, c.fields...)
+	combined = append(combined, fields...)
+	return &dynamicCore{fields: combined}
+}
+
+// Check implements zapcore.Core
+func (c *dynamicCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return c.current().Check(entry, checked)
+}
+
+// Write implements zapcore.Core
+func (c *dynamicCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	return c.current().Write(entry, fields)
+}
+
+// Sync implements zapcore.Core
+func (c *dynamicCore) Sync() error {
+	return c.current().Sync()
+}
@@ -0,0 +1,93 @@
+package logger
+
+import (
+	"sync/atomic"
+)
+
+// Logger is the logging interface used by uncouch packages.
+// *zap.SugaredLogger satisfies it.
+type Logger interface {
+	Debug(args ...interface{})
+	Debugf(template string, args ...interface{})
+	Info(args ...interface{})
+	Infof(template string, args ...interface{})
+	Warn(args ...interface{})
+	Warnf(template string, args ...interface{})
+	Error(args ...interface{})
+	Errorf(template string, args ...interface{})
+}
+
+// loggerHolder wraps Logger so atomic.Value always stores the same type
+type loggerHolder struct {
+	l Logger
+}
+
+// currentLogger holds Logger the proxy forwards to
+var currentLogger atomic.Value
+
+func init() {
+	currentLogger.Store(loggerHolder{Nop()})
+}
+
+// Set replaces logger used by all uncouch packages. Nil discards logs.
+func Set(l Logger) {
+	if l == nil {
+		l = Nop()
+	}
+	currentLogger.Store(loggerHolder{l})
+}
+
+// Get returns logger forwarding to the one set by Set, so packages can
+// keep it in a variable and still follow later changes. Logs are discarded
+// until Set is called.
+func Get() Logger {
+	return proxy{}
+}
+
+// proxy forwards to the current logger
+type proxy struct{}
+
+func (proxy) current() Logger {
+	return currentLogger.Load().(loggerHolder).l
+}
+
+// Debug implements Logger
+func (p proxy) Debug(args ...interface{}) { p.current().Debug(args...) }
+
+// Debugf implements Logger
+func (p proxy) Debugf(template string, args ...interface{}) { p.current().Debugf(template, args...) }
+
+// Info implements Logger
+func (p proxy) Info(args ...interface{}) { p.current().Info(args...) }
+
+// Infof implements Logger
+func (p proxy) Infof(template string, args ...interface{}) { p.current().Infof(template, args...) }
+
+// Warn implements Logger
+func (p proxy) Warn(args ...interface{}) { p.current().Warn(args...) }
+
+// Warnf implements Logger
+func (p proxy) Warnf(template string, args ...interface{}) { p.current().Warnf(template, args...) }
+
+// Error implements Logger
+func (p proxy) Error(args ...interface{}) { p.current().Error(args...) }
+
+// Errorf implements Logger
+func (p proxy) Errorf(template string, args ...interface{}) { p.current().Errorf(template, args...) }
+
+// Nop returns logger discarding everything
+func Nop() Logger {
+	return nop{}
+}
+
+// nop discards all logs
+type nop struct{}
+
+func (nop) Debug(args ...interface{})                   {}
+func (nop) Debugf(template string, args ...interface{}) {}
+func (nop) Info(args ...interface{})                    {}
+func (nop) Infof(template string, args ...interface{})  {}
+func (nop) Warn(args ...interface{})                    {}
+func (nop) Warnf(template string, args ...interface{})  {}
+func (nop) Error(args ...interface{})                   {}
+func (nop) Errorf(template string, args ...interface{}) {}
@@ -0,0 +1,88 @@
+package uncouch
+
+import (
+	"github.com/pipedrive/uncouch/jsonser"
+)
+
+// Option configures DB opened by Open or OpenReader
+type Option func(*options)
+
+// options holds settings changed by Option
+type options struct {
+	name       string
+	deleted    bool
+	poolMemory *int64
+	utf8Policy jsonser.UTF8Policy
+	ascii      bool
+	floats     jsonser.FloatFormat
+}
+
+// FloatFormat decides how floats in document bodies are written
+type FloatFormat = jsonser.FloatFormat
+
+// Float formats
+const (
+	// FloatJSON writes floats as encoding/json does. It is the default.
+	FloatJSON = jsonser.FloatJSON
+	// FloatCouchDB writes floats exactly as CouchDB serves them
+	FloatCouchDB = jsonser.FloatCouchDB
+)
+
+// InvalidUTF8Policy decides how invalid UTF-8 in document keys and
+// strings is written
+type InvalidUTF8Policy = jsonser.UTF8Policy
+
+// Invalid UTF-8 policies
+const (
+	// InvalidUTF8Replace writes invalid bytes as U+FFFD replacement
+	// character. It is the default.
+	InvalidUTF8Replace = jsonser.UTF8Replace
+	// InvalidUTF8Escape writes invalid bytes as \u00XX escapes
+	InvalidUTF8Escape = jsonser.UTF8Escape
+	// InvalidUTF8Fail fails reading the document with ErrInvalidUTF8
+	InvalidUTF8Fail = jsonser.UTF8Fail
+)
+
+// WithName sets database name reported by Info
+func WithName(name string) Option {
+	return func(o *options) {
+		o.name = name
+	}
+}
+
+// WithDeleted makes Get and Documents return deleted documents as well
+func WithDeleted() Option {
+	return func(o *options) {
+		o.deleted = true
+	}
+}
+
+// WithPoolMemory limits memory kept in buffer pools for reuse. Pools are
+// shared by all open files, so the last limit set applies.
+func WithPoolMemory(bytes int64) Option {
+	return func(o *options) {
+		o.poolMemory = &bytes
+	}
+}
+
+// WithInvalidUTF8 sets how invalid UTF-8 in documents is written
+func WithInvalidUTF8(policy InvalidUTF8Policy) Option {
+	return func(o *options) {
+		o.utf8Policy = policy
+	}
+}
+
+// WithASCII makes document bodies ASCII only, non-ASCII characters are
+// written as \uXXXX escapes
+func WithASCII() Option {
+	return func(o *options) {
+		o.ascii = true
+	}
+}
+
+// WithFloatFormat sets how floats in document bodies are written
+func WithFloatFormat(format FloatFormat) Option {
+	return func(o *options) {
+		o.floats = format
+	}
+}
@@ -0,0 +1,326 @@
+package uncouch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/pipedrive/uncouch/couchdbfile"
+	"github.com/pipedrive/uncouch/jsonser"
+	"github.com/pipedrive/uncouch/leakybucket"
+)
+
+// Field is top level field set into document body by SetField. Use
+// StringField, IntField or BoolField to make one.
+type Field struct {
+	Key string
+	// Value is JSON encoded value
+	Value []byte
+}
+
+// StringField returns field with string value
+func StringField(key, value string) Field {
+	return Field(jsonser.StringField(key, value))
+}
+
+// IntField returns field with integer value
+func IntField(key string, value int64) Field {
+	return Field(jsonser.IntField(key, value))
+}
+
+// BoolField returns field with boolean value
+func BoolField(key string, value bool) Field {
+	return Field(jsonser.BoolField(key, value))
+}
+
+// serFields returns fields as jsonser fields
+func serFields(fields []Field) []jsonser.Field {
+	if len(fields) == 0 {
+		return nil
+	}
+	sf := make([]jsonser.Field, len(fields))
+	for i, f := range fields {
+		sf[i] = jsonser.Field(f)
+	}
+	return sf
+}
+
+// BodySource writes body of document made by NewDocument
+type BodySource interface {
+	// WriteBody writes body as JSON object to output, with fields placed
+	// before the fields stored in the body
+	WriteBody(output io.Writer, fields ...Field) error
+}
+
+// fileBody is BodySource streaming body of di from the file
+type fileBody struct {
+	cf *couchdbfile.CouchDbFile
+	di *couchdbfile.DocumentInfo
+}
+
+// WriteBody implements BodySource
+func (b *fileBody) WriteBody(output io.Writer, fields ...Field) error {
+	return b.cf.StreamDocument(b.di, output, serFields(fields)...)
+}
+
+// DocumentSink receives documents at the end of Pipeline
+type DocumentSink interface {
+	Write(doc *Document) error
+	Close() error
+}
+
+// Transformer changes documents on their way to DocumentSink. It returns
+// the document to pass on, which may be doc itself, and whether to keep
+// it at all.
+type Transformer interface {
+	Transform(doc *Document) (*Document, bool, error)
+}
+
+// TransformerFunc is function used as Transformer
+type TransformerFunc func(doc *Document) (*Document, bool, error)
+
+// Transform implements Transformer
+func (f TransformerFunc) Transform(doc *Document) (*Document, bool, error) {
+	return f(doc)
+}
+
+// NewDocument returns document with ID, Rev, Seq and Deleted of meta,
+// whose body is written by source once it is needed. It lets documents
+// read by other means pass through Pipeline.
+func NewDocument(meta Document, source BodySource) *Document {
+	return &Document{
+		ID:      meta.ID,
+		Rev:     meta.Rev,
+		Seq:     meta.Seq,
+		Deleted: meta.Deleted,
+		source:  source,
+	}
+}
+
+// NewFileDocument returns document of di whose body is streamed from cf
+// once it is needed. Failures reading the body are *DocumentError with
+// the offset of the body.
+func NewFileDocument(cf *couchdbfile.CouchDbFile, di *couchdbfile.DocumentInfo) *Document {
+	doc := newDocument(di)
+	doc.source = &fileBody{cf: cf, di: di}
+	return doc
+}
+
+// SetField sets top level field of the body, placing it before the
+// fields stored in the document. Fields are kept aside until the body is
+// read or written, so setting them does not read the body.
+func (doc *Document) SetField(f Field) {
+	for i := range doc.fields {
+		if doc.fields[i].Key == f.Key {
+			doc.fields[i] = f
+			return
+		}
+	}
+	doc.fields = append(doc.fields, f)
+}
+
+// ReadBody returns Body, reading it from the file first if the document
+// has none yet. Fields set by SetField are applied to it.
+func (doc *Document) ReadBody() (json.RawMessage, error) {
+	if doc.Body == nil && doc.source != nil {
+		output := leakybucket.GetBuffer()
+		defer leakybucket.PutBuffer(output)
+		err := doc.source.WriteBody(output, doc.fields...)
+		if err != nil {
+			return nil, doc.documentError(err)
+		}
+		doc.Body = append(json.RawMessage(nil), output.Bytes()...)
+		doc.fields = nil
+		return doc.Body, nil
+	}
+	if len(doc.fields) > 0 {
+		body, err := jsonser.AppendWithFields(nil, doc.Body, serFields(doc.fields))
+		if err != nil {
+			return nil, doc.documentError(err)
+		}
+		doc.Body = body
+		doc.fields = nil
+	}
+	return doc.Body, nil
+}
+
+// WriteBody writes body with fields set by SetField to output. Body not
+// read yet is streamed from the file a block at a time. Failing document
+// may leave part of the body written to output, write into a buffer
+// first when that matters.
+func (doc *Document) WriteBody(output io.Writer) error {
+	if doc.Body == nil && doc.source != nil {
+		err := doc.source.WriteBody(output, doc.fields...)
+		if err != nil {
+			return doc.documentError(err)
+		}
+		return nil
+	}
+	body, err := doc.ReadBody()
+	if err != nil {
+		return err
+	}
+	_, err = output.Write(body)
+	return err
+}
+
+// documentError returns err as *DocumentError of the document
+func (doc *Document) documentError(err error) error {
+	err = apiError(err)
+	var docErr *DocumentError
+	if errors.As(err, &docErr) {
+		return err
+	}
+	if fb, ok := doc.source.(*fileBody); ok {
+		return apiError(couchdbfile.NewDocumentError(fb.di, err))
+	}
+	return &DocumentError{ID: doc.ID, Offset: -1, Err: err}
+}
+
+// Pipeline passes documents through transformers into sink. It is
+// DocumentSink itself, so pipelines can be chained.
+type Pipeline struct {
+	sink         DocumentSink
+	transformers []Transformer
+	onError      func(doc *Document, err error) error
+}
+
+// NewPipeline returns pipeline applying transformers in order before
+// writing documents into sink
+func NewPipeline(sink DocumentSink, transformers ...Transformer) *Pipeline {
+	var (
+		newPipeline Pipeline
+	)
+	p := &newPipeline
+	p.sink = sink
+	p.transformers = transformers
+	return p
+}
+
+// Process passes document through transformers into sink and tells if it
+// was kept. Transformer errors are returned as *DocumentError, so they
+// can be skipped the same way as documents which can not be read.
+func (p *Pipeline) Process(doc *Document) (bool, error) {
+	for _, t := range p.transformers {
+		next, keep, err := t.Transform(doc)
+		if err != nil {
+			return false, doc.documentError(err)
+		}
+		if !keep {
+			return false, nil
+		}
+		doc = next
+	}
+	return true, apiError(p.sink.Write(doc))
+}
+
+// Write implements DocumentSink
+func (p *Pipeline) Write(doc *Document) error {
+	_, err := p.Process(doc)
+	return err
+}
+
+// Close implements DocumentSink, it closes the sink
+func (p *Pipeline) Close() error {
+	return p.sink.Close()
+}
+
+// OnError sets handler of documents failing in Run with *DocumentError,
+// which could not be read or transformed. Returning nil skips the
+// document and Run goes on, returning error stops Run with it. Without
+// handler Run stops at the first failing document. Skipping is safe with
+// sinks leaving no partial output of failing documents, like the one of
+// NewBufferedJSONLinesSink.
+func (p *Pipeline) OnError(fn func(doc *Document, err error) error) {
+	p.onError = fn
+}
+
+// Run passes all documents of the iterator through the pipeline and
+// closes it. It returns number of documents written into the sink.
+func (p *Pipeline) Run(it *Iterator) (int, error) {
+	written := 0
+	for it.Next() {
+		doc := NewFileDocument(it.db.cf, it.di)
+		kept, err := p.Process(doc)
+		if err != nil {
+			var docErr *DocumentError
+			if p.onError != nil && errors.As(err, &docErr) {
+				err = p.onError(doc, err)
+				if err == nil {
+					continue
+				}
+			}
+			p.Close()
+			return written, err
+		}
+		if kept {
+			written++
+		}
+	}
+	if err := it.Err(); err != nil {
+		p.Close()
+		return written, err
+	}
+	return written, p.Close()
+}
+
+// jsonLinesSink writes each document body as JSON object on its own line
+type jsonLinesSink struct {
+	w *bufio.Writer
+	// buf holds the document being rendered, it is nil when bodies are
+	// streamed into w
+	buf *bytes.Buffer
+}
+
+// NewJSONLinesSink returns sink writing document bodies as JSON lines.
+// Bodies are streamed into w, so memory use does not follow document
+// size, but failing document leaves partial line behind. Close flushes
+// the output, but does not close it.
+func NewJSONLinesSink(w io.Writer) DocumentSink {
+	var (
+		newSink jsonLinesSink
+	)
+	s := &newSink
+	s.w = bufio.NewWriter(w)
+	return s
+}
+
+// NewBufferedJSONLinesSink returns JSON lines sink rendering each body
+// into memory before it is written, so failing document leaves no partial
+// line and can be skipped by OnError handler. It costs holding the
+// biggest document in memory.
+func NewBufferedJSONLinesSink(w io.Writer) DocumentSink {
+	s := NewJSONLinesSink(w).(*jsonLinesSink)
+	s.buf = leakybucket.GetBuffer()
+	return s
+}
+
+// Write implements DocumentSink
+func (s *jsonLinesSink) Write(doc *Document) error {
+	if s.buf == nil {
+		err := doc.WriteBody(s.w)
+		if err != nil {
+			return err
+		}
+		return s.w.WriteByte('\n')
+	}
+	s.buf.Reset()
+	err := doc.WriteBody(s.buf)
+	if err != nil {
+		return err
+	}
+	s.buf.WriteString("\n")
+	_, err = s.buf.WriteTo(s.w)
+	return err
+}
+
+// Close implements DocumentSink
+func (s *jsonLinesSink) Close() error {
+	if s.buf != nil {
+		leakybucket.PutBuffer(s.buf)
+		s.buf = nil
+	}
+	return s.w.Flush()
+}
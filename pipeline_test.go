@@ -0,0 +1,124 @@
+package uncouch
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/pipedrive/uncouch/couchbytes"
+	"github.com/pipedrive/uncouch/couchdbfile"
+	"github.com/pipedrive/uncouch/jsonser"
+)
+
+// testBody is BodySource of stored body, failing with err after writing
+// part of it when err is set
+type testBody struct {
+	stored string
+	err    error
+}
+
+// WriteBody implements BodySource
+func (b testBody) WriteBody(output io.Writer, fields ...Field) error {
+	body, err := jsonser.AppendWithFields(nil, []byte(b.stored), serFields(fields))
+	if err != nil {
+		return err
+	}
+	if b.err != nil {
+		output.Write(body[:len(body)/2])
+		return b.err
+	}
+	_, err = output.Write(body)
+	return err
+}
+
+func TestPipelineNewDocument(t *testing.T) {
+	var out bytes.Buffer
+	p := NewPipeline(NewJSONLinesSink(&out), TransformerFunc(func(doc *Document) (*Document, bool, error) {
+		doc.SetField(StringField("_id", doc.ID))
+		doc.SetField(BoolField("deleted", doc.Deleted))
+		return doc, true, nil
+	}))
+	doc := NewDocument(Document{ID: "doc1", Rev: "1-abc", Deleted: true}, testBody{stored: `{"a":1}`})
+	kept, err := p.Process(doc)
+	if err != nil || !kept {
+		t.Fatalf("got %v, %v", kept, err)
+	}
+	if err = p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"_id":"doc1","deleted":true,"a":1}` + "\n"
+	if out.String() != want {
+		t.Errorf("got %s, want %s", out.String(), want)
+	}
+}
+
+func TestJSONLinesSinks(t *testing.T) {
+	failure := errors.New("Read failed")
+	tests := []struct {
+		name    string
+		newSink func(w io.Writer) DocumentSink
+		want    string
+	}{
+		{"buffered", NewBufferedJSONLinesSink, `{"a":1}` + "\n" + `{"c":3}` + "\n"},
+		// Streamed body of failing document is left behind
+		{"streaming", NewJSONLinesSink, `{"a":1}` + "\n" + `{"b":"` + `{"c":3}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			p := NewPipeline(tt.newSink(&out))
+			docs := []*Document{
+				NewDocument(Document{ID: "doc1"}, testBody{stored: `{"a":1}`}),
+				NewDocument(Document{ID: "doc2"}, testBody{stored: `{"b":"long"}`, err: failure}),
+				NewDocument(Document{ID: "doc3"}, testBody{stored: `{"c":3}`}),
+			}
+			for _, doc := range docs {
+				_, err := p.Process(doc)
+				if doc.ID != "doc2" {
+					if err != nil {
+						t.Fatal(err)
+					}
+					continue
+				}
+				var docErr *DocumentError
+				if !errors.As(err, &docErr) || docErr.ID != "doc2" || docErr.Offset != -1 || !errors.Is(err, failure) {
+					t.Fatalf("got error %v, want DocumentError of doc2", err)
+				}
+			}
+			if err := p.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("got %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	err := apiError(&couchdbfile.DocumentError{
+		ID:     "doc1",
+		Offset: 4096,
+		Err:    &couchbytes.CorruptBlockError{Offset: 4096, Reason: "block too short", Err: io.ErrUnexpectedEOF},
+	})
+	var docErr *DocumentError
+	if !errors.As(err, &docErr) || docErr.ID != "doc1" || docErr.Offset != 4096 {
+		t.Errorf("got %v, want DocumentError of doc1", err)
+	}
+	var cbe *CorruptBlockError
+	if !errors.As(err, &cbe) || cbe.Reason != "block too short" {
+		t.Errorf("got %v, want CorruptBlockError", err)
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got %v, want it to wrap unexpected EOF", err)
+	}
+	want := "Can not read document \"doc1\" at offset 4096: Corrupt block at offset 4096: block too short: unexpected EOF"
+	if err.Error() != want {
+		t.Errorf("got message %q, want %q", err.Error(), want)
+	}
+	var ute *UnmarshalTypeError
+	if !errors.As(apiError(&jsonser.UnmarshalTypeError{Value: "string", Field: "a"}), &ute) || ute.Field != "a" {
+		t.Error("UnmarshalTypeError was not converted")
+	}
+}
@@ -0,0 +1,318 @@
+package termite
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pipedrive/uncouch/erldeser"
+)
+
+// Erlang returns Termite formatted as Erlang term syntax
+func (t *Termite) Erlang() string {
+	var output strings.Builder
+	t.WriteErlang(&output)
+	return output.String()
+}
+
+// WriteErlang writes Termite as Erlang term syntax which can be pasted
+// into erl shell
+func (t *Termite) WriteErlang(w io.Writer) error {
+	var output strings.Builder
+	err := formatErlang(t, &output)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, output.String())
+	return err
+}
+
+// formatErlang is recursive helper for writing Erlang term syntax
+func formatErlang(t *Termite, output *strings.Builder) error {
+	switch t.T.Term {
+	case erldeser.NewFloatExt:
+		output.WriteString(erlangFloat(t.T.FloatValue))
+	case erldeser.SmallIntegerExt, erldeser.IntegerExt:
+		output.WriteString(strconv.FormatInt(t.T.IntegerValue, 10))
+	case erldeser.SmallBigExt, erldeser.LargeBigExt:
+		output.WriteString(t.T.BigValue.String())
+	case erldeser.AtomExt:
+		output.WriteString(erlangAtom(string(t.T.Binary)))
+	case erldeser.NilExt:
+		output.WriteString("[]")
+	case erldeser.StringExt:
+		if isPrintableASCII(t.T.Binary) {
+			output.WriteString(erlangQuote(string(t.T.Binary), '"'))
+		} else {
+			output.WriteString("[")
+			writeByteList(t.T.Binary, output)
+			output.WriteString("]")
+		}
+	case erldeser.BinaryExt:
+		output.WriteString("<<")
+		switch {
+		case isPrintableASCII(t.T.Binary):
+			output.WriteString(erlangQuote(string(t.T.Binary), '"'))
+		case utf8.Valid(t.T.Binary) && isPrintable(string(t.T.Binary)):
+			output.WriteString(erlangQuote(string(t.T.Binary), '"'))
+			output.WriteString("/utf8")
+		default:
+			writeByteList(t.T.Binary, output)
+		}
+		output.WriteString(">>")
+	case erldeser.SmallTupleExt:
+		output.WriteString("{")
+		for i, child := range t.Children {
+			if i > 0 {
+				output.WriteString(",")
+			}
+			err := formatErlang(child, output)
+			if err != nil {
+				return err
+			}
+		}
+		output.WriteString("}")
+	case erldeser.ListExt:
+		output.WriteString("[")
+		elements, tail := t.Children[:len(t.Children)-1], t.Children[len(t.Children)-1]
+		for i, child := range elements {
+			if i > 0 {
+				output.WriteString(",")
+			}
+			err := formatErlang(child, output)
+			if err != nil {
+				return err
+			}
+		}
+		if tail.T.Term != erldeser.NilExt {
+			// Improper list
+			output.WriteString("|")
+			err := formatErlang(tail, output)
+			if err != nil {
+				return err
+			}
+		}
+		output.WriteString("]")
+	default:
+		return fmt.Errorf("Can not format %v as Erlang term", erldeser.TypeName(t.T.Term))
+	}
+	return nil
+}
+
+// erlangFloat formats float so that Erlang parser accepts it, it needs
+// to have a fraction part even when exponent is present
+func erlangFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	mantissa, exponent := s, ""
+	if i := strings.IndexByte(s, 'e'); i >= 0 {
+		mantissa, exponent = s[:i], s[i:]
+	}
+	if !strings.Contains(mantissa, ".") {
+		mantissa += ".0"
+	}
+	return mantissa + strings.Replace(exponent, "+", "", 1)
+}
+
+// erlangAtom returns atom as is when it does not need quoting
+func erlangAtom(name string) string {
+	if name == "" || name[0] < 'a' || name[0] > 'z' || erlangReserved[name] {
+		return erlangQuote(name, '\'')
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '@') {
+			return erlangQuote(name, '\'')
+		}
+	}
+	return name
+}
+
+// erlangReserved lists words which can not be used as unquoted atoms
+var erlangReserved = map[string]bool{
+	"after": true, "and": true, "andalso": true, "band": true, "begin": true,
+	"bnot": true, "bor": true, "bsl": true, "bsr": true, "bxor": true,
+	"case": true, "catch": true, "cond": true, "div": true, "end": true,
+	"fun": true, "if": true, "let": true, "maybe": true, "not": true,
+	"of": true, "or": true, "orelse": true, "receive": true, "rem": true,
+	"try": true, "when": true, "xor": true,
+}
+
+// erlangQuote quotes string with Erlang escape sequences
+func erlangQuote(s string, quote byte) string {
+	var output strings.Builder
+	output.WriteByte(quote)
+	for _, r := range s {
+		switch r {
+		case '\\':
+			output.WriteString(`\\`)
+		case rune(quote):
+			output.WriteByte('\\')
+			output.WriteByte(quote)
+		case '\n':
+			output.WriteString(`\n`)
+		case '\r':
+			output.WriteString(`\r`)
+		case '\t':
+			output.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				output.WriteString(fmt.Sprintf(`\x{%X}`, r))
+			} else {
+				output.WriteRune(r)
+			}
+		}
+	}
+	output.WriteByte(quote)
+	return output.String()
+}
+
+// isPrintableASCII returns true when every byte is printable ASCII
+// or whitespace handled by erlangQuote
+func isPrintableASCII(b []byte) bool {
+	for _, c := range b {
+		if c >= 0x7f || (c < 0x20 && c != '\n' && c != '\r' && c != '\t') {
+			return false
+		}
+	}
+	return true
+}
+
+// isPrintable returns true when string has no control characters
+func isPrintable(s string) bool {
+	for _, r := range s {
+		if r < 0x20 && r != '\n' && r != '\r' && r != '\t' || r >= 0x7f && r < 0xa0 {
+			return false
+		}
+	}
+	return true
+}
+
+// writeByteList writes bytes as comma separated integers
+func writeByteList(b []byte, output *strings.Builder) {
+	for i, c := range b {
+		if i > 0 {
+			output.WriteString(",")
+		}
+		output.WriteString(strconv.Itoa(int(c)))
+	}
+}
+
+// TaggedJSON returns Termite formatted as lossless tagged JSON
+func (t *Termite) TaggedJSON() string {
+	var output strings.Builder
+	t.WriteTaggedJSON(&output)
+	return output.String()
+}
+
+// WriteTaggedJSON writes Termite as JSON where every term is an object
+// with its type, so the original term can be restored from it:
+//
+//	{"type":"atom","value":"kv_node"}
+//	{"type":"integer","value":42}
+//	{"type":"big","value":"123456789012345678901234567890"}
+//	{"type":"float","value":1.5}
+//	{"type":"binary","value":"text"} or {"type":"binary","base64":"AAE="}
+//	{"type":"string","value":"text"} or {"type":"string","base64":"AAE="}
+//	{"type":"tuple","elements":[...]}
+//	{"type":"list","elements":[...]} with "tail" if list is improper
+//	{"type":"nil"}
+func (t *Termite) WriteTaggedJSON(w io.Writer) error {
+	var output strings.Builder
+	err := formatTaggedJSON(t, &output)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, output.String())
+	return err
+}
+
+// formatTaggedJSON is recursive helper for writing tagged JSON
+func formatTaggedJSON(t *Termite, output *strings.Builder) error {
+	switch t.T.Term {
+	case erldeser.NewFloatExt:
+		output.WriteString(`{"type":"float","value":`)
+		output.WriteString(strconv.FormatFloat(t.T.FloatValue, 'g', -1, 64))
+		output.WriteString("}")
+	case erldeser.SmallIntegerExt, erldeser.IntegerExt:
+		output.WriteString(`{"type":"integer","value":`)
+		output.WriteString(strconv.FormatInt(t.T.IntegerValue, 10))
+		output.WriteString("}")
+	case erldeser.SmallBigExt, erldeser.LargeBigExt:
+		output.WriteString(`{"type":"big","value":"`)
+		output.WriteString(t.T.BigValue.String())
+		output.WriteString(`"}`)
+	case erldeser.AtomExt:
+		output.WriteString(`{"type":"atom","value":`)
+		writeJSONString(string(t.T.Binary), output)
+		output.WriteString("}")
+	case erldeser.NilExt:
+		output.WriteString(`{"type":"nil"}`)
+	case erldeser.StringExt:
+		output.WriteString(`{"type":"string",`)
+		writeTaggedBytes(t.T.Binary, output)
+		output.WriteString("}")
+	case erldeser.BinaryExt:
+		output.WriteString(`{"type":"binary",`)
+		writeTaggedBytes(t.T.Binary, output)
+		output.WriteString("}")
+	case erldeser.SmallTupleExt:
+		output.WriteString(`{"type":"tuple","elements":[`)
+		for i, child := range t.Children {
+			if i > 0 {
+				output.WriteString(",")
+			}
+			err := formatTaggedJSON(child, output)
+			if err != nil {
+				return err
+			}
+		}
+		output.WriteString("]}")
+	case erldeser.ListExt:
+		output.WriteString(`{"type":"list","elements":[`)
+		elements, tail := t.Children[:len(t.Children)-1], t.Children[len(t.Children)-1]
+		for i, child := range elements {
+			if i > 0 {
+				output.WriteString(",")
+			}
+			err := formatTaggedJSON(child, output)
+			if err != nil {
+				return err
+			}
+		}
+		output.WriteString("]")
+		if tail.T.Term != erldeser.NilExt {
+			output.WriteString(`,"tail":`)
+			err := formatTaggedJSON(tail, output)
+			if err != nil {
+				return err
+			}
+		}
+		output.WriteString("}")
+	default:
+		return fmt.Errorf("Can not format %v as tagged JSON", erldeser.TypeName(t.T.Term))
+	}
+	return nil
+}
+
+// writeTaggedBytes writes bytes as "value" string when they are valid
+// UTF-8 and as "base64" otherwise
+func writeTaggedBytes(b []byte, output *strings.Builder) {
+	if utf8.Valid(b) {
+		output.WriteString(`"value":`)
+		writeJSONString(string(b), output)
+		return
+	}
+	output.WriteString(`"base64":"`)
+	output.WriteString(base64.StdEncoding.EncodeToString(b))
+	output.WriteString(`"`)
+}
+
+// writeJSONString writes JSON quoted string
+func writeJSONString(s string, output *strings.Builder) {
+	quoted, _ := json.Marshal(s)
+	output.Write(quoted)
+}
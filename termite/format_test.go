@@ -0,0 +1,220 @@
+package termite
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/pipedrive/uncouch/erldeser"
+)
+
+func termString(s string) []byte {
+	b := binary.BigEndian.AppendUint16([]byte{byte(erldeser.StringExt)}, uint16(len(s)))
+	return append(b, s...)
+}
+
+// termBigInt returns SMALL_BIG_EXT of n
+func termBigInt(n *big.Int) []byte {
+	var sign byte
+	if n.Sign() < 0 {
+		sign = 1
+	}
+	digits := new(big.Int).Abs(n).Bytes()
+	b := []byte{byte(erldeser.SmallBigExt), byte(len(digits)), sign}
+	for i := len(digits) - 1; i >= 0; i-- {
+		b = append(b, digits[i])
+	}
+	return b
+}
+
+// termImproperList returns list of elements ending with tail instead of []
+func termImproperList(tail []byte, elements ...[]byte) []byte {
+	b := binary.BigEndian.AppendUint32([]byte{byte(erldeser.ListExt)}, uint32(len(elements)))
+	for _, e := range elements {
+		b = append(b, e...)
+	}
+	return append(b, tail...)
+}
+
+var formatTests = []struct {
+	name   string
+	input  []byte
+	erlang string
+	tagged string
+}{
+	{"lower case atom", termAtom("kv_node"), `kv_node`,
+		`{"type":"atom","value":"kv_node"}`},
+	{"atom with digits and at", termAtom("node1@host"), `node1@host`,
+		`{"type":"atom","value":"node1@host"}`},
+	{"capitalised atom", termAtom("Kv"), `'Kv'`,
+		`{"type":"atom","value":"Kv"}`},
+	{"reserved word atom", termAtom("receive"), `'receive'`,
+		`{"type":"atom","value":"receive"}`},
+	{"empty atom", termAtom(""), `''`,
+		`{"type":"atom","value":""}`},
+	{"atom with quote and dash", termAtom("it's-a"), `'it\'s-a'`,
+		`{"type":"atom","value":"it's-a"}`},
+	{"small integer", termInt(42), `42`,
+		`{"type":"integer","value":42}`},
+	{"negative integer", termInt(-70000), `-70000`,
+		`{"type":"integer","value":-70000}`},
+	{"big integer", termBig(9), `18446744073709551616`,
+		`{"type":"big","value":"18446744073709551616"}`},
+	{"negative big integer", termBigInt(new(big.Int).Lsh(big.NewInt(-1), 70)), `-1180591620717411303424`,
+		`{"type":"big","value":"-1180591620717411303424"}`},
+	{"float", termFloat(1.5), `1.5`,
+		`{"type":"float","value":1.5}`},
+	{"integral float", termFloat(2), `2.0`,
+		`{"type":"float","value":2}`},
+	{"float with exponent", termFloat(1e21), `1.0e21`,
+		`{"type":"float","value":1e+21}`},
+	{"float with negative exponent", termFloat(-2.5e-10), `-2.5e-10`,
+		`{"type":"float","value":-2.5e-10}`},
+	{"empty list", termList(), `[]`,
+		`{"type":"nil"}`},
+	{"printable string", termString("a \"b\"\n"), `"a \"b\"\n"`,
+		`{"type":"string","value":"a \"b\"\n"}`},
+	{"byte string", termString("\x01\xff"), `[1,255]`,
+		`{"type":"string","base64":"Af8="}`},
+	{"ascii binary", termBinary(`back\slash`), `<<"back\\slash">>`,
+		`{"type":"binary","value":"back\\slash"}`},
+	{"utf8 binary", termBinary("ümlaut"), `<<"ümlaut"/utf8>>`,
+		`{"type":"binary","value":"ümlaut"}`},
+	{"control character binary", termBinary("a\x7fb"), `<<97,127,98>>`,
+		"{\"type\":\"binary\",\"value\":\"a\x7fb\"}"},
+	{"raw binary", termBinary("\x00\x01\xfe"), `<<0,1,254>>`,
+		`{"type":"binary","base64":"AAH+"}`},
+	{"empty binary", termBinary(""), `<<"">>`,
+		`{"type":"binary","value":""}`},
+	{"tuple", termTuple(termAtom("ok"), termBinary("x"), termTuple()), `{ok,<<"x">>,{}}`,
+		`{"type":"tuple","elements":[{"type":"atom","value":"ok"},{"type":"binary","value":"x"},{"type":"tuple","elements":[]}]}`},
+	{"proper list", termList(termInt(1), termList(termInt(2))), `[1,[2]]`,
+		`{"type":"list","elements":[{"type":"integer","value":1},{"type":"list","elements":[{"type":"integer","value":2}]}]}`},
+	{"improper list", termImproperList(termAtom("tail"), termInt(1), termInt(2)), `[1,2|tail]`,
+		`{"type":"list","elements":[{"type":"integer","value":1},{"type":"integer","value":2}],"tail":{"type":"atom","value":"tail"}}`},
+	{"improper list of binary tail", termImproperList(termBinary("t"), termFloat(0.5)), `[0.5|<<"t">>]`,
+		`{"type":"list","elements":[{"type":"float","value":0.5}],"tail":{"type":"binary","value":"t"}}`},
+}
+
+func TestWriteErlang(t *testing.T) {
+	for _, tt := range formatTests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			if err := readTermite(t, tt.input).WriteErlang(&output); err != nil {
+				t.Fatal(err)
+			}
+			if output.String() != tt.erlang {
+				t.Errorf("got %s, want %s", output.String(), tt.erlang)
+			}
+		})
+	}
+}
+
+func TestWriteTaggedJSON(t *testing.T) {
+	for _, tt := range formatTests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			if err := readTermite(t, tt.input).WriteTaggedJSON(&output); err != nil {
+				t.Fatal(err)
+			}
+			if output.String() != tt.tagged {
+				t.Errorf("got %s, want %s", output.String(), tt.tagged)
+			}
+			if !json.Valid(output.Bytes()) {
+				t.Fatalf("invalid JSON %s", output.String())
+			}
+		})
+	}
+}
+
+// taggedTerm is a term of tagged JSON
+type taggedTerm struct {
+	Type     string          `json:"type"`
+	Value    json.RawMessage `json:"value"`
+	Base64   *string         `json:"base64"`
+	Elements []taggedTerm    `json:"elements"`
+	Tail     *taggedTerm     `json:"tail"`
+}
+
+// restoreTerm returns the Erlang term of tagged JSON
+func restoreTerm(t *testing.T, tt taggedTerm) []byte {
+	t.Helper()
+	text := func() string {
+		var s string
+		var err error
+		if tt.Base64 == nil {
+			err = json.Unmarshal(tt.Value, &s)
+		} else {
+			var b []byte
+			b, err = base64.StdEncoding.DecodeString(*tt.Base64)
+			s = string(b)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	number := json.Number(tt.Value)
+	switch tt.Type {
+	case "atom":
+		return termAtom(text())
+	case "integer":
+		i, err := number.Int64()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return termInt(int32(i))
+	case "big":
+		n, ok := new(big.Int).SetString(text(), 10)
+		if !ok {
+			t.Fatalf("invalid big %q", tt.Value)
+		}
+		return termBigInt(n)
+	case "float":
+		f, err := number.Float64()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return termFloat(f)
+	case "nil":
+		return termList()
+	case "string":
+		return termString(text())
+	case "binary":
+		return termBinary(text())
+	case "tuple":
+		var elements [][]byte
+		for _, e := range tt.Elements {
+			elements = append(elements, restoreTerm(t, e))
+		}
+		return termTuple(elements...)
+	case "list":
+		var elements [][]byte
+		for _, e := range tt.Elements {
+			elements = append(elements, restoreTerm(t, e))
+		}
+		if tt.Tail != nil {
+			return termImproperList(restoreTerm(t, *tt.Tail), elements...)
+		}
+		return termList(elements...)
+	}
+	t.Fatalf("unknown type %q", tt.Type)
+	return nil
+}
+
+func TestTaggedJSONIsLossless(t *testing.T) {
+	for _, tt := range formatTests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tagged taggedTerm
+			if err := json.Unmarshal([]byte(readTermite(t, tt.input).TaggedJSON()), &tagged); err != nil {
+				t.Fatal(err)
+			}
+			if got := restoreTerm(t, tagged); !bytes.Equal(got, tt.input) {
+				t.Errorf("restored %v, want %v", got, tt.input)
+			}
+		})
+	}
+}
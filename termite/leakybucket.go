@@ -2,30 +2,68 @@ package termite
 
 import (
 	"fmt"
+	"sync/atomic"
 
 	"github.com/pipedrive/uncouch/erlterm"
 )
 
-const termPoolSize = 300
+const (
+	termPoolSize = 300
+	// maxFreeTermPools limits number of Term pools kept for reuse
+	maxFreeTermPools = 50
+)
 
 var (
-	freeTermPoolList                                                                       = make(chan *[]*erlterm.Term, 50)
-	putPoolSuccess, putPoolFailure, getPoolSuccess, getPoolFailure, termPoolListIncrements int64
+	freeTermPoolList = make(chan *[]*erlterm.Term, maxFreeTermPools)
+
+	putPoolSuccess, putPoolFailure, getPoolSuccess, getPoolFailure, termPoolListIncrements atomic.Int64
 )
 
+// PoolStats is snapshot of Term pool counters
+type PoolStats struct {
+	PutSuccess int64
+	PutFailure int64
+	GetSuccess int64
+	GetFailure int64
+	// Increments counts Builders needing more than one Term pool
+	Increments int64
+	// Free is number of Term pools on the free list
+	Free int
+	// TermsPerPool is number of Terms in single pool
+	TermsPerPool int
+}
+
+// GetPoolStats returns snapshot of Term pool counters
+func GetPoolStats() PoolStats {
+	return PoolStats{
+		PutSuccess:   putPoolSuccess.Load(),
+		PutFailure:   putPoolFailure.Load(),
+		GetSuccess:   getPoolSuccess.Load(),
+		GetFailure:   getPoolFailure.Load(),
+		Increments:   termPoolListIncrements.Load(),
+		Free:         len(freeTermPoolList),
+		TermsPerPool: termPoolSize,
+	}
+}
+
+// String implements Stringer for printing the stats
+func (s PoolStats) String() string {
+	return fmt.Sprintf("term pools: putPoolSuccess %v, putPoolFailure %v, getPoolSuccess %v, getPoolFailure %v, termPoolListIncrements %v, free %v",
+		s.PutSuccess, s.PutFailure, s.GetSuccess, s.GetFailure, s.Increments, s.Free)
+}
+
 // GetProfilerData emits primitive profiler data bout our pool caching
 func GetProfilerData() string {
-	return fmt.Sprintf("putPoolSuccess %v, putPoolFailure %v, getPoolSuccess %v, getPoolFailure %v, termPoolListIncrements %v",
-		putPoolSuccess, putPoolFailure, getPoolSuccess, getPoolFailure, termPoolListIncrements)
+	return GetPoolStats().String()
 }
 
 // GetTermPool returns reusable Term pool in the hope to reduce GC stress
 func GetTermPool() (tp *[]*erlterm.Term) {
 	select {
 	case tp = <-freeTermPoolList:
-		getPoolSuccess++
+		getPoolSuccess.Add(1)
 	default:
-		getPoolFailure++
+		getPoolFailure.Add(1)
 		newPool := make([]*erlterm.Term, termPoolSize)
 		for i := range newPool {
 			newPool[i] = new(erlterm.Term)
@@ -43,10 +81,10 @@ func PutTermPool(tp *[]*erlterm.Term) {
 	}
 	select {
 	case freeTermPoolList <- tp:
-		putPoolSuccess++
+		putPoolSuccess.Add(1)
 		// Term on free list; nothing more to do.
 	default:
-		putPoolFailure++
+		putPoolFailure.Add(1)
 		// Free list full, just carry on.
 	}
 	return
@@ -56,7 +94,7 @@ func PutTermPool(tp *[]*erlterm.Term) {
 func (b *Builder) GetTerm() (t *erlterm.Term) {
 	if b.j >= termPoolSize {
 		b.termPools = append(b.termPools, GetTermPool())
-		termPoolListIncrements++
+		termPoolListIncrements.Add(1)
 		b.i++
 		b.j = 0
 	}
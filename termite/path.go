@@ -0,0 +1,202 @@
+package termite
+
+import (
+	"fmt"
+
+	"github.com/pipedrive/uncouch/erldeser"
+	"github.com/pipedrive/uncouch/erlterm"
+)
+
+// PathError describes Termite not having the shape the caller expected
+type PathError struct {
+	Path   []int
+	Want   string
+	Got    erlterm.TermType
+	Reason string
+}
+
+// Error implements error interface
+func (e *PathError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("termite path %v: %s (%s)", e.Path, e.Reason, erldeser.TypeName(e.Got))
+	}
+	return fmt.Sprintf("termite path %v: expected %s, got %s", e.Path, e.Want, erldeser.TypeName(e.Got))
+}
+
+// Node points to a Termite inside a bigger structure. It remembers how it
+// was reached so decoding errors can tell where the structure went wrong.
+// Navigation errors are kept inside the Node and returned by the accessors.
+type Node struct {
+	t    *Termite
+	path []int
+	err  error
+}
+
+// Path navigates to the child Termite reached through given indexes
+func (t *Termite) Path(path ...int) Node {
+	return Node{t: t}.Path(path...)
+}
+
+// Path navigates further down from the Node
+func (n Node) Path(path ...int) Node {
+	for _, i := range path {
+		if n.err != nil {
+			return n
+		}
+		p := make([]int, len(n.path), len(n.path)+1)
+		copy(p, n.path)
+		p = append(p, i)
+		size, err := n.Len()
+		if err != nil {
+			return Node{path: p, err: err}
+		}
+		if i < 0 || i >= size {
+			return Node{path: p, err: &PathError{
+				Path:   p,
+				Got:    n.t.T.Term,
+				Reason: fmt.Sprintf("index %d out of range [0:%d]", i, size),
+			}}
+		}
+		n = Node{t: n.t.Children[i], path: p}
+	}
+	return n
+}
+
+// Err returns navigation error, if any
+func (n Node) Err() error {
+	return n.err
+}
+
+// Termite returns Termite the Node points to
+func (n Node) Termite() (*Termite, error) {
+	if n.err != nil {
+		return nil, n.err
+	}
+	return n.t, nil
+}
+
+// Type returns term type of the Node or zero if navigation failed
+func (n Node) Type() erlterm.TermType {
+	if n.err != nil {
+		return 0
+	}
+	return n.t.T.Term
+}
+
+// IsNil returns true if Node points to empty list
+func (n Node) IsNil() bool {
+	return n.Type() == erldeser.NilExt
+}
+
+// Len returns number of elements in tuple or list. Empty list has length 0.
+func (n Node) Len() (int, error) {
+	if n.err != nil {
+		return 0, n.err
+	}
+	switch n.t.T.Term {
+	case erldeser.SmallTupleExt, erldeser.ListExt:
+		return int(n.t.T.IntegerValue), nil
+	case erldeser.NilExt:
+		return 0, nil
+	default:
+		return 0, n.mismatch("tuple or list")
+	}
+}
+
+// TupleN checks the Node is a tuple with arity of n
+func (n Node) TupleN(arity int) (Node, error) {
+	if n.err != nil {
+		return n, n.err
+	}
+	if n.t.T.Term != erldeser.SmallTupleExt {
+		return n, n.mismatch(fmt.Sprintf("tuple of %d", arity))
+	}
+	if int(n.t.T.IntegerValue) != arity {
+		return n, &PathError{
+			Path:   n.path,
+			Got:    n.t.T.Term,
+			Reason: fmt.Sprintf("expected %d elements, got %d", arity, n.t.T.IntegerValue),
+		}
+	}
+	return n, nil
+}
+
+// List returns list elements as Nodes, leaving out the list tail
+func (n Node) List() ([]Node, error) {
+	if n.err != nil {
+		return nil, n.err
+	}
+	switch n.t.T.Term {
+	case erldeser.NilExt:
+		return nil, nil
+	case erldeser.ListExt:
+		elements := make([]Node, n.t.T.IntegerValue)
+		for i := range elements {
+			elements[i] = n.Path(i)
+		}
+		return elements, nil
+	default:
+		return nil, n.mismatch("list")
+	}
+}
+
+// Int returns integer value of the Node
+func (n Node) Int() (int64, error) {
+	if n.err != nil {
+		return 0, n.err
+	}
+	switch n.t.T.Term {
+	case erldeser.SmallIntegerExt, erldeser.IntegerExt:
+		return n.t.T.IntegerValue, nil
+	case erldeser.SmallBigExt, erldeser.LargeBigExt:
+		if !n.t.T.BigValue.IsInt64() {
+			return 0, n.mismatch("integer fitting into int64")
+		}
+		return n.t.T.IntegerValue, nil
+	default:
+		return 0, n.mismatch("integer")
+	}
+}
+
+// Float returns float value of the Node
+func (n Node) Float() (float64, error) {
+	if n.err != nil {
+		return 0, n.err
+	}
+	if n.t.T.Term != erldeser.NewFloatExt {
+		return 0, n.mismatch("float")
+	}
+	return n.t.T.FloatValue, nil
+}
+
+// Atom returns atom name of the Node
+func (n Node) Atom() (string, error) {
+	if n.err != nil {
+		return "", n.err
+	}
+	if n.t.T.Term != erldeser.AtomExt {
+		return "", n.mismatch("atom")
+	}
+	return string(n.t.T.Binary), nil
+}
+
+// Binary returns binary value of the Node. Returned slice shares memory
+// with the Termite and is valid until Termite is released.
+func (n Node) Binary() ([]byte, error) {
+	if n.err != nil {
+		return nil, n.err
+	}
+	if n.t.T.Term != erldeser.BinaryExt {
+		return nil, n.mismatch("binary")
+	}
+	return n.t.T.Binary, nil
+}
+
+// mismatch builds PathError for the Node
+func (n Node) mismatch(want string) error {
+	return &PathError{
+		Path: n.path,
+		Want: want,
+		Got:  n.t.T.Term,
+	}
+}
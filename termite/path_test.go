@@ -0,0 +1,182 @@
+package termite
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/pipedrive/uncouch/erldeser"
+)
+
+// Builders of Erlang terms used by the tests
+
+func termAtom(s string) []byte {
+	b := binary.BigEndian.AppendUint16([]byte{byte(erldeser.AtomExt)}, uint16(len(s)))
+	return append(b, s...)
+}
+
+func termBinary(s string) []byte {
+	b := binary.BigEndian.AppendUint32([]byte{byte(erldeser.BinaryExt)}, uint32(len(s)))
+	return append(b, s...)
+}
+
+func termInt(i int32) []byte {
+	if i >= 0 && i < 256 {
+		return []byte{byte(erldeser.SmallIntegerExt), byte(i)}
+	}
+	return binary.BigEndian.AppendUint32([]byte{byte(erldeser.IntegerExt)}, uint32(i))
+}
+
+func termFloat(f float64) []byte {
+	return binary.BigEndian.AppendUint64([]byte{byte(erldeser.NewFloatExt)}, math.Float64bits(f))
+}
+
+// termBig returns SMALL_BIG_EXT of 2^(8*(digits-1))
+func termBig(digits int) []byte {
+	b := []byte{byte(erldeser.SmallBigExt), byte(digits), 0}
+	b = append(b, make([]byte, digits-1)...)
+	return append(b, 1)
+}
+
+func termTuple(elements ...[]byte) []byte {
+	b := []byte{byte(erldeser.SmallTupleExt), byte(len(elements))}
+	for _, e := range elements {
+		b = append(b, e...)
+	}
+	return b
+}
+
+func termList(elements ...[]byte) []byte {
+	if len(elements) == 0 {
+		return []byte{byte(erldeser.NilExt)}
+	}
+	b := binary.BigEndian.AppendUint32([]byte{byte(erldeser.ListExt)}, uint32(len(elements)))
+	for _, e := range elements {
+		b = append(b, e...)
+	}
+	return append(b, byte(erldeser.NilExt))
+}
+
+// readTermite builds Termite of input
+func readTermite(t *testing.T, input []byte) *Termite {
+	t.Helper()
+	s, err := erldeser.NewScanner(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tb, err := NewBuilder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tt, err := tb.ReadTermite(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(tt.Release)
+	return tt
+}
+
+// testNode returns Termite of
+// {kp_node, [{<<"id">>, 42}, {<<"x">>, 1.5}], 2^64, []}
+func testNode(t *testing.T) *Termite {
+	return readTermite(t, termTuple(
+		termAtom("kp_node"),
+		termList(
+			termTuple(termBinary("id"), termInt(42)),
+			termTuple(termBinary("x"), termFloat(1.5)),
+		),
+		termBig(9),
+		termList(),
+	))
+}
+
+func TestPathValues(t *testing.T) {
+	tt := testNode(t)
+	if atom, err := tt.Path(0).Atom(); err != nil || atom != "kp_node" {
+		t.Errorf("got atom %q, %v", atom, err)
+	}
+	elements, err := tt.Path(1).List()
+	if err != nil || len(elements) != 2 {
+		t.Fatalf("got %d elements, %v", len(elements), err)
+	}
+	if id, err := elements[0].Path(0).Binary(); err != nil || string(id) != "id" {
+		t.Errorf("got binary %q, %v", id, err)
+	}
+	if i, err := elements[0].Path(1).Int(); err != nil || i != 42 {
+		t.Errorf("got integer %d, %v", i, err)
+	}
+	if f, err := tt.Path(1, 1, 1).Float(); err != nil || f != 1.5 {
+		t.Errorf("got float %v, %v", f, err)
+	}
+	if _, err := tt.Path(1, 0).TupleN(2); err != nil {
+		t.Error(err)
+	}
+	if !tt.Path(3).IsNil() {
+		t.Error("empty list is not nil")
+	}
+	if n, err := tt.Path(3).Len(); err != nil || n != 0 {
+		t.Errorf("got length %d, %v of empty list", n, err)
+	}
+	if empty, err := tt.Path(3).List(); err != nil || len(empty) != 0 {
+		t.Errorf("got %d elements, %v of empty list", len(empty), err)
+	}
+}
+
+func TestPathErrors(t *testing.T) {
+	tt := testNode(t)
+	tests := []struct {
+		name string
+		err  func() error
+		want string
+	}{
+		{"index out of range", func() error { return tt.Path(4).Err() },
+			"termite path [4]: index 4 out of range [0:4] (SMALL_TUPLE_EXT)"},
+		{"negative index", func() error { return tt.Path(1, -1).Err() },
+			"termite path [1 -1]: index -1 out of range [0:2] (LIST_EXT)"},
+		{"index into atom", func() error { return tt.Path(0, 0).Err() },
+			"termite path [0]: expected tuple or list, got ATOM_EXT"},
+		{"error kept by further path", func() error { return tt.Path(0, 0).Path(1, 2).Err() },
+			"termite path [0]: expected tuple or list, got ATOM_EXT"},
+		{"tuple of wrong arity", func() error { _, err := tt.Path().TupleN(3); return err },
+			"termite path []: expected 3 elements, got 4 (SMALL_TUPLE_EXT)"},
+		{"tuple expected", func() error { _, err := tt.Path(1).TupleN(2); return err },
+			"termite path [1]: expected tuple of 2, got LIST_EXT"},
+		{"list expected", func() error { _, err := tt.Path(1, 0).List(); return err },
+			"termite path [1 0]: expected list, got SMALL_TUPLE_EXT"},
+		{"integer expected", func() error { _, err := tt.Path(1, 0, 0).Int(); return err },
+			"termite path [1 0 0]: expected integer, got BINARY_EXT"},
+		{"integer beyond int64", func() error { _, err := tt.Path(2).Int(); return err },
+			"termite path [2]: expected integer fitting into int64, got SMALL_BIG_EXT"},
+		{"float expected", func() error { _, err := tt.Path(1, 0, 1).Float(); return err },
+			"termite path [1 0 1]: expected float, got SMALL_INTEGER_EXT"},
+		{"atom expected", func() error { _, err := tt.Path(3).Atom(); return err },
+			"termite path [3]: expected atom, got NIL_EXT"},
+		{"binary expected", func() error { _, err := tt.Path(0).Binary(); return err },
+			"termite path [0]: expected binary, got ATOM_EXT"},
+		{"accessor after failed path", func() error { _, err := tt.Path(9).Binary(); return err },
+			"termite path [9]: index 9 out of range [0:4] (SMALL_TUPLE_EXT)"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.err()
+			var pe *PathError
+			if !errors.As(err, &pe) {
+				t.Fatalf("got error %v, want PathError", err)
+			}
+			if err.Error() != tc.want {
+				t.Errorf("got %q, want %q", err.Error(), tc.want)
+			}
+		})
+	}
+}
+
+func TestNodeOfFailedPath(t *testing.T) {
+	n := testNode(t).Path(0, 1)
+	if n.Type() != 0 || n.IsNil() {
+		t.Errorf("failed path has type %v", n.Type())
+	}
+	if _, err := n.Termite(); err == nil {
+		t.Error("failed path returned Termite")
+	}
+}
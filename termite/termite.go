@@ -41,7 +41,6 @@ func (b *Builder) ReadTermite(s *erldeser.Scanner) (*Termite, error) {
 	var rootTermite Termite
 	err := b.buildTermite(&rootTermite)
 	if err != nil {
-		slog.Error(err)
 		b.s = nil
 		return nil, err
 	}
@@ -54,7 +53,10 @@ func (b *Builder) ReadTermite(s *erldeser.Scanner) (*Termite, error) {
 // buildTermite is recursive functiuon building Termite structure
 func (b *Builder) buildTermite(buildNode *Termite) error {
 	t := b.GetTerm()
-	b.s.Scan(t)
+	err := b.s.Scan(t)
+	if err != nil {
+		return err
+	}
 	buildNode.T = *t
 	switch t.Term {
 	case erldeser.NewFloatExt:
@@ -65,6 +67,7 @@ func (b *Builder) buildTermite(buildNode *Termite) error {
 	case erldeser.StringExt:
 	case erldeser.BinaryExt:
 	case erldeser.SmallBigExt:
+	case erldeser.LargeBigExt:
 	case erldeser.SmallTupleExt:
 		children := make([]*Termite, 0, 5)
 		buildNode.Children = children
@@ -74,7 +77,6 @@ func (b *Builder) buildTermite(buildNode *Termite) error {
 			buildNode.Children = temp
 			err := b.buildTermite(termite)
 			if err != nil {
-				slog.Error(err)
 				return err
 			}
 		}
@@ -87,14 +89,11 @@ func (b *Builder) buildTermite(buildNode *Termite) error {
 			buildNode.Children = temp
 			err := b.buildTermite(termite)
 			if err != nil {
-				slog.Error(err)
 				return err
 			}
 		}
 	default:
-		err := fmt.Errorf("Unhandled term type %v", t.Term)
-		slog.Error(err)
-		return err
+		return fmt.Errorf("%w %v", erldeser.ErrUnsupportedTerm, erldeser.TypeName(t.Term))
 	}
 	return nil
 }
@@ -121,6 +120,8 @@ func formatTermite(t *Termite, output *strings.Builder, nestedLevel int) {
 		output.WriteString(fmt.Sprintf("%s Small int: %v\n", pad, t.T.IntegerValue))
 	case erldeser.IntegerExt:
 		output.WriteString(fmt.Sprintf("%s Int: %v\n", pad, t.T.IntegerValue))
+	case erldeser.SmallBigExt, erldeser.LargeBigExt:
+		output.WriteString(fmt.Sprintf("%s Big int: %v\n", pad, t.T.BigValue))
 	case erldeser.AtomExt:
 		output.WriteString(fmt.Sprintf("%s Atom: %v\n", pad, string(t.T.Binary)))
 	case erldeser.SmallTupleExt:
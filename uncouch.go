@@ -0,0 +1,211 @@
+// Package uncouch reads documents directly from CouchDB .couch data files
+// without running CouchDB.
+//
+//	db, err := uncouch.Open("users.couch")
+//	if err != nil {
+//		return err
+//	}
+//	defer db.Close()
+//	it := db.Documents()
+//	for it.Next() {
+//		doc, err := it.Document()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		return err
+//	}
+//
+// DB is not safe for concurrent use, open the file once per goroutine
+// instead.
+package uncouch
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pipedrive/uncouch/couchdbfile"
+	"github.com/pipedrive/uncouch/leakybucket"
+	"github.com/pipedrive/uncouch/logger"
+)
+
+// Logger is the logging interface used by uncouch. *zap.SugaredLogger
+// satisfies it.
+type Logger = logger.Logger
+
+// SetLogger sets logger used by uncouch. Logs are discarded until it is
+// called. Logger is shared by all open files.
+func SetLogger(l Logger) {
+	logger.Set(l)
+}
+
+// DB is single CouchDB file opened for reading
+type DB struct {
+	cf     *couchdbfile.CouchDbFile
+	closer io.Closer
+	size   int64
+	opts   options
+}
+
+// Info describes the database file
+type Info struct {
+	Name            string
+	DiskVersion     int
+	UpdateSeq       int64
+	DocCount        int64
+	DeletedDocCount int64
+	FileSize        int64
+}
+
+// Document is single document read from the file
+type Document struct {
+	ID      string
+	Rev     string
+	Seq     int64
+	Deleted bool
+	// Body is document body as JSON object. Documents passed through
+	// Pipeline have no Body until ReadBody is called.
+	Body json.RawMessage
+	// source writes the body once it is needed, fields are set by
+	// SetField and not applied to the body yet
+	source BodySource
+	fields []Field
+}
+
+// Open opens CouchDB file at path. Database name defaults to the file
+// name without extension.
+func Open(filename string, opts ...Option) (*DB, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	name := strings.Split(path.Base(filename), ".")[0]
+	opts = append([]Option{WithName(name)}, opts...)
+	db, err := OpenReader(f, fi.Size(), opts...)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	db.closer = f
+	return db, nil
+}
+
+// OpenReader reads CouchDB file of given size from input. Close does not
+// close the input.
+func OpenReader(input io.ReadSeeker, size int64, opts ...Option) (*DB, error) {
+	var (
+		newDB DB
+	)
+	db := &newDB
+	for _, opt := range opts {
+		opt(&db.opts)
+	}
+	if db.opts.poolMemory != nil {
+		leakybucket.SetMemoryCeiling(*db.opts.poolMemory)
+	}
+	cf, err := couchdbfile.New(input, size)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	cf.SetUTF8Policy(db.opts.utf8Policy)
+	cf.SetASCII(db.opts.ascii)
+	cf.SetFloatFormat(db.opts.floats)
+	db.cf = cf
+	db.size = size
+	return db, nil
+}
+
+// Close closes the file opened by Open
+func (db *DB) Close() error {
+	if db.closer == nil {
+		return nil
+	}
+	err := db.closer.Close()
+	db.closer = nil
+	return err
+}
+
+// Info returns information about the database
+func (db *DB) Info() (Info, error) {
+	docCount, deletedCount, err := db.cf.DocCounts()
+	if err != nil {
+		return Info{}, apiError(err)
+	}
+	return Info{
+		Name:            db.opts.name,
+		DiskVersion:     int(db.cf.Header.DiskVersion),
+		UpdateSeq:       int64(db.cf.Header.UpdateSeq),
+		DocCount:        docCount,
+		DeletedDocCount: deletedCount,
+		FileSize:        db.size,
+	}, nil
+}
+
+// Get reads document by ID. It returns ErrNotFound when there is no such
+// document, or the document is deleted and WithDeleted is not set.
+func (db *DB) Get(id string) (*Document, error) {
+	di, err := db.cf.LookupID([]byte(id))
+	if err != nil {
+		return nil, apiError(err)
+	}
+	if di == nil || (di.Deleted != 0 && !db.opts.deleted) {
+		return nil, ErrNotFound
+	}
+	return db.readDocument(di)
+}
+
+// Unmarshal decodes body of document with given ID into v like
+// json.Decoder with UseNumber does, so numbers in interface{} values are
+// json.Number. Missing documents are reported as by Get.
+func (db *DB) Unmarshal(id string, v interface{}) error {
+	di, err := db.cf.LookupID([]byte(id))
+	if err != nil {
+		return apiError(err)
+	}
+	if di == nil || (di.Deleted != 0 && !db.opts.deleted) {
+		return ErrNotFound
+	}
+	return apiError(db.cf.UnmarshalDocument(di, v))
+}
+
+// Documents returns iterator over documents ordered by ID. Deleted
+// documents are skipped unless WithDeleted is set.
+func (db *DB) Documents() *Iterator {
+	return &Iterator{db: db, c: db.cf.IDCursor(), skipDeleted: !db.opts.deleted}
+}
+
+// Changes returns iterator over documents ordered by update sequence,
+// starting after sequence since. Deleted documents are included.
+func (db *DB) Changes(since int64) *Iterator {
+	return &Iterator{db: db, c: db.cf.SeqCursor(since)}
+}
+
+// readDocument reads document body and metadata
+func (db *DB) readDocument(di *couchdbfile.DocumentInfo) (*Document, error) {
+	output := leakybucket.GetBuffer()
+	defer leakybucket.PutBuffer(output)
+	err := db.cf.WriteDocument(di, output)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	doc := newDocument(di)
+	doc.Body = append(json.RawMessage(nil), output.Bytes()...)
+	return doc, nil
+}
+
+// newDocument returns Document with metadata of di and no body
+func newDocument(di *couchdbfile.DocumentInfo) *Document {
+	return &Document{
+		ID:      string(di.ID),
+		Rev:     di.Rev(),
+		Seq:     di.UpdateSeq,
+		Deleted: di.Deleted != 0,
+	}
+}
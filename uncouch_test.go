@@ -0,0 +1,189 @@
+package uncouch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/pipedrive/uncouch/internal/couchtest"
+)
+
+// testFile returns path of database with documents spread over several
+// Btree nodes. Documents "b" and "f" are deleted.
+func testFile(t *testing.T) string {
+	return couchtest.File(t,
+		couchtest.Doc("c", 1, "c1", `{"name":"Carol","age":41}`),
+		couchtest.Doc("a", 2, "a2", `{"name":"Ann","tags":["x","y"]}`),
+		couchtest.Deleted("b", 3, "b3"),
+		couchtest.Doc("e", 1, "e1", `{"name":"Eve","age":7}`),
+		couchtest.Doc("d", 1, "d1", `{"name":"Dan"}`),
+		couchtest.Deleted("f", 2, "f2"),
+		couchtest.Doc("h", 1, "h1", `{"name":"Hal"}`),
+		couchtest.Doc("g", 4, "g4", `{"name":"Gus","age":3}`),
+	)
+}
+
+// openTest opens database of testFile
+func openTest(t *testing.T, opts ...Option) *DB {
+	t.Helper()
+	db, err := Open(testFile(t), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// iteratorIDs returns IDs and sequences of iterated documents
+func iteratorIDs(t *testing.T, it *Iterator) ([]string, []int64) {
+	t.Helper()
+	var ids []string
+	var seqs []int64
+	for it.Next() {
+		meta := it.Meta()
+		ids = append(ids, meta.ID)
+		seqs = append(seqs, meta.Seq)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return ids, seqs
+}
+
+func TestInfo(t *testing.T) {
+	info, err := openTest(t).Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Info{Name: "test", DiskVersion: 6, UpdateSeq: 8, DocCount: 6, DeletedDocCount: 2, FileSize: info.FileSize}
+	if info != want || info.FileSize == 0 {
+		t.Errorf("got %+v, want %+v", info, want)
+	}
+}
+
+func TestGet(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      string
+		opts    []Option
+		want    *Document
+		wantErr error
+	}{
+		{"hit", "e", nil, &Document{ID: "e", Rev: "1-e1", Seq: 4, Body: []byte(`{"name":"Eve","age":7}`)}, nil},
+		{"hit in last node", "h", nil, &Document{ID: "h", Rev: "1-h1", Seq: 7, Body: []byte(`{"name":"Hal"}`)}, nil},
+		{"miss before first", "0", nil, nil, ErrNotFound},
+		{"miss between", "bb", nil, nil, ErrNotFound},
+		{"miss after last", "z", nil, nil, ErrNotFound},
+		{"deleted", "b", nil, nil, ErrNotFound},
+		{"deleted with WithDeleted", "b", []Option{WithDeleted()},
+			&Document{ID: "b", Rev: "3-b3", Seq: 3, Deleted: true, Body: []byte(`{}`)}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := openTest(t, tt.opts...).Get(tt.id)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(doc, tt.want) {
+				t.Errorf("got %+v, want %+v", doc, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	db := openTest(t)
+	var person struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}
+	if err := db.Unmarshal("c", &person); err != nil {
+		t.Fatal(err)
+	}
+	if person.Name != "Carol" || person.Age != 41 {
+		t.Errorf("got %+v", person)
+	}
+	var fields map[string]interface{}
+	if err := db.Unmarshal("c", &fields); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]interface{}{"name": "Carol", "age": json.Number("41")}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("got %v, want %v", fields, want)
+	}
+	if err := db.Unmarshal("missing", &person); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v for missing document, want ErrNotFound", err)
+	}
+	if err := db.Unmarshal("f", &person); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v for deleted document, want ErrNotFound", err)
+	}
+}
+
+func TestDocuments(t *testing.T) {
+	ids, _ := iteratorIDs(t, openTest(t).Documents())
+	if want := []string{"a", "c", "d", "e", "g", "h"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got %v, want %v", ids, want)
+	}
+	ids, _ = iteratorIDs(t, openTest(t, WithDeleted()).Documents())
+	if want := []string{"a", "b", "c", "d", "e", "f", "g", "h"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got %v with deleted, want %v", ids, want)
+	}
+}
+
+func TestDocumentsBody(t *testing.T) {
+	it := openTest(t).Documents()
+	if !it.Next() {
+		t.Fatal(it.Err())
+	}
+	var body bytes.Buffer
+	if err := it.WriteBody(&body); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := it.Document()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"name":"Ann","tags":["x","y"]}`
+	if body.String() != want || string(doc.Body) != want || doc.Rev != "2-a2" {
+		t.Errorf("got body %s and document %+v, want %s", body.String(), doc, want)
+	}
+}
+
+func TestChanges(t *testing.T) {
+	tests := []struct {
+		since int64
+		ids   []string
+		seqs  []int64
+	}{
+		{0, []string{"c", "a", "b", "e", "d", "f", "h", "g"}, []int64{1, 2, 3, 4, 5, 6, 7, 8}},
+		{3, []string{"e", "d", "f", "h", "g"}, []int64{4, 5, 6, 7, 8}},
+		{4, []string{"d", "f", "h", "g"}, []int64{5, 6, 7, 8}},
+		{8, nil, nil},
+	}
+	db := openTest(t)
+	for _, tt := range tests {
+		ids, seqs := iteratorIDs(t, db.Changes(tt.since))
+		if !reflect.DeepEqual(ids, tt.ids) || !reflect.DeepEqual(seqs, tt.seqs) {
+			t.Errorf("since %d: got %v %v, want %v %v", tt.since, ids, seqs, tt.ids, tt.seqs)
+		}
+	}
+}
+
+func TestOpenEmptyDatabase(t *testing.T) {
+	db, err := Open(couchtest.File(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	info, err := db.Info()
+	if err != nil || info.DocCount != 0 || info.UpdateSeq != 0 {
+		t.Errorf("got %+v, %v", info, err)
+	}
+	if _, err = db.Get("a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v, want ErrNotFound", err)
+	}
+	if ids, _ := iteratorIDs(t, db.Documents()); len(ids) != 0 {
+		t.Errorf("got %v in empty database", ids)
+	}
+}